@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net"
+	"net/http"
 	"time"
 
 	"app/api/proto"
 	"app/internal/api/file"
+	"app/internal/api/gateway"
+	"app/internal/api/ratelimit"
 	"app/internal/config"
 	postgresqlClient "app/pkg/client/postgresql"
 	"app/pkg/logging"
@@ -20,29 +24,143 @@ func main() {
 	cfg := config.GetConfig()
 	logger := logging.NewLogger()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	postgreSQLClient, err := postgresqlClient.NewClient(logger, ctx, 4, cfg.Postgres.Host, cfg.Postgres.Port, cfg.Postgres.Username, cfg.Postgres.Password, cfg.Postgres.Database)
+	postgreSQLClient, err := postgresqlClient.NewClient(logger, context.Background(), cfg.PostgresClient())
 	if err != nil {
 		log.Fatalf("failed to connect to PostgreSQL: %v", err)
 	}
 
-	fileRepository := file.NewRepository(logger, postgreSQLClient)
-	startGRPCServer(logger, cfg, fileRepository)
+	var replicas []postgresqlClient.Replica
+	for _, replicaCfg := range cfg.PostgresReplicas() {
+		pool, err := postgresqlClient.NewClient(logger, context.Background(), replicaCfg)
+		if err != nil {
+			log.Fatalf("failed to configure PostgreSQL replica %s: %v", replicaCfg.Host, err)
+		}
+		replicas = append(replicas, postgresqlClient.Replica{Name: replicaCfg.Host + ":" + replicaCfg.Port, Client: pool})
+	}
+	// повторы только на основном сервере: при отказе реплики чтение переходит на него
+	cluster := postgresqlClient.NewCluster(logger, postgresqlClient.WithRetry(logger, postgreSQLClient, cfg.PostgresQueryRetry()), replicas, cfg.Postgres.MaxReplicationLag)
+	go cluster.Run(context.Background(), cfg.Postgres.ReplicaCheckInterval)
+
+	fileRepository := file.NewRepository(logger, cluster)
+	go file.RunVersionPruning(context.Background(), logger, fileRepository, cfg.Versioning.KeepLast, cfg.Versioning.KeepDays, cfg.Versioning.PruneInterval)
+	go file.RunIdempotencyKeyCleanup(context.Background(), logger, fileRepository, cfg.Idempotency.TTL, cfg.Idempotency.CleanupInterval)
+	go file.RunUploadSessionCleanup(context.Background(), logger, fileRepository, cfg.UploadSessions.CleanupInterval)
+	go file.RunDownloadLinkCleanup(context.Background(), logger, fileRepository, cfg.DownloadLinks.CleanupInterval)
+	go file.RunFileEventCleanup(context.Background(), logger, fileRepository, cfg.FileEvents.Retention, cfg.FileEvents.CleanupInterval)
+
+	srv := file.NewServer(logger, fileRepository)
+	srv.IdempotencyTTL = cfg.Idempotency.TTL
+	srv.UploadSessionTTL = cfg.UploadSessions.TTL
+	srv.MaxUploadSessionSize = cfg.UploadSessions.MaxSize
+	srv.MaxBatchSize = cfg.Batch.MaxSize
+	srv.MaxArchiveSize = cfg.Batch.MaxArchiveSize
+	srv.LinkBaseURL = cfg.DownloadLinks.BaseURL
+	srv.DefaultLinkTTL = cfg.DownloadLinks.DefaultTTL
+	srv.MaxLinkTTL = cfg.DownloadLinks.MaxTTL
+	srv.AdminToken = cfg.Admin.Token
+	srv.BucketTokens = cfg.Buckets.Tokens
+	srv.OwnerTokens = cfg.Owners.Tokens
+	srv.WatchPollInterval = cfg.FileEvents.PollInterval
+	srv.WatchHeartbeatInterval = cfg.FileEvents.HeartbeatInterval
+	// уведомления об изменениях приходят от всех экземпляров сервиса, работающих с базой
+	go postgresqlClient.Listen(context.Background(), logger, postgreSQLClient, file.FileEventsChannel, cfg.FileEvents.PollInterval, srv.Events.Notify, srv.Events.NotifyAll)
+	aging := cfg.Concurrency.PriorityAging
+	srv.UploadSemaphore = ratelimit.NewFairQueue(cfg.Concurrency.UploadRequests, cfg.Concurrency.UploadBytes, aging)
+	srv.DownloadSemaphore = ratelimit.NewFairQueue(cfg.Concurrency.DownloadRequests, cfg.Concurrency.DownloadBytes, aging)
+	srv.ListSemaphore = ratelimit.NewFairQueue(cfg.Concurrency.ListRequests, 0, aging)
+	expvar.Publish("request_queue_depth", expvar.Func(func() any { return srv.QueueDepth() }))
+	srv.UploadRateLimit = newRateLimit(cfg.RateLimits.Upload)
+	srv.DownloadRateLimit = newRateLimit(cfg.RateLimits.Download)
+	srv.ListRateLimit = newRateLimit(cfg.RateLimits.List)
+	if cfg.DownloadLinks.ActiveKey != "" {
+		srv.LinkSigner, err = file.NewLinkSigner(cfg.DownloadLinks.ActiveKey, cfg.DownloadLinks.Keys)
+		if err != nil {
+			log.Fatalf("invalid download link keys: %v", err)
+		}
+	}
+
+	// HTTP-шлюз вызывает тот же srv, поэтому семафоры и лимиты общие для обоих протоколов
+	go startHTTPServer(logger, cfg, srv)
+	go startDebugServer(cfg)
+	startGRPCServer(cfg, srv)
+}
+
+func newRateLimit(cfg config.RateLimit) file.RateLimit {
+	return file.RateLimit{
+		Requests: ratelimit.NewLimiter(cfg.RequestsPerSecond, cfg.RequestsBurst),
+		Bytes:    ratelimit.NewLimiter(cfg.BytesPerSecond, cfg.BytesBurst),
+	}
+}
+
+func startHTTPServer(logger *logging.Logger, cfg *config.Config, srv *file.Server) {
+	addr := cfg.Listen.HTTP.Host + ":" + cfg.Listen.HTTP.Port
+	mux := http.NewServeMux()
+	mux.Handle("/", withDBSession(gateway.NewHandler(logger, srv, cfg.Listen.HTTP.MaxUploadSize)))
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Println("HTTP gateway is running on port :" + cfg.Listen.HTTP.Port)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve HTTP: %v", err)
+	}
+}
+
+// startDebugServer отдает метрики, в том числе глубину очередей request_queue_depth, на
+// отдельном адресе: в них есть командная строка процесса и статистика памяти.
+func startDebugServer(cfg *config.Config) {
+	if cfg.Listen.Debug.Port == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	debugServer := &http.Server{
+		Addr:              cfg.Listen.Debug.Host + ":" + cfg.Listen.Debug.Port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Println("Debug server is running on " + debugServer.Addr)
+	if err := debugServer.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve debug HTTP: %v", err)
+	}
+}
+
+// withDBSession и перехватчики gRPC начинают сеанс на каждый запрос, чтобы чтения
+// после записи в том же запросе шли на основной сервер, а не на реплику.
+func withDBSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(postgresqlClient.WithSession(r.Context())))
+	})
 }
 
-func startGRPCServer(logger *logging.Logger, cfg *config.Config, fileRepository file.FileRepository) {
+type sessionStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *sessionStream) Context() context.Context { return s.ctx }
+
+func startGRPCServer(cfg *config.Config, srv *file.Server) {
 	lis, err := net.Listen("tcp", cfg.Listen.GRPC.Host+":"+cfg.Listen.GRPC.Port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			return handler(postgresqlClient.WithSession(ctx), req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, &sessionStream{ServerStream: ss, ctx: postgresqlClient.WithSession(ss.Context())})
+		}),
+	)
 	if *cfg.IsDebug {
 		reflection.Register(grpcServer)
 	}
-	srv := file.NewServer(logger, fileRepository)
 	proto.RegisterFileServiceServer(grpcServer, srv)
 
 	log.Println("gRPC server is running on port :" + cfg.Listen.GRPC.Port)
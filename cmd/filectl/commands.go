@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	pb "app/api/proto"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func runUpload(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	name := flags.String("name", "", "File name on the server, required for stdin")
+	chunkSize := flags.Int("chunk-size", 1<<20, "Files larger than this are uploaded in chunks with progress")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("upload: no files given")
+	}
+
+	var paths []string
+	for _, arg := range flags.Args() {
+		if arg == "-" {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("upload: no files match %s", arg)
+		}
+		paths = append(paths, matches...)
+	}
+	if *name != "" && len(paths) > 1 {
+		return errors.New("upload: -name can be used with a single file only")
+	}
+
+	for _, p := range paths {
+		var data []byte
+		var err error
+		fileName := *name
+		if p == "-" {
+			if fileName == "" {
+				return errors.New("upload: -name is required for stdin")
+			}
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			if fileName == "" {
+				fileName = filepath.Base(p)
+			}
+			data, err = os.ReadFile(p)
+		}
+		if err != nil {
+			return err
+		}
+
+		var id string
+		if len(data) > *chunkSize {
+			id, err = c.uploadChunked(ctx, fileName, data, *chunkSize)
+		} else {
+			id, err = c.upload(ctx, fileName, data)
+		}
+		if err != nil {
+			return fmt.Errorf("upload %s: %w", p, err)
+		}
+		fmt.Printf("%s\t%s\n", id, fileName)
+	}
+
+	return nil
+}
+
+func (c *cli) upload(ctx context.Context, name string, data []byte) (string, error) {
+	ctx, cancel := c.rpcContext(ctx)
+	defer cancel()
+
+	res, err := c.client.UploadFile(ctx, &pb.UploadFileRequest{FileName: name, Data: data})
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+func (c *cli) uploadChunked(ctx context.Context, name string, data []byte, chunkSize int) (string, error) {
+	sum := sha256.Sum256(data)
+
+	rpcCtx, cancel := c.rpcContext(ctx)
+	session, err := c.client.CreateUploadSession(rpcCtx, &pb.CreateUploadSessionRequest{FileName: name, TotalSize: int64(len(data)), Sha256: hex.EncodeToString(sum[:])})
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	bar := newProgress(name, int64(len(data)), c.quiet)
+	defer bar.Finish()
+
+	for offset := 0; offset < len(data); offset += chunkSize {
+		end := min(offset+chunkSize, len(data))
+
+		rpcCtx, cancel := c.rpcContext(ctx)
+		_, err = c.client.UploadChunk(rpcCtx, &pb.UploadChunkRequest{SessionId: session.SessionId, Offset: int64(offset), Data: data[offset:end]})
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		bar.Add(int64(end - offset))
+	}
+
+	rpcCtx, cancel = c.rpcContext(ctx)
+	defer cancel()
+
+	res, err := c.client.CompleteUploadSession(rpcCtx, &pb.CompleteUploadSessionRequest{SessionId: session.SessionId})
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+func runDownload(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	out := flags.String("o", ".", "Output file, directory, or - for stdout")
+	flags.Parse(args)
+
+	targets, err := c.resolve(ctx, flags.Args())
+	if err != nil {
+		return err
+	}
+
+	info, statErr := os.Stat(*out)
+	toDir := statErr == nil && info.IsDir()
+	if len(targets) > 1 && !toDir {
+		return fmt.Errorf("download: %s must be an existing directory for multiple files", *out)
+	}
+
+	// имена файлов разных папок совпадают: второй файл не должен молча заменить первый.
+	// Имена найденных по шаблону файлов проверяются до скачивания, заданных ID - перед записью
+	names := map[string]string{}
+	for _, t := range targets {
+		if toDir && t.Name != "" {
+			if err = claimName(names, t.Name, t.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	bar := newProgress("download", int64(len(targets)), c.quiet || *out == "-")
+	defer bar.Finish()
+
+	for _, t := range targets {
+		rpcCtx, cancel := c.rpcContext(ctx)
+		res, err := c.client.DownloadFile(rpcCtx, &pb.DownloadFileRequest{Id: t.Id})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("download %s: %w", t.Id, err)
+		}
+
+		switch {
+		case *out == "-":
+			_, err = os.Stdout.Write(res.Data)
+		case toDir:
+			if err = claimName(names, res.FileName, t.Id); err != nil {
+				return err
+			}
+			err = os.WriteFile(filepath.Join(*out, filepath.Base(res.FileName)), res.Data, 0644)
+		default:
+			err = os.WriteFile(*out, res.Data, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		bar.Add(1)
+	}
+
+	return nil
+}
+
+// claimName закрепляет имя в каталоге загрузки за файлом id.
+func claimName(names map[string]string, name, id string) error {
+	name = filepath.Base(name)
+	if other, ok := names[name]; ok && other != id {
+		return fmt.Errorf("download: files %s and %s have the same name %s, download them separately", other, id, name)
+	}
+	names[name] = id
+	return nil
+}
+
+func runList(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	format := flags.String("o", "table", "Output format: table or json")
+	flags.Parse(args)
+
+	files, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		files, err = matchFiles(files, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	}
+
+	rows := make([]fileRow, 0, len(files))
+	for _, f := range files {
+		rows = append(rows, fileRow{ID: f.Id, Name: f.Name, Size: f.Size, ETag: f.Etag, CreatedAt: time.Unix(f.CreatedAt, 0), UpdatedAt: time.Unix(f.UpdatedAt, 0)})
+	}
+
+	return printRows(rows, *format)
+}
+
+func runRemove(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ExitOnError)
+	ifMatch := flags.String("if-match", "", "Delete only if the file etag matches")
+	flags.Parse(args)
+
+	targets, err := c.resolve(ctx, flags.Args())
+	if err != nil {
+		return err
+	}
+	if *ifMatch != "" && len(targets) > 1 {
+		return errors.New("rm: -if-match can be used with a single file only")
+	}
+
+	for _, t := range targets {
+		rpcCtx, cancel := c.rpcContext(ctx)
+		_, err = c.client.DeleteFile(rpcCtx, &pb.DeleteFileRequest{Id: t.Id, IfMatch: *ifMatch})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("rm %s: %w", t.Id, err)
+		}
+		fmt.Printf("%s\t%s\n", t.Id, t.Name)
+	}
+
+	return nil
+}
+
+func runMove(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("mv", flag.ExitOnError)
+	ifMatch := flags.String("if-match", "", "Rename only if the file etag matches")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return errors.New("mv: expected <id> <new name>")
+	}
+
+	ctx, cancel := c.rpcContext(ctx)
+	defer cancel()
+
+	res, err := c.client.UpdateFile(ctx, &pb.UpdateFileRequest{Id: flags.Arg(0), FileName: flags.Arg(1), IfMatch: *ifMatch})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\t%s\tetag %s\n", flags.Arg(0), flags.Arg(1), res.Etag)
+	return nil
+}
+
+func runStat(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("stat", flag.ExitOnError)
+	format := flags.String("o", "table", "Output format: table or json")
+	flags.Parse(args)
+
+	targets, err := c.resolve(ctx, flags.Args())
+	if err != nil {
+		return err
+	}
+
+	// метаданные берутся из списка файлов, а номер версии - из списка версий,
+	// чтобы не скачивать содержимое
+	var byID map[string]*pb.FileMetadata
+	rows := make([]fileRow, 0, len(targets))
+	for _, t := range targets {
+		if t.Name == "" {
+			if byID == nil {
+				files, err := c.list(ctx)
+				if err != nil {
+					return err
+				}
+				byID = make(map[string]*pb.FileMetadata, len(files))
+				for _, f := range files {
+					byID[f.Id] = f
+				}
+			}
+			f, ok := byID[t.Id]
+			if !ok {
+				return fmt.Errorf("stat %s: file not found", t.Id)
+			}
+			t = f
+		}
+
+		rpcCtx, cancel := c.rpcContext(ctx)
+		res, err := c.client.ListFileVersions(rpcCtx, &pb.ListFileVersionsRequest{Id: t.Id})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", t.Id, err)
+		}
+
+		row := fileRow{ID: t.Id, Name: t.Name, Size: t.Size, ETag: t.Etag, CreatedAt: time.Unix(t.CreatedAt, 0), UpdatedAt: time.Unix(t.UpdatedAt, 0)}
+		// версии упорядочены от новой к старой
+		if len(res.Versions) > 0 {
+			row.Version = res.Versions[0].Version
+		}
+		rows = append(rows, row)
+	}
+
+	return printRows(rows, *format)
+}
+
+func (c *cli) list(ctx context.Context) ([]*pb.FileMetadata, error) {
+	ctx, cancel := c.rpcContext(ctx)
+	defer cancel()
+
+	res, err := c.client.ListFiles(ctx, &pb.ListFilesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Files, nil
+}
+
+// resolve превращает аргументы в список файлов: ID используются как есть,
+// остальные аргументы считаются шаблонами имен и сопоставляются со списком файлов.
+func (c *cli) resolve(ctx context.Context, args []string) ([]*pb.FileMetadata, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no files given")
+	}
+
+	var files []*pb.FileMetadata
+	var targets []*pb.FileMetadata
+	for _, arg := range args {
+		if uuidRegexp.MatchString(arg) {
+			targets = append(targets, &pb.FileMetadata{Id: arg})
+			continue
+		}
+
+		if files == nil {
+			var err error
+			if files, err = c.list(ctx); err != nil {
+				return nil, err
+			}
+		}
+		matched, err := matchFiles(files, arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no files match %s", arg)
+		}
+		targets = append(targets, matched...)
+	}
+
+	return targets, nil
+}
+
+func matchFiles(files []*pb.FileMetadata, pattern string) ([]*pb.FileMetadata, error) {
+	var matched []*pb.FileMetadata
+	for _, f := range files {
+		ok, err := path.Match(pattern, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, f)
+		}
+	}
+	return matched, nil
+}
+
+type fileRow struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Version   int32     `json:"version,omitempty"`
+	ETag      string    `json:"etag"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func printRows(rows []fileRow, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSIZE\tETAG\tUPDATED")
+		for _, r := range rows {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", r.ID, r.Name, r.Size, r.ETag, r.UpdatedAt.Format(time.DateTime))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "app/api/proto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	idA = "00000000-0000-0000-0000-00000000000a"
+	idB = "00000000-0000-0000-0000-00000000000b"
+	idC = "00000000-0000-0000-0000-00000000000c"
+)
+
+// fakeClient отвечает из files; не реализованные методы вызывают панику
+// встроенного nil-интерфейса, поэтому лишние запросы видны в тесте.
+type fakeClient struct {
+	pb.FileServiceClient
+	mu        sync.Mutex
+	files     []*pb.FileMetadata
+	data      map[string][]byte
+	versions  map[string][]*pb.FileVersion
+	downloads []string
+}
+
+func (f *fakeClient) ListFiles(context.Context, *pb.ListFilesRequest, ...grpc.CallOption) (*pb.ListFilesResponse, error) {
+	return &pb.ListFilesResponse{Files: f.files}, nil
+}
+
+func (f *fakeClient) ListFileVersions(_ context.Context, req *pb.ListFileVersionsRequest, _ ...grpc.CallOption) (*pb.ListFileVersionsResponse, error) {
+	versions, ok := f.versions[req.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+	return &pb.ListFileVersionsResponse{Versions: versions}, nil
+}
+
+func (f *fakeClient) DownloadFile(_ context.Context, req *pb.DownloadFileRequest, _ ...grpc.CallOption) (*pb.DownloadFileResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.downloads = append(f.downloads, req.Id)
+	for _, fl := range f.files {
+		if fl.Id == req.Id {
+			return &pb.DownloadFileResponse{FileName: fl.Name, Data: f.data[fl.Id]}, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+}
+
+func newTestCLI() (*cli, *fakeClient) {
+	fake := &fakeClient{
+		files: []*pb.FileMetadata{
+			{Id: idA, Name: "a.txt", Size: 5, Etag: "3", CreatedAt: 100, UpdatedAt: 200},
+			{Id: idB, Name: "b.txt", Size: 6, Etag: "1", CreatedAt: 100, UpdatedAt: 100},
+			// тот же a.txt в другой папке
+			{Id: idC, Name: "a.txt", Size: 4, Etag: "1", FolderId: "f1", CreatedAt: 100, UpdatedAt: 100},
+		},
+		data: map[string][]byte{idA: []byte("first"), idB: []byte("second"), idC: []byte("copy")},
+		versions: map[string][]*pb.FileVersion{
+			idA: {{Version: 3, Name: "a.txt", Size: 5}, {Version: 2, Name: "a.txt", Size: 2}},
+			idB: {{Version: 1, Name: "b.txt", Size: 6}},
+		},
+	}
+	return &cli{client: fake, cfg: &clientConfig{Timeout: time.Second}, quiet: true}, fake
+}
+
+// captureStdout возвращает то, что fn вывела в stdout.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+
+	err = fn()
+	w.Close()
+	return <-out, err
+}
+
+func TestStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []fileRow
+		wantErr string
+	}{
+		{
+			name: "ByID",
+			args: []string{"-o", "json", idA},
+			want: []fileRow{{ID: idA, Name: "a.txt", Size: 5, Version: 3, ETag: "3", CreatedAt: time.Unix(100, 0), UpdatedAt: time.Unix(200, 0)}},
+		},
+		{
+			name: "ByPattern",
+			args: []string{"-o", "json", "b.*"},
+			want: []fileRow{{ID: idB, Name: "b.txt", Size: 6, Version: 1, ETag: "1", CreatedAt: time.Unix(100, 0), UpdatedAt: time.Unix(100, 0)}},
+		},
+		{name: "UnknownID", args: []string{"00000000-0000-0000-0000-0000000000ff"}, wantErr: "file not found"},
+		{name: "NoVersions", args: []string{idC}, wantErr: "NotFound"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, fake := newTestCLI()
+
+			out, err := captureStdout(t, func() error { return runStat(context.Background(), c, tt.args) })
+			// stat не скачивает содержимое
+			assert.Empty(t, fake.downloads)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var rows []fileRow
+			require.NoError(t, json.Unmarshal([]byte(out), &rows))
+			assert.Equal(t, len(tt.want), len(rows))
+			for i := range tt.want {
+				assert.Equal(t, tt.want[i].ID, rows[i].ID)
+				assert.Equal(t, tt.want[i].Name, rows[i].Name)
+				assert.Equal(t, tt.want[i].Size, rows[i].Size)
+				assert.Equal(t, tt.want[i].Version, rows[i].Version)
+				assert.Equal(t, tt.want[i].ETag, rows[i].ETag)
+				assert.True(t, tt.want[i].UpdatedAt.Equal(rows[i].UpdatedAt))
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	t.Run("ToDir", func(t *testing.T) {
+		c, _ := newTestCLI()
+		dir := t.TempDir()
+
+		require.NoError(t, runDownload(context.Background(), c, []string{"-o", dir, idA, "b.txt"}))
+		data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(data))
+		data, err = os.ReadFile(filepath.Join(dir, "b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(data))
+	})
+
+	t.Run("ToFile", func(t *testing.T) {
+		c, _ := newTestCLI()
+		out := filepath.Join(t.TempDir(), "renamed.txt")
+
+		require.NoError(t, runDownload(context.Background(), c, []string{"-o", out, idB}))
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(data))
+	})
+
+	t.Run("ManyToFile", func(t *testing.T) {
+		c, fake := newTestCLI()
+
+		err := runDownload(context.Background(), c, []string{"-o", filepath.Join(t.TempDir(), "x"), idA, idB})
+		assert.ErrorContains(t, err, "must be an existing directory")
+		assert.Empty(t, fake.downloads)
+	})
+
+	t.Run("PatternNameCollision", func(t *testing.T) {
+		c, fake := newTestCLI()
+		dir := t.TempDir()
+
+		// оба a.txt известны по списку - ничего не скачивается
+		err := runDownload(context.Background(), c, []string{"-o", dir, "a.*"})
+		assert.ErrorContains(t, err, "have the same name a.txt")
+		assert.Empty(t, fake.downloads)
+	})
+
+	t.Run("IDNameCollision", func(t *testing.T) {
+		c, _ := newTestCLI()
+		dir := t.TempDir()
+
+		// имена файлов, заданных ID, известны только после скачивания
+		err := runDownload(context.Background(), c, []string{"-o", dir, idA, idC})
+		assert.ErrorContains(t, err, "have the same name a.txt")
+		data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(data))
+	})
+
+	t.Run("SameFileTwice", func(t *testing.T) {
+		c, _ := newTestCLI()
+
+		assert.NoError(t, runDownload(context.Background(), c, []string{"-o", t.TempDir(), idB, "b.txt"}))
+	})
+}
+
+func TestResolve(t *testing.T) {
+	c, _ := newTestCLI()
+
+	tests := []struct {
+		name    string
+		args    []string
+		ids     []string
+		wantErr string
+	}{
+		{name: "ID", args: []string{idB}, ids: []string{idB}},
+		{name: "Pattern", args: []string{"*.txt"}, ids: []string{idA, idB, idC}},
+		{name: "Mixed", args: []string{idB, "a.txt"}, ids: []string{idB, idA, idC}},
+		{name: "NoMatch", args: []string{"*.jpg"}, wantErr: "no files match *.jpg"},
+		{name: "BadPattern", args: []string{"[a"}, wantErr: "syntax error"},
+		{name: "Empty", wantErr: "no files given"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := c.resolve(context.Background(), tt.args)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(targets))
+			for _, f := range targets {
+				ids = append(ids, f.Id)
+			}
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+}
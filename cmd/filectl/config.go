@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Настройки подключения читаются из файла конфигурации, затем из переменных
+// окружения FILECTL_*, затем из флагов; каждый следующий источник важнее предыдущего.
+type clientConfig struct {
+	Addr    string        `yaml:"addr" env:"FILECTL_ADDR" env-default:"localhost:50051"`
+	Token   string        `yaml:"token" env:"FILECTL_TOKEN"`
+	Timeout time.Duration `yaml:"timeout" env:"FILECTL_TIMEOUT" env-default:"1m"`
+
+	TLS struct {
+		Enabled            bool   `yaml:"enabled" env:"FILECTL_TLS"`
+		CAFile             string `yaml:"ca_file" env:"FILECTL_TLS_CA"`
+		CertFile           string `yaml:"cert_file" env:"FILECTL_TLS_CERT"`
+		KeyFile            string `yaml:"key_file" env:"FILECTL_TLS_KEY"`
+		ServerName         string `yaml:"server_name" env:"FILECTL_TLS_SERVER_NAME"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"FILECTL_TLS_INSECURE_SKIP_VERIFY"`
+	} `yaml:"tls"`
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("FILECTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "filectl", "config.yaml")
+}
+
+func loadConfig(path string, flags *flag.FlagSet) (*clientConfig, error) {
+	cfg := &clientConfig{}
+
+	_, statErr := os.Stat(path)
+	switch {
+	case path != "" && statErr == nil:
+		if err := cleanenv.ReadConfig(path, cfg); err != nil {
+			return nil, fmt.Errorf("read config %s: %w", path, err)
+		}
+	case path != "" && !errors.Is(statErr, fs.ErrNotExist):
+		return nil, statErr
+	default:
+		if err := cleanenv.ReadEnv(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = f.Value.String()
+		case "token":
+			cfg.Token = f.Value.String()
+		case "timeout":
+			cfg.Timeout = f.Value.(flag.Getter).Get().(time.Duration)
+		case "tls":
+			cfg.TLS.Enabled = f.Value.(flag.Getter).Get().(bool)
+		case "tls-ca":
+			cfg.TLS.CAFile = f.Value.String()
+		case "tls-cert":
+			cfg.TLS.CertFile = f.Value.String()
+		case "tls-key":
+			cfg.TLS.KeyFile = f.Value.String()
+		case "tls-server-name":
+			cfg.TLS.ServerName = f.Value.String()
+		case "tls-insecure-skip-verify":
+			cfg.TLS.InsecureSkipVerify = f.Value.(flag.Getter).Get().(bool)
+		}
+	})
+
+	return cfg, nil
+}
+
+func registerConnectionFlags(flags *flag.FlagSet) *string {
+	configPath := flags.String("config", defaultConfigPath(), "Config file (env FILECTL_CONFIG)")
+	flags.String("addr", "localhost:50051", "FileService address (env FILECTL_ADDR)")
+	flags.String("token", "", "Bearer token sent in the authorization metadata (env FILECTL_TOKEN)")
+	flags.Duration("timeout", time.Minute, "Timeout of a single RPC (env FILECTL_TIMEOUT)")
+	flags.Bool("tls", false, "Use TLS (env FILECTL_TLS)")
+	flags.String("tls-ca", "", "CA certificate file (env FILECTL_TLS_CA)")
+	flags.String("tls-cert", "", "Client certificate file for mTLS (env FILECTL_TLS_CERT)")
+	flags.String("tls-key", "", "Client key file for mTLS (env FILECTL_TLS_KEY)")
+	flags.String("tls-server-name", "", "Override TLS server name (env FILECTL_TLS_SERVER_NAME)")
+	flags.Bool("tls-insecure-skip-verify", false, "Do not verify the server certificate (env FILECTL_TLS_INSECURE_SKIP_VERIFY)")
+	return configPath
+}
+
+func dial(cfg *clientConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.TLS.Enabled {
+		tlsCfg, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if cfg.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: cfg.Token, secure: cfg.TLS.Enabled}))
+	}
+
+	return grpc.NewClient(cfg.Addr, opts...)
+}
+
+func tlsConfig(cfg *clientConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.TLS.ServerName,
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+	}
+
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+// Токен разрешено передавать без TLS, чтобы работать с локальным сервером.
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
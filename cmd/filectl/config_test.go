@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("addr: file:50051\ntoken: file-token\ntimeout: 10s\n"), 0644))
+
+	tests := []struct {
+		name  string
+		path  string
+		env   map[string]string
+		args  []string
+		addr  string
+		token string
+		want  time.Duration
+	}{
+		{name: "Defaults", path: filepath.Join(t.TempDir(), "missing.yaml"), addr: "localhost:50051", want: time.Minute},
+		{name: "File", path: path, addr: "file:50051", token: "file-token", want: 10 * time.Second},
+		{name: "EnvOverFile", path: path, env: map[string]string{"FILECTL_TOKEN": "env-token"}, addr: "file:50051", token: "env-token", want: 10 * time.Second},
+		{
+			name:  "FlagsOverEnv",
+			path:  path,
+			env:   map[string]string{"FILECTL_ADDR": "env:50051"},
+			args:  []string{"-addr", "flag:50051", "-timeout", "5s"},
+			addr:  "flag:50051",
+			token: "file-token",
+			want:  5 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			flags := flag.NewFlagSet("filectl", flag.ContinueOnError)
+			registerConnectionFlags(flags)
+			require.NoError(t, flags.Parse(tt.args))
+
+			cfg, err := loadConfig(tt.path, flags)
+			require.NoError(t, err)
+			assert.Equal(t, tt.addr, cfg.Addr)
+			assert.Equal(t, tt.token, cfg.Token)
+			assert.Equal(t, tt.want, cfg.Timeout)
+		})
+	}
+}
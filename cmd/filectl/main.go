@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	pb "app/api/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+const usage = `Usage: filectl [connection flags] <command> [command flags] [args]
+
+Commands:
+  upload   [-name N] [-chunk-size B] <file|glob|->...   upload files, "-" reads stdin
+  download [-o path|dir|-] <id|name glob>...           download files, "-o -" writes to stdout
+  ls       [-o table|json] [name glob]                 list files
+  rm       [-if-match etag] <id|name glob>...          delete files
+  mv       [-if-match etag] <id> <new name>            rename a file
+  stat     [-o table|json] <id|name glob>...           show file metadata
+
+Name globs use path.Match syntax and are resolved with ls.
+
+Connection flags:
+`
+
+type command func(ctx context.Context, cli *cli, args []string) error
+
+var commands = map[string]command{
+	"upload":   runUpload,
+	"download": runDownload,
+	"ls":       runList,
+	"rm":       runRemove,
+	"mv":       runMove,
+	"stat":     runStat,
+}
+
+type cli struct {
+	client pb.FileServiceClient
+	cfg    *clientConfig
+	quiet  bool
+}
+
+func main() {
+	flags := flag.NewFlagSet("filectl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	configPath := registerConnectionFlags(flags)
+	quiet := flags.Bool("quiet", false, "Do not show progress")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath, flags)
+	if err != nil {
+		fatal(err)
+	}
+
+	conn, err := dial(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &cli{client: pb.NewFileServiceClient(conn), cfg: cfg, quiet: *quiet}
+	if err = run(ctx, c, flags.Args()[1:]); err != nil {
+		stop()
+		conn.Close()
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	if s, ok := status.FromError(err); ok {
+		fmt.Fprintf(os.Stderr, "filectl: %s: %s\n", s.Code(), s.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "filectl: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// rpcContext ограничивает один вызов таймаутом из настроек.
+func (c *cli) rpcContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.cfg.Timeout)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const progressWidth = 30
+
+// progress рисует строку вида "[=====>      ]  42% name" в stderr,
+// только если stderr - терминал, чтобы не засорять перенаправленный вывод.
+type progress struct {
+	w       io.Writer
+	label   string
+	total   int64
+	done    int64
+	enabled bool
+}
+
+func newProgress(label string, total int64, quiet bool) *progress {
+	p := &progress{w: os.Stderr, label: label, total: total}
+	if info, err := os.Stderr.Stat(); err == nil && !quiet {
+		p.enabled = info.Mode()&os.ModeCharDevice != 0
+	}
+	p.draw()
+	return p
+}
+
+func (p *progress) Add(n int64) {
+	p.done += n
+	p.draw()
+}
+
+func (p *progress) Finish() {
+	if p.enabled {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *progress) draw() {
+	if !p.enabled || p.total <= 0 {
+		return
+	}
+
+	ratio := float64(min(p.done, p.total)) / float64(p.total)
+	filled := int(ratio * progressWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	fmt.Fprintf(p.w, "\r[%s] %3d%% %s", bar, int(ratio*100), p.label)
+}
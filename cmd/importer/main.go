@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pb "app/api/proto"
+	"app/pkg/retry"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const maxFileNameLength = 100
+
+type reportEntry struct {
+	Path   string `json:"path"`
+	ID     string `json:"id,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type report struct {
+	mu       sync.Mutex
+	Imported []reportEntry `json:"imported"`
+	Skipped  []reportEntry `json:"skipped"`
+	Failed   []reportEntry `json:"failed"`
+}
+
+func (r *report) add(list *[]reportEntry, e reportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*list = append(*list, e)
+}
+
+type importer struct {
+	client    pb.FileServiceClient
+	state     *state
+	report    *report
+	retries   int
+	delay     time.Duration
+	timeout   time.Duration
+	chunkSize int
+	verify    bool
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:50051", "FileService gRPC address")
+	source := flag.String("source", "", "Directory, .zip, .tar, .tar.gz or .tgz to import")
+	parallel := flag.Int("parallel", 4, "Number of concurrent uploads")
+	retries := flag.Int("retries", 3, "Attempts per file")
+	delay := flag.Duration("retry-delay", 2*time.Second, "Base delay between attempts; grows exponentially with random jitter")
+	timeout := flag.Duration("timeout", time.Minute, "Timeout of a single RPC; chunked uploads apply it to each chunk")
+	chunkSize := flag.Int("chunk-size", 1<<20, "Files larger than this are uploaded in chunks via upload sessions")
+	verify := flag.Bool("verify", true, "Download small files after upload and compare checksums")
+	statePath := flag.String("state", "import-state.json", "Resume state file, empty to disable")
+	reportPath := flag.String("report", "", "JSON report file, stdout if empty")
+	priority := flag.String("priority", "low", "Queue priority sent in x-priority metadata: low, normal or high (high needs -admin-token)")
+	adminToken := flag.String("admin-token", "", "Admin token sent in x-admin-token metadata")
+	bucket := flag.String("bucket", "", "Bucket sent in x-bucket metadata, server default if empty (other buckets need -admin-token)")
+	flag.Parse()
+
+	if *source == "" {
+		log.Fatalf("source is required")
+	}
+	if *parallel < 1 {
+		log.Fatalf("parallel must be positive")
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		log.Fatalf("failed to load state %s: %v", *statePath, err)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("failed to connect to %s: %v", *addr, err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// импорт - фоновая задача и по умолчанию не должен задерживать запросы пользователей
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-priority", *priority)
+	if *adminToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-admin-token", *adminToken)
+	}
+	if *bucket != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-bucket", *bucket)
+	}
+
+	imp := &importer{
+		client:    pb.NewFileServiceClient(conn),
+		state:     st,
+		report:    &report{Imported: []reportEntry{}, Skipped: []reportEntry{}, Failed: []reportEntry{}},
+		retries:   *retries,
+		delay:     *delay,
+		timeout:   *timeout,
+		chunkSize: *chunkSize,
+		verify:    *verify,
+	}
+
+	items := make(chan item, *parallel)
+	sourceErr := make(chan error, 1)
+	go func() {
+		sourceErr <- readSource(ctx, *source, items)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				imp.importItem(ctx, it)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = writeReport(*reportPath, imp.report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	log.Printf("imported: %d, skipped: %d, failed: %d", len(imp.report.Imported), len(imp.report.Skipped), len(imp.report.Failed))
+	if err = <-sourceErr; err != nil {
+		log.Fatalf("failed to read source: %v", err)
+	}
+	if len(imp.report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+func (imp *importer) importItem(ctx context.Context, it item) {
+	if it.Err != nil {
+		imp.report.add(&imp.report.Failed, reportEntry{Path: it.Path, Reason: it.Err.Error()})
+		return
+	}
+
+	sum := sha256.Sum256(it.Data)
+	checksum := hex.EncodeToString(sum[:])
+
+	if e, ok := imp.state.imported(it.Path, checksum); ok {
+		imp.report.add(&imp.report.Skipped, reportEntry{Path: it.Path, ID: e.ID, SHA256: checksum, Reason: "already imported"})
+		return
+	}
+
+	name := path.Base(it.Path)
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		imp.report.add(&imp.report.Failed, reportEntry{Path: it.Path, Reason: fmt.Sprintf("file name is longer than %d characters", maxFileNameLength)})
+		return
+	}
+
+	var id string
+	policy := retry.Policy{
+		MaxAttempts: imp.retries,
+		BaseDelay:   imp.delay,
+		MaxDelay:    8 * imp.delay,
+		Retryable:   retryableUpload,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			log.Printf("upload %s (attempt %d), retrying in %s: %v", it.Path, attempt, delay.Round(time.Millisecond), err)
+		},
+	}
+	err := retry.Do(ctx, policy, func(ctx context.Context) error {
+		var err error
+		if len(it.Data) > imp.chunkSize {
+			id, err = imp.uploadChunked(ctx, name, it.Data, checksum)
+		} else {
+			id, err = imp.upload(ctx, it.Path, name, it.Data, checksum)
+		}
+		return err
+	})
+	if err != nil {
+		imp.report.add(&imp.report.Failed, reportEntry{Path: it.Path, Size: len(it.Data), SHA256: checksum, Reason: err.Error()})
+		return
+	}
+
+	if err = imp.state.add(it.Path, importedEntry{ID: id, SHA256: checksum}); err != nil {
+		log.Printf("failed to save state for %s: %v", it.Path, err)
+	}
+	imp.report.add(&imp.report.Imported, reportEntry{Path: it.Path, ID: id, Size: len(it.Data), SHA256: checksum})
+}
+
+// retryableUpload не повторяет ошибки, которые повтор не исправит: некорректные данные,
+// отказ в доступе, превышение квоты.
+func retryableUpload(err error) bool {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument, codes.AlreadyExists, codes.PermissionDenied, codes.Unauthenticated,
+		codes.NotFound, codes.OutOfRange, codes.FailedPrecondition, codes.Unimplemented, codes.DataLoss:
+		return false
+	case codes.ResourceExhausted:
+		// превышение квоты приходит с QuotaFailure, лимит частоты - с RetryInfo
+		for _, detail := range st.Details() {
+			if _, ok := detail.(*errdetails.QuotaFailure); ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// upload отправляет файл одним запросом. Ключ идемпотентности строится из пути и
+// содержимого, поэтому повтор после таймаута не создает дубликат.
+func (imp *importer) upload(ctx context.Context, filePath, name string, data []byte, checksum string) (string, error) {
+	key := sha256.Sum256([]byte(filePath + "\x00" + checksum))
+	rpcCtx, cancel := context.WithTimeout(ctx, imp.timeout)
+	res, err := imp.client.UploadFile(rpcCtx, &pb.UploadFileRequest{
+		FileName:       name,
+		Data:           data,
+		IdempotencyKey: "import-" + hex.EncodeToString(key[:]),
+	})
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	if !imp.verify {
+		return res.Id, nil
+	}
+
+	rpcCtx, cancel = context.WithTimeout(ctx, imp.timeout)
+	fl, err := imp.client.DownloadFile(rpcCtx, &pb.DownloadFileRequest{Id: res.Id})
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("verify: %w", err)
+	}
+	if !bytes.Equal(fl.Data, data) {
+		return "", fmt.Errorf("verify: checksum mismatch for file %s", res.Id)
+	}
+
+	return res.Id, nil
+}
+
+// uploadChunked загружает большой файл через сессию; контрольную сумму проверяет сервер при завершении.
+// Таймаут действует на каждый запрос, а не на всю загрузку: иначе большой файл не успевал бы загрузиться.
+func (imp *importer) uploadChunked(ctx context.Context, name string, data []byte, checksum string) (string, error) {
+	rpcCtx, cancel := context.WithTimeout(ctx, imp.timeout)
+	session, err := imp.client.CreateUploadSession(rpcCtx, &pb.CreateUploadSessionRequest{FileName: name, TotalSize: int64(len(data)), Sha256: checksum})
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	for offset := 0; offset < len(data); offset += imp.chunkSize {
+		end := min(offset+imp.chunkSize, len(data))
+		rpcCtx, cancel = context.WithTimeout(ctx, imp.timeout)
+		_, err = imp.client.UploadChunk(rpcCtx, &pb.UploadChunkRequest{SessionId: session.SessionId, Offset: int64(offset), Data: data[offset:end]})
+		cancel()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	rpcCtx, cancel = context.WithTimeout(ctx, imp.timeout)
+	res, err := imp.client.CompleteUploadSession(rpcCtx, &pb.CompleteUploadSessionRequest{SessionId: session.SessionId})
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	return res.Id, nil
+}
+
+func writeReport(path string, r *report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "app/api/proto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// slowClient отвечает на каждый запрос загрузки через delay, если контекст запроса
+// к тому времени не истек.
+type slowClient struct {
+	pb.FileServiceClient
+	delay  time.Duration
+	chunks int
+}
+
+func (c *slowClient) wait(ctx context.Context) error {
+	select {
+	case <-time.After(c.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *slowClient) CreateUploadSession(ctx context.Context, _ *pb.CreateUploadSessionRequest, _ ...grpc.CallOption) (*pb.CreateUploadSessionResponse, error) {
+	return &pb.CreateUploadSessionResponse{SessionId: "s1"}, c.wait(ctx)
+}
+
+func (c *slowClient) UploadChunk(ctx context.Context, _ *pb.UploadChunkRequest, _ ...grpc.CallOption) (*pb.UploadChunkResponse, error) {
+	c.chunks++
+	return &pb.UploadChunkResponse{}, c.wait(ctx)
+}
+
+func (c *slowClient) CompleteUploadSession(ctx context.Context, _ *pb.CompleteUploadSessionRequest, _ ...grpc.CallOption) (*pb.CompleteUploadSessionResponse, error) {
+	return &pb.CompleteUploadSessionResponse{Id: "id1"}, c.wait(ctx)
+}
+
+func TestUploadChunkedTimeout(t *testing.T) {
+	t.Run("PerRequest", func(t *testing.T) {
+		// вся загрузка дольше таймаута, но каждый запрос укладывается в него
+		client := &slowClient{delay: 20 * time.Millisecond}
+		imp := &importer{client: client, timeout: 200 * time.Millisecond, chunkSize: 2}
+
+		id, err := imp.uploadChunked(context.Background(), "big.bin", make([]byte, 40), "sum")
+		require.NoError(t, err)
+		assert.Equal(t, "id1", id)
+		assert.Equal(t, 20, client.chunks)
+	})
+
+	t.Run("SlowChunk", func(t *testing.T) {
+		imp := &importer{client: &slowClient{delay: 50 * time.Millisecond}, timeout: 10 * time.Millisecond, chunkSize: 2}
+
+		_, err := imp.uploadChunked(context.Background(), "big.bin", make([]byte, 4), "sum")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
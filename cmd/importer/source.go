@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type item struct {
+	Path string
+	Data []byte
+	Err  error
+}
+
+// readSource перечисляет файлы каталога, ZIP- или TAR-архива (в том числе .tar.gz/.tgz)
+// и отправляет их в out по одному. Канал закрывается по завершении обхода.
+func readSource(ctx context.Context, source string, out chan<- item) error {
+	defer close(out)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	send := func(it item) error {
+		select {
+		case out <- it:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	lower := strings.ToLower(source)
+	switch {
+	case info.IsDir():
+		return readDir(source, send)
+	case strings.HasSuffix(lower, ".zip"):
+		return readZip(source, send)
+	case strings.HasSuffix(lower, ".tar"):
+		return readTar(source, false, send)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return readTar(source, true, send)
+	}
+
+	return fmt.Errorf("unsupported source %s: expected directory, .zip, .tar, .tar.gz or .tgz", source)
+}
+
+func readDir(root string, send func(item) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		return send(item{Path: filepath.ToSlash(rel), Data: data, Err: err})
+	})
+}
+
+func readZip(source string, send func(item) error) error {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := readZipFile(f)
+		if err = send(item{Path: f.Name, Data: data, Err: err}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func readTar(source string, gzipped bool, send func(item) error) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err = send(item{Path: hdr.Name, Data: data, Err: err}); err != nil {
+			return err
+		}
+	}
+}
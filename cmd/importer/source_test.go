@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sourceFiles = map[string]string{
+	"a.txt":          "first",
+	"docs/b.txt":     "second",
+	"docs/deep/c.md": "third",
+}
+
+func writeDirSource(t *testing.T) string {
+	root := filepath.Join(t.TempDir(), "src")
+	for name, data := range sourceFiles {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+	// символическая ссылка не является обычным файлом и пропускается
+	require.NoError(t, os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(root, "link.txt")))
+	return root
+}
+
+func writeZipSource(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "src.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.Create("docs/")
+	require.NoError(t, err)
+	for name, data := range sourceFiles {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func writeTarSource(t *testing.T, name string, gzipped bool) string {
+	path := filepath.Join(t.TempDir(), name)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link.txt", Typeflag: tar.TypeSymlink, Linkname: "a.txt"}))
+	for name, data := range sourceFiles {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	data := buf.Bytes()
+	if gzipped {
+		var gz bytes.Buffer
+		gw := gzip.NewWriter(&gz)
+		_, err := gw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		data = gz.Bytes()
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func collectSource(t *testing.T, source string) (map[string]string, error) {
+	out := make(chan item)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- readSource(context.Background(), source, out)
+	}()
+
+	got := map[string]string{}
+	for it := range out {
+		require.NoError(t, it.Err, it.Path)
+		got[it.Path] = string(it.Data)
+	}
+	return got, <-errc
+}
+
+func TestReadSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source func(t *testing.T) string
+	}{
+		{name: "Dir", source: writeDirSource},
+		{name: "Zip", source: writeZipSource},
+		{name: "Tar", source: func(t *testing.T) string { return writeTarSource(t, "src.tar", false) }},
+		{name: "TarGz", source: func(t *testing.T) string { return writeTarSource(t, "src.tar.gz", true) }},
+		{name: "Tgz", source: func(t *testing.T) string { return writeTarSource(t, "SRC.TGZ", true) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectSource(t, tt.source(t))
+			assert.NoError(t, err)
+			assert.Equal(t, sourceFiles, got)
+		})
+	}
+}
+
+func TestReadSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+	unsupported := filepath.Join(dir, "src.rar")
+	require.NoError(t, os.WriteFile(unsupported, []byte("data"), 0644))
+	corruptZip := filepath.Join(dir, "corrupt.zip")
+	require.NoError(t, os.WriteFile(corruptZip, []byte("not a zip"), 0644))
+	corruptGz := filepath.Join(dir, "corrupt.tgz")
+	require.NoError(t, os.WriteFile(corruptGz, []byte("not a gzip"), 0644))
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{name: "Missing", source: filepath.Join(dir, "missing")},
+		{name: "Unsupported", source: unsupported},
+		{name: "CorruptZip", source: corruptZip},
+		{name: "CorruptGzip", source: corruptGz},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectSource(t, tt.source)
+			assert.Error(t, err)
+			assert.Empty(t, got)
+		})
+	}
+}
+
+func TestReadSourceCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// никто не читает канал: обход прерывается отменой, а не блокируется
+	out := make(chan item)
+	err := readSource(ctx, writeDirSource(t), out)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, open := <-out
+	assert.False(t, open)
+}
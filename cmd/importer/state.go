@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type importedEntry struct {
+	ID     string `json:"id"`
+	SHA256 string `json:"sha256"`
+}
+
+// state хранит уже импортированные файлы, чтобы повторный запуск продолжал с места остановки.
+type state struct {
+	mu      sync.Mutex
+	path    string
+	Entries map[string]importedEntry `json:"entries"`
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{path: path, Entries: map[string]importedEntry{}}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.Entries == nil {
+		st.Entries = map[string]importedEntry{}
+	}
+
+	return st, nil
+}
+
+func (s *state) imported(path, checksum string) (importedEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.Entries[path]
+	return e, ok && e.SHA256 == checksum
+}
+
+// add сохраняет состояние после каждого файла; запись через временный файл
+// не оставляет поврежденное состояние при аварийном завершении.
+func (s *state) add(path string, e importedEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Entries[path] = e
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
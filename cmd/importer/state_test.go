@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string // пусто - файла состояния нет
+		wantErr  bool
+		entries  map[string]importedEntry
+	}{
+		{name: "Missing", entries: map[string]importedEntry{}},
+		{name: "NullEntries", contents: `{"entries": null}`, entries: map[string]importedEntry{}},
+		{name: "Saved", contents: `{"entries": {"a/b.txt": {"id": "1", "sha256": "abc"}}}`, entries: map[string]importedEntry{"a/b.txt": {ID: "1", SHA256: "abc"}}},
+		{name: "Corrupt", contents: `{"entries": `, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.contents != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tt.contents), 0644))
+			}
+
+			st, err := loadState(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.entries, st.Entries)
+		})
+	}
+}
+
+func TestStateResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	st, err := loadState(path)
+	require.NoError(t, err)
+	require.NoError(t, st.add("photos/a.jpg", importedEntry{ID: "1", SHA256: "aaa"}))
+	require.NoError(t, st.add("photos/b.jpg", importedEntry{ID: "2", SHA256: "bbb"}))
+
+	// повторный запуск читает состояние с диска
+	resumed, err := loadState(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		path     string
+		checksum string
+		id       string
+		imported bool
+	}{
+		{path: "photos/a.jpg", checksum: "aaa", id: "1", imported: true},
+		{path: "photos/b.jpg", checksum: "bbb", id: "2", imported: true},
+		// содержимое изменилось с прошлого запуска - файл импортируется заново
+		{path: "photos/a.jpg", checksum: "changed", id: "1"},
+		{path: "photos/c.jpg", checksum: "ccc"},
+	}
+	for _, tt := range tests {
+		e, ok := resumed.imported(tt.path, tt.checksum)
+		assert.Equal(t, tt.imported, ok, tt.path+" "+tt.checksum)
+		assert.Equal(t, tt.id, e.ID, tt.path+" "+tt.checksum)
+	}
+
+	// временные файлы записи не остаются рядом с состоянием
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestStateWithoutFile(t *testing.T) {
+	st, err := loadState("")
+	require.NoError(t, err)
+	require.NoError(t, st.add("a.txt", importedEntry{ID: "1", SHA256: "aaa"}))
+
+	_, ok := st.imported("a.txt", "aaa")
+	assert.True(t, ok)
+}
@@ -0,0 +1,109 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type archiveWriter interface {
+	WriteFile(name string, modTime time.Time, data []byte) error
+	Close() error
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func newZipArchive(w io.Writer) *zipArchive {
+	return &zipArchive{w: zip.NewWriter(w)}
+}
+
+func (a *zipArchive) WriteFile(name string, modTime time.Time, data []byte) error {
+	// Изображения уже сжаты, повторное сжатие только тратит процессор.
+	fw, err := a.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	w *tar.Writer
+}
+
+func newTarArchive(w io.Writer) *tarArchive {
+	return &tarArchive{w: tar.NewWriter(w)}
+}
+
+func (a *tarArchive) WriteFile(name string, modTime time.Time, data []byte) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(data)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	return a.w.Close()
+}
+
+// archiveNames выдает уникальные и безопасные для распаковки имена файлов в архиве:
+// разделители путей заменяются, повторы получают суффикс " (n)" перед расширением.
+type archiveNames map[string]struct{}
+
+func (n archiveNames) unique(name, fallback string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = fallback
+	}
+
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, ok := n[candidate]; !ok {
+			break
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+
+	n[candidate] = struct{}{}
+	return candidate
+}
+
+// chunkWriter режет поток на сообщения не больше size байт.
+type chunkWriter struct {
+	send func([]byte) error
+	size int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.size)
+		if err := w.send(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
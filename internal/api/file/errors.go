@@ -0,0 +1,62 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPreconditionFailed возвращается, если ревизия файла не совпала с ожидаемой (if_match).
+var ErrPreconditionFailed = errors.New("file revision does not match")
+
+// ErrIdempotencyKeyReused возвращается, если ключ идемпотентности уже использован для другого запроса.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found or expired")
+	ErrUploadOffsetMismatch  = errors.New("chunk offset does not match received size")
+	ErrUploadSizeExceeded    = errors.New("chunk exceeds declared total size")
+	ErrUploadIncomplete      = errors.New("upload session is incomplete")
+	ErrChecksumMismatch      = errors.New("checksum does not match uploaded data")
+)
+
+// ErrTooManyMetadataKeys возвращается, если после изменения у файла было бы больше
+// ключей метаданных, чем допускает ограничение files_metadata_keys_check.
+var ErrTooManyMetadataKeys = errors.New("too many metadata keys")
+
+// ErrBatchAborted возвращается, если пакетная операция в режиме "все или ничего" не была применена.
+var ErrBatchAborted = errors.New("batch aborted")
+
+var (
+	ErrLinkInvalid = errors.New("download link is invalid")
+	ErrLinkExpired = errors.New("download link has expired or was used up")
+)
+
+var (
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	// ErrNameExists возвращается, если имя файла или папки уже занято в той же папке.
+	ErrNameExists = errors.New("name already exists in the folder")
+)
+
+var (
+	// ErrBucketNotFound возвращается и при записи в бакет, которого нет.
+	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketExists   = errors.New("bucket already exists")
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+	// ErrFileTooLarge возвращается, если файл больше наибольшего размера файла бакета.
+	ErrFileTooLarge = errors.New("file exceeds the bucket file size limit")
+)
+
+// QuotaExceededError возвращается, если запись превысила бы квоту владельца, квоту всего
+// бакета (Owner == GlobalOwner) или всего сервиса (Bucket == DeploymentBucket).
+// Bytes и Files - использование с учетом отклоненной записи.
+type QuotaExceededError struct {
+	Usage
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Bucket == DeploymentBucket {
+		return fmt.Sprintf("deployment quota exceeded: %d of %d bytes, %d of %d files", e.Bytes, e.MaxBytes, e.Files, e.MaxFiles)
+	}
+	return fmt.Sprintf("quota exceeded for owner %s in bucket %s: %d of %d bytes, %d of %d files", e.Owner, e.Bucket, e.Bytes, e.MaxBytes, e.Files, e.MaxFiles)
+}
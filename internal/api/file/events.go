@@ -0,0 +1,62 @@
+package file
+
+import "sync"
+
+// FileEventsChannel - канал LISTEN/NOTIFY, в который триггер журнала file_events
+// отправляет имя бакета с новыми событиями.
+const FileEventsChannel = "file_events"
+
+// EventHub будит наблюдателей WatchFiles, когда в их бакете появились события.
+// Сами события наблюдатели читают из журнала, поэтому пропущенное пробуждение
+// лишь задерживает их до следующего опроса.
+type EventHub struct {
+	mu sync.Mutex
+	// бакет каждого наблюдателя
+	watchers map[chan struct{}]string
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{watchers: make(map[chan struct{}]string)}
+}
+
+// subscribe возвращает канал пробуждений наблюдателя бакета; пробуждения, пришедшие
+// до чтения из канала, объединяются. Вызывающий обязан вызвать cancel.
+func (h *EventHub) subscribe(bucket string) (wake <-chan struct{}, cancel func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	h.watchers[ch] = bucket
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.watchers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Notify будит наблюдателей бакета bucket.
+func (h *EventHub) Notify(bucket string) {
+	h.wake(func(b string) bool { return b == bucket })
+}
+
+// NotifyAll будит всех наблюдателей, например после восстановления подписки,
+// когда уведомления за время обрыва потеряны.
+func (h *EventHub) NotifyAll() {
+	h.wake(func(string) bool { return true })
+}
+
+func (h *EventHub) wake(match func(bucket string) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch, bucket := range h.watchers {
+		if !match(bucket) {
+			continue
+		}
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
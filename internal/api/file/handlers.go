@@ -2,9 +2,29 @@ package file
 
 import (
 	pb "app/api/proto"
+	"app/internal/api/ratelimit"
 	"app/pkg/logging"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	idempotencyKeyHeader    = "idempotency-key"
+	maxIdempotencyKeyLength = 255
+
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
 )
 
 type Server struct {
@@ -13,35 +33,119 @@ type Server struct {
 	Logger         *logging.Logger
 	FileRepository FileRepository
 
-	UploadSemaphore   chan struct{}
-	DownloadSemaphore chan struct{}
-	ListSemaphore     chan struct{}
+	// слоты одновременных запросов, распределяемые между клиентами по очереди;
+	// бюджет UploadSemaphore и DownloadSemaphore ограничивает объем данных в обработке
+	UploadSemaphore   *ratelimit.FairQueue
+	DownloadSemaphore *ratelimit.FairQueue
+	ListSemaphore     *ratelimit.FairQueue
+
+	UploadRateLimit   RateLimit
+	DownloadRateLimit RateLimit
+	ListRateLimit     RateLimit
+
+	IdempotencyTTL       time.Duration
+	UploadSessionTTL     time.Duration
+	MaxUploadSessionSize int64
+	MaxBatchSize         int
+	MaxArchiveSize       int64
+
+	// LinkSigner равен nil, если ссылки на скачивание не настроены
+	LinkSigner     *LinkSigner
+	LinkBaseURL    string
+	DefaultLinkTTL time.Duration
+	MaxLinkTTL     time.Duration
+
+	// AdminToken пропускает административные методы; пустой отключает их
+	AdminToken string
+	// BucketTokens - бакеты клиентов по их токенам, см. bucketContext
+	BucketTokens map[string]string
+	// OwnerTokens - владельцы файлов по токенам клиентов, см. ownerFromContext
+	OwnerTokens map[string]string
+
+	// Events будит наблюдателей WatchFiles по уведомлениям базы; без уведомлений
+	// они находят события опросом раз в WatchPollInterval
+	Events            *EventHub
+	WatchPollInterval time.Duration
+	// WatchHeartbeatInterval - период ответов WatchFiles только с курсором; 0 отключает их
+	WatchHeartbeatInterval time.Duration
 }
 
 func NewServer(logger *logging.Logger, fileRepository FileRepository) *Server {
 	return &Server{
 		FileRepository:    fileRepository,
 		Logger:            logger,
-		UploadSemaphore:   make(chan struct{}, 10),
-		DownloadSemaphore: make(chan struct{}, 10),
-		ListSemaphore:     make(chan struct{}, 100),
+		UploadSemaphore:   ratelimit.NewFairQueue(10, 0, defaultPriorityAging),
+		DownloadSemaphore: ratelimit.NewFairQueue(10, 0, defaultPriorityAging),
+		ListSemaphore:     ratelimit.NewFairQueue(100, 0, defaultPriorityAging),
+		IdempotencyTTL:    24 * time.Hour,
+
+		UploadSessionTTL:     24 * time.Hour,
+		MaxUploadSessionSize: 256 << 20,
+		MaxBatchSize:         100,
+		MaxArchiveSize:       1 << 30,
+
+		DefaultLinkTTL: time.Hour,
+		MaxLinkTTL:     7 * 24 * time.Hour,
+
+		Events:                 NewEventHub(),
+		WatchPollInterval:      5 * time.Second,
+		WatchHeartbeatInterval: time.Minute,
 	}
 }
 
 func (s *Server) UploadFile(ctx context.Context, req *pb.UploadFileRequest) (*pb.UploadFileResponse, error) {
-	s.UploadSemaphore <- struct{}{}
-	fmt.Println("Uploading started:", req.FileName)
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateDescription(req.Description); err != nil {
+		return nil, err
+	}
+	if err = validateMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+	if err = validateFolderID(req.FolderId); err != nil {
+		return nil, err
+	}
+
+	size := int64(len(req.Data))
+	release, err := s.admit(ctx, uploadClass, size, size)
+	if err != nil {
+		return nil, err
+	}
+	s.Logger.Debug(fmt.Sprintf("Uploading started: %s", req.FileName))
 	defer func() {
-		<-s.UploadSemaphore
-		fmt.Println("Uploading finished:", req.FileName)
+		release()
+		s.Logger.Debug(fmt.Sprintf("Uploading finished: %s", req.FileName))
 	}()
 
-	newFile := File{Name: req.FileName, Data: req.Data}
+	owner, err := s.ownerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	newFile := File{Name: req.FileName, Data: req.Data, Owner: owner, Tags: tags, Description: req.Description, Metadata: req.Metadata, FolderID: req.FolderId}
+
+	key := idempotencyKey(ctx, req)
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key is longer than %d characters", maxIdempotencyKeyLength)
+	}
 
-	err := s.FileRepository.Create(ctx, &newFile)
+	if key == "" {
+		err = s.FileRepository.Create(ctx, &newFile)
+	} else {
+		err = s.FileRepository.CreateIdempotent(ctx, &newFile, key, uploadFingerprint(req), s.IdempotencyTTL)
+	}
+	if errors.Is(err, ErrIdempotencyKeyReused) {
+		return nil, status.Errorf(codes.AlreadyExists, "idempotency key %q was already used with a different request", key)
+	}
 	if err != nil {
 		s.Logger.Error(fmt.Sprintf("Failed to create file: %v", err))
-		return nil, err
+		return nil, folderError(err)
 	}
 
 	s.Logger.Info(fmt.Sprintf("File uploaded successfully: %s", newFile.Name))
@@ -49,8 +153,27 @@ func (s *Server) UploadFile(ctx context.Context, req *pb.UploadFileRequest) (*pb
 }
 
 func (s *Server) DownloadFile(ctx context.Context, req *pb.DownloadFileRequest) (*pb.DownloadFileResponse, error) {
-	s.DownloadSemaphore <- struct{}{}
-	defer func() { <-s.DownloadSemaphore }()
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admitDownload(ctx, []string{req.Id}, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if req.IfNoneMatch != "" && req.Version == 0 {
+		revision, err := s.FileRepository.FindRevision(ctx, req.Id)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to find file revision: %v", err))
+			return nil, err
+		}
+		if revision != 0 && etagMatches(req.IfNoneMatch, revision) {
+			return &pb.DownloadFileResponse{Etag: formatETag(revision), NotModified: true}, nil
+		}
+	}
 
 	fl, err := s.FileRepository.FindOne(ctx, req.Id)
 	if err != nil {
@@ -58,14 +181,66 @@ func (s *Server) DownloadFile(ctx context.Context, req *pb.DownloadFileRequest)
 		return nil, err
 	}
 
-	return &pb.DownloadFileResponse{FileName: fl.Name, Data: fl.Data, CreatedAt: fl.CreatedAt.Unix(), UpdatedAt: fl.UpdatedAt.Unix()}, nil
+	if req.Version == 0 || req.Version == fl.Version {
+		s.chargeBytes(ctx, downloadClass, int64(len(fl.Data)))
+		return &pb.DownloadFileResponse{FileName: fl.Name, Data: fl.Data, CreatedAt: fl.CreatedAt.Unix(), UpdatedAt: fl.UpdatedAt.Unix(), Version: fl.Version, Etag: formatETag(fl.Revision)}, nil
+	}
+
+	fv, err := s.FileRepository.FindVersion(ctx, req.Id, req.Version)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find file version: %v", err))
+		return nil, err
+	}
+	if fv.Version == 0 {
+		return nil, status.Errorf(codes.NotFound, "version %d of file %s not found", req.Version, req.Id)
+	}
+
+	// etag описывает текущее содержимое: клиент, сохранивший под ним старую версию,
+	// получил бы not_modified вместо текущей, поэтому у старых версий etag нет
+	s.chargeBytes(ctx, downloadClass, int64(len(fv.Data)))
+	return &pb.DownloadFileResponse{FileName: fv.Name, Data: fv.Data, CreatedAt: fl.CreatedAt.Unix(), UpdatedAt: fv.CreatedAt.Unix(), Version: fv.Version}, nil
 }
 
 func (s *Server) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (*pb.ListFilesResponse, error) {
-	s.ListSemaphore <- struct{}{}
-	defer func() { <-s.ListSemaphore }()
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var nextPageToken string
+	if req.PageSize < 0 || req.PageSize > maxListPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page size must be between 0 and %d", maxListPageSize)
+	}
+	afterID, err := parsePageToken(req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := fileFilter(req)
+	if err != nil {
+		return nil, err
+	}
 
-	files, err := s.FileRepository.FindAll(ctx)
+	var files []FileInfo
+	if req.PageSize == 0 && req.PageToken == "" {
+		files, err = s.FileRepository.FindAll(ctx, filter)
+	} else {
+		pageSize := int(req.PageSize)
+		if pageSize == 0 {
+			pageSize = defaultListPageSize
+		}
+		// лишняя запись показывает, есть ли следующая страница
+		files, err = s.FileRepository.FindPage(ctx, filter, afterID, pageSize+1)
+		if err == nil && len(files) > pageSize {
+			files = files[:pageSize]
+			nextPageToken = formatPageToken(files[pageSize-1].ID)
+		}
+	}
 	if err != nil {
 		s.Logger.Error(fmt.Sprintf("Failed to find all files: %v", err))
 		return nil, err
@@ -73,12 +248,255 @@ func (s *Server) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (*pb.L
 
 	var fileMetadataList []*pb.FileMetadata
 	for _, file := range files {
-		fileMetadataList = append(fileMetadataList, &pb.FileMetadata{
-			Name:      file.Name,
-			CreatedAt: file.CreatedAt.Unix(),
-			UpdatedAt: file.UpdatedAt.Unix(),
+		fileMetadataList = append(fileMetadataList, fileMetadata(file.File, file.Size))
+	}
+
+	return &pb.ListFilesResponse{Files: fileMetadataList, NextPageToken: nextPageToken}, nil
+}
+
+func formatPageToken(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+func parsePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !uuidRegexp.Match(id) {
+		return "", status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	return string(id), nil
+}
+
+func (s *Server) UpdateFile(ctx context.Context, req *pb.UpdateFileRequest) (*pb.UpdateFileResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := parseETag(req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	name, setData, err := fileUpdate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(len(req.Data))
+	release, err := s.admit(ctx, uploadClass, size, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	update := &File{ID: req.Id, Name: name, Revision: revision}
+	if setData {
+		update.Data = req.Data
+	}
+	files, err := s.FileRepository.Update(ctx, update, setData)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to update file: %v", err))
+		return nil, preconditionError(err, req.Id)
+	}
+	if len(files) == 0 {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("File updated successfully: %s, version %d", req.Id, files[0].Version))
+	return &pb.UpdateFileResponse{Version: files[0].Version, UpdatedAt: files[0].UpdatedAt.Unix(), Etag: formatETag(files[0].Revision)}, nil
+}
+
+// fileUpdate переводит маску полей UpdateFile в новое имя (пустое - без изменения) и флаг
+// замены содержимого. Без маски меняются только непустые поля.
+func fileUpdate(req *pb.UpdateFileRequest) (name string, setData bool, err error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return req.FileName, len(req.Data) > 0, nil
+	}
+
+	for _, path := range paths {
+		switch path {
+		case "file_name":
+			if req.FileName == "" {
+				return "", false, status.Error(codes.InvalidArgument, "file name must not be empty")
+			}
+			name = req.FileName
+		case "data":
+			setData = true
+		default:
+			return "", false, status.Errorf(codes.InvalidArgument, "unknown update mask path %q", path)
+		}
+	}
+	return name, setData, nil
+}
+
+func (s *Server) DeleteFile(ctx context.Context, req *pb.DeleteFileRequest) (*pb.DeleteFileResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := parseETag(req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ids, err := s.FileRepository.Delete(ctx, req.Id, revision)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to delete file: %v", err))
+		return nil, preconditionError(err, req.Id)
+	}
+	if len(ids) == 0 {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("File deleted successfully: %s", req.Id))
+	return &pb.DeleteFileResponse{}, nil
+}
+
+func (s *Server) ListFileVersions(ctx context.Context, req *pb.ListFileVersionsRequest) (*pb.ListFileVersionsResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	versions, err := s.FileRepository.FindVersions(ctx, req.Id)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find file versions: %v", err))
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	var versionList []*pb.FileVersion
+	for _, v := range versions {
+		versionList = append(versionList, &pb.FileVersion{
+			Version:   v.Version,
+			Name:      v.Name,
+			Size:      v.Size,
+			CreatedAt: v.CreatedAt.Unix(),
 		})
 	}
 
-	return &pb.ListFilesResponse{Files: fileMetadataList}, nil
+	return &pb.ListFileVersionsResponse{Versions: versionList}, nil
+}
+
+func (s *Server) RestoreFileVersion(ctx context.Context, req *pb.RestoreFileVersionRequest) (*pb.RestoreFileVersionResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := parseETag(req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fl, err := s.FileRepository.RestoreVersion(ctx, req.Id, req.Version, revision)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to restore file version: %v", err))
+		return nil, preconditionError(err, req.Id)
+	}
+	if fl.ID == "" {
+		return nil, status.Errorf(codes.NotFound, "version %d of file %s not found", req.Version, req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("File %s restored from version %d as version %d", req.Id, req.Version, fl.Version))
+	return &pb.RestoreFileVersionResponse{Version: fl.Version, Etag: formatETag(fl.Revision)}, nil
+}
+
+func idempotencyKey(ctx context.Context, req *pb.UploadFileRequest) string {
+	if req.IdempotencyKey != "" {
+		return req.IdempotencyKey
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(idempotencyKeyHeader); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// uploadFingerprint отличает повтор того же запроса от другого запроса с тем же ключом.
+func uploadFingerprint(req *pb.UploadFileRequest) string {
+	h := sha256.New()
+	h.Write([]byte(req.FileName))
+	h.Write([]byte{0})
+	h.Write(req.Data)
+	// пустые метаданные не меняют отпечаток, чтобы ключи, сохраненные до их появления, оставались верны
+	if len(req.Tags) > 0 || req.Description != "" || len(req.Metadata) > 0 {
+		h.Write([]byte{0})
+		h.Write([]byte(strings.Join(req.Tags, ",")))
+		h.Write([]byte{0})
+		h.Write([]byte(req.Description))
+		h.Write([]byte{0})
+		h.Write([]byte(metadataJSON(req.Metadata)))
+	}
+	if req.FolderId != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(req.FolderId))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// etag файла - его ревизия, увеличивающаяся при каждом изменении.
+func formatETag(revision int64) string {
+	return strconv.FormatInt(revision, 10)
+}
+
+// parseETag принимает etag как в виде числа, так и в HTTP-форме ("3", W/"3").
+// Пустая строка означает отсутствие условия.
+func parseETag(etag string) (int64, error) {
+	etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+	if etag == "" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid etag %q", etag)
+	}
+
+	return revision, nil
+}
+
+func etagMatches(etag string, revision int64) bool {
+	for _, tag := range strings.Split(etag, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			return true
+		}
+		if rev, err := parseETag(tag); err == nil && rev == revision {
+			return true
+		}
+	}
+	return false
+}
+
+func preconditionError(err error, id string) error {
+	if errors.Is(err, ErrPreconditionFailed) {
+		return status.Errorf(codes.FailedPrecondition, "file %s was modified: etag does not match", id)
+	}
+	return folderError(err)
 }
@@ -0,0 +1,99 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"bufio"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Меньше ограничения gRPC на размер сообщения по умолчанию (4 МБ).
+const archiveChunkSize = 1 << 20
+
+// DownloadArchive собирает архив на лету, читая файлы по одному. Весь архив
+// занимает один слот DownloadSemaphore, отданные части списываются с лимита байтов по мере отправки.
+// Число файлов ограничено MaxBatchSize и без ids, их суммарный размер - MaxArchiveSize.
+func (s *Server) DownloadArchive(req *pb.DownloadArchiveRequest, stream grpc.ServerStreamingServer[pb.DownloadArchiveResponse]) error {
+	if len(req.Ids) > s.MaxBatchSize {
+		return status.Errorf(codes.InvalidArgument, "archive size %d exceeds limit %d", len(req.Ids), s.MaxBatchSize)
+	}
+	for _, id := range req.Ids {
+		if !uuidRegexp.MatchString(id) {
+			return status.Errorf(codes.InvalidArgument, "invalid file id %q", id)
+		}
+	}
+
+	ctx, err := s.bucketContext(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err = s.checkRate(ctx, downloadClass, 0); err != nil {
+		return err
+	}
+
+	size, err := s.FileRepository.MeasureArchive(ctx, req.Ids, req.NamePrefix)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to measure archive: %v", err))
+		return err
+	}
+	if ids := uniqueStrings(req.Ids); len(ids) > 0 && size.Files != len(ids) {
+		return status.Errorf(codes.NotFound, "%d of %d files not found", len(ids)-size.Files, len(ids))
+	}
+	if size.Files > s.MaxBatchSize {
+		return status.Errorf(codes.InvalidArgument, "archive size %d exceeds limit %d, narrow it with ids or name_prefix", size.Files, s.MaxBatchSize)
+	}
+	if size.Bytes > s.MaxArchiveSize {
+		return status.Errorf(codes.InvalidArgument, "archive of %d bytes exceeds limit %d, narrow it with ids or name_prefix", size.Bytes, s.MaxArchiveSize)
+	}
+
+	// ForEach держит в памяти один файл целиком, а кроме него - буфер и отправляемую часть архива
+	release, err := s.acquire(ctx, downloadClass, size.Largest+2*archiveChunkSize)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cw := &chunkWriter{size: archiveChunkSize, send: func(p []byte) error {
+		s.chargeBytes(ctx, downloadClass, int64(len(p)))
+		return stream.Send(&pb.DownloadArchiveResponse{Data: p})
+	}}
+	buf := bufio.NewWriterSize(cw, archiveChunkSize)
+
+	var archive archiveWriter
+	switch req.Format {
+	case pb.ArchiveFormat_ARCHIVE_FORMAT_ZIP:
+		archive = newZipArchive(buf)
+	case pb.ArchiveFormat_ARCHIVE_FORMAT_TAR:
+		archive = newTarArchive(buf)
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown archive format %v", req.Format)
+	}
+
+	names := archiveNames{}
+	count := 0
+	err = s.FileRepository.ForEach(ctx, req.Ids, req.NamePrefix, func(fl File) error {
+		// файл, выросший после подсчета, занял бы больше памяти, чем выделено архиву
+		if int64(len(fl.Data)) > size.Largest {
+			return status.Errorf(codes.Aborted, "file %s changed while building the archive", fl.ID)
+		}
+		count++
+		return archive.WriteFile(names.unique(fl.Name, fl.ID), fl.UpdatedAt, fl.Data)
+	})
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to build archive: %v", err))
+		return err
+	}
+
+	if err = archive.Close(); err != nil {
+		return err
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+
+	s.Logger.Info(fmt.Sprintf("Archive sent: %d files", count))
+	return nil
+}
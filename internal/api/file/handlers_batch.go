@@ -0,0 +1,248 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxFileNameLength = 100
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Пакет целиком занимает один слот соответствующего семафора.
+func (s *Server) BatchUploadFiles(ctx context.Context, req *pb.BatchUploadFilesRequest) (*pb.BatchUploadFilesResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize(len(req.Files)); err != nil {
+		return nil, err
+	}
+	owner, err := s.ownerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, f := range req.Files {
+		size += int64(len(f.Data))
+	}
+	release, err := s.admit(ctx, uploadClass, size, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	results := make([]*pb.BatchUploadResult, len(req.Files))
+	files := make([]*File, 0, len(req.Files))
+	positions := make([]int, 0, len(req.Files))
+	for i, f := range req.Files {
+		results[i] = &pb.BatchUploadResult{}
+		if err := validateFileName(f.FileName); err != nil {
+			results[i].Error = batchError(codes.InvalidArgument, err.Error())
+			continue
+		}
+		files = append(files, &File{Name: f.FileName, Data: f.Data, Owner: owner})
+		positions = append(positions, i)
+	}
+
+	if len(files) < len(req.Files) && req.Mode == pb.BatchMode_BATCH_MODE_ALL_OR_NOTHING {
+		abortBatch(results, func(r *pb.BatchUploadResult) **pb.BatchError { return &r.Error })
+		return &pb.BatchUploadFilesResponse{Results: results}, nil
+	}
+
+	// в режиме best effort отказ базы на одном файле не отменяет остальные
+	var errs []error
+	if req.Mode == pb.BatchMode_BATCH_MODE_ALL_OR_NOTHING {
+		err = s.FileRepository.CreateMany(ctx, files)
+	} else {
+		errs, err = s.FileRepository.CreateEach(ctx, files)
+	}
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to create files batch: %v", err))
+		return nil, folderError(err)
+	}
+
+	uploaded := 0
+	for i, fl := range files {
+		if i < len(errs) && errs[i] != nil {
+			results[positions[i]].Error = s.batchItemError(errs[i], fl.Name)
+			continue
+		}
+		results[positions[i]].Id = fl.ID
+		uploaded++
+	}
+
+	s.Logger.Info(fmt.Sprintf("Files batch uploaded: %d of %d", uploaded, len(req.Files)))
+	return &pb.BatchUploadFilesResponse{Results: results}, nil
+}
+
+// batchItemError переводит ошибку репозитория по одному элементу пакета в BatchError.
+// Неизвестные ошибки только логируются, чтобы не раскрывать клиенту детали SQL.
+func (s *Server) batchItemError(err error, name string) *pb.BatchError {
+	st := status.Convert(folderError(err))
+	if st.Code() == codes.Unknown {
+		s.Logger.Error(fmt.Sprintf("Failed to create file %s in batch: %v", name, err))
+		return batchError(codes.Internal, "failed to create file")
+	}
+	return batchError(st.Code(), st.Message())
+}
+
+func (s *Server) BatchGetFiles(ctx context.Context, req *pb.BatchGetFilesRequest) (*pb.BatchGetFilesResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize(len(req.Ids)); err != nil {
+		return nil, err
+	}
+
+	ids := validUUIDs(req.Ids)
+	release, err := s.admitDownload(ctx, ids, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	files, err := s.FileRepository.FindMany(ctx, ids)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find files batch: %v", err))
+		return nil, err
+	}
+
+	byID := make(map[string]File, len(files))
+	for _, fl := range files {
+		byID[fl.ID] = fl
+	}
+
+	results := make([]*pb.BatchGetResult, len(req.Ids))
+	for i, id := range req.Ids {
+		results[i] = &pb.BatchGetResult{Id: id}
+		fl, ok := byID[id]
+		if !ok {
+			results[i].Error = batchError(codes.NotFound, fmt.Sprintf("file %s not found", id))
+			continue
+		}
+		s.chargeBytes(ctx, downloadClass, int64(len(fl.Data)))
+		results[i].File = &pb.DownloadFileResponse{
+			FileName:  fl.Name,
+			Data:      fl.Data,
+			CreatedAt: fl.CreatedAt.Unix(),
+			UpdatedAt: fl.UpdatedAt.Unix(),
+			Version:   fl.Version,
+			Etag:      formatETag(fl.Revision),
+		}
+	}
+
+	return &pb.BatchGetFilesResponse{Results: results}, nil
+}
+
+func (s *Server) BatchDeleteFiles(ctx context.Context, req *pb.BatchDeleteFilesRequest) (*pb.BatchDeleteFilesResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize(len(req.Ids)); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	results := make([]*pb.BatchDeleteResult, len(req.Ids))
+	for i, id := range req.Ids {
+		results[i] = &pb.BatchDeleteResult{Id: id}
+	}
+
+	ids := validUUIDs(req.Ids)
+	atomic := req.Mode == pb.BatchMode_BATCH_MODE_ALL_OR_NOTHING
+	if atomic && len(ids) < len(req.Ids) {
+		for _, r := range results {
+			if !uuidRegexp.MatchString(r.Id) {
+				r.Error = batchError(codes.NotFound, fmt.Sprintf("file %s not found", r.Id))
+			}
+		}
+		abortBatch(results, func(r *pb.BatchDeleteResult) **pb.BatchError { return &r.Error })
+		return &pb.BatchDeleteFilesResponse{Results: results}, nil
+	}
+
+	deleted, err := s.FileRepository.DeleteMany(ctx, ids, atomic)
+	if err != nil && !errors.Is(err, ErrBatchAborted) {
+		s.Logger.Error(fmt.Sprintf("Failed to delete files batch: %v", err))
+		return nil, err
+	}
+
+	found := make(map[string]struct{}, len(deleted))
+	for _, id := range deleted {
+		found[id] = struct{}{}
+	}
+	for _, r := range results {
+		if _, ok := found[r.Id]; !ok {
+			r.Error = batchError(codes.NotFound, fmt.Sprintf("file %s not found", r.Id))
+		}
+	}
+	if errors.Is(err, ErrBatchAborted) {
+		abortBatch(results, func(r *pb.BatchDeleteResult) **pb.BatchError { return &r.Error })
+	}
+
+	s.Logger.Info(fmt.Sprintf("Files batch deleted: %d of %d", len(found), len(req.Ids)))
+	return &pb.BatchDeleteFilesResponse{Results: results}, nil
+}
+
+func (s *Server) checkBatchSize(n int) error {
+	if n == 0 {
+		return status.Error(codes.InvalidArgument, "batch is empty")
+	}
+	if n > s.MaxBatchSize {
+		return status.Errorf(codes.InvalidArgument, "batch size %d exceeds limit %d", n, s.MaxBatchSize)
+	}
+	return nil
+}
+
+func validateFileName(name string) error {
+	if name == "" {
+		return errors.New("file name is required")
+	}
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		return fmt.Errorf("file name is longer than %d characters", maxFileNameLength)
+	}
+	return nil
+}
+
+// validUUIDs отбрасывает значения, которые не могут быть ID файла,
+// чтобы одно некорректное значение не приводило к ошибке всего запроса.
+func validUUIDs(ids []string) []string {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if uuidRegexp.MatchString(id) {
+			valid = append(valid, id)
+		}
+	}
+	return valid
+}
+
+func batchError(code codes.Code, message string) *pb.BatchError {
+	return &pb.BatchError{Code: int32(code), Message: message}
+}
+
+// abortBatch помечает как Aborted все элементы без собственной ошибки.
+func abortBatch[T any](results []T, errField func(T) **pb.BatchError) {
+	for _, r := range results {
+		if e := errField(r); *e == nil {
+			*e = batchError(codes.Aborted, "batch aborted: another item failed")
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"app/pkg/client/postgresql"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	bucketHeader = "x-bucket"
+	// DefaultBucket создается миграцией и не удаляется; в нем работают клиенты без бакета.
+	DefaultBucket = "default"
+)
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// bucketContext определяет бакет запроса и ограничивает им запросы к базе с ctx.
+// Бакет определяют только проверенные учетные данные: токен из BucketTokens закрепляет
+// за клиентом его бакет, а x-admin-token открывает любой бакет из x-bucket. Клиенты без
+// токенов работают только в default.
+func (s *Server) bucketContext(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	bucket := DefaultBucket
+	pinned, hasToken := s.tokenBucket(md)
+	if hasToken {
+		bucket = pinned
+	}
+
+	if values := md.Get(bucketHeader); len(values) > 0 && values[0] != "" && values[0] != bucket {
+		if !s.isAdmin(ctx) {
+			return nil, status.Errorf(codes.PermissionDenied, "credentials do not allow access to bucket %s", values[0])
+		}
+		bucket = values[0]
+	}
+
+	if !bucketNameRegexp.MatchString(bucket) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid bucket name %q", bucket)
+	}
+	if postgresql.TenantFromContext(ctx) == bucket {
+		return ctx, nil
+	}
+	return postgresql.WithTenant(ctx, bucket), nil
+}
+
+// tokenBucket возвращает бакет токена из заголовка authorization: Bearer <токен>.
+func (s *Server) tokenBucket(md metadata.MD) (string, bool) {
+	return lookupToken(md, s.BucketTokens)
+}
+
+// lookupToken ищет токен из заголовка authorization: Bearer <токен> среди ключей tokens
+// и возвращает его значение.
+func lookupToken(md metadata.MD, tokens map[string]string) (string, bool) {
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", false
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	for known, value := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func validateBucketConfig(name string, config *pb.BucketConfig) error {
+	if !bucketNameRegexp.MatchString(name) {
+		return status.Error(codes.InvalidArgument, "bucket name must be 1-63 lowercase letters, digits or '-', starting and ending with a letter or digit")
+	}
+	if config.GetMaxBytes() < 0 || config.GetMaxFiles() < 0 || config.GetMaxFileSize() < 0 || config.GetKeepVersions() < 0 || config.GetKeepVersionsDays() < 0 {
+		return status.Error(codes.InvalidArgument, "limits must not be negative")
+	}
+	return nil
+}
+
+func bucketFromProto(name string, config *pb.BucketConfig) Bucket {
+	return Bucket{
+		Name:             name,
+		MaxBytes:         config.GetMaxBytes(),
+		MaxFiles:         config.GetMaxFiles(),
+		MaxFileSize:      config.GetMaxFileSize(),
+		KeepVersions:     config.GetKeepVersions(),
+		KeepVersionsDays: config.GetKeepVersionsDays(),
+	}
+}
+
+func bucketToProto(b Bucket) *pb.Bucket {
+	return &pb.Bucket{
+		Name: b.Name,
+		Config: &pb.BucketConfig{
+			MaxBytes:         b.MaxBytes,
+			MaxFiles:         b.MaxFiles,
+			MaxFileSize:      b.MaxFileSize,
+			KeepVersions:     b.KeepVersions,
+			KeepVersionsDays: b.KeepVersionsDays,
+		},
+		Bytes:     b.Bytes,
+		Files:     b.Files,
+		CreatedAt: b.CreatedAt.Unix(),
+	}
+}
+
+func bucketError(err error) error {
+	switch {
+	case errors.Is(err, ErrBucketNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrBucketExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrBucketNotEmpty):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
+
+// Административные методы бакетов выполняются без бакета запроса и видят все бакеты.
+
+func (s *Server) CreateBucket(ctx context.Context, req *pb.CreateBucketRequest) (*pb.CreateBucketResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateBucketConfig(req.Name, req.Config); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	bucket := bucketFromProto(req.Name, req.Config)
+	if err = s.FileRepository.CreateBucket(ctx, &bucket); err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to create bucket %s: %v", req.Name, err))
+		return nil, bucketError(err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Bucket created: %s", bucket.Name))
+	return &pb.CreateBucketResponse{Bucket: bucketToProto(bucket)}, nil
+}
+
+func (s *Server) UpdateBucket(ctx context.Context, req *pb.UpdateBucketRequest) (*pb.UpdateBucketResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateBucketConfig(req.Name, req.Config); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	bucket := bucketFromProto(req.Name, req.Config)
+	if err = s.FileRepository.UpdateBucket(ctx, &bucket); err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to update bucket %s: %v", req.Name, err))
+		return nil, bucketError(err)
+	}
+
+	// использование бакета UpdateBucket не возвращает
+	bucket, err = s.FileRepository.FindBucket(ctx, req.Name)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find bucket %s: %v", req.Name, err))
+		return nil, err
+	}
+	if bucket.Name == "" {
+		return nil, status.Errorf(codes.NotFound, "bucket %s not found", req.Name)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Bucket updated: %s", bucket.Name))
+	return &pb.UpdateBucketResponse{Bucket: bucketToProto(bucket)}, nil
+}
+
+func (s *Server) ListBuckets(ctx context.Context, _ *pb.ListBucketsRequest) (*pb.ListBucketsResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	buckets, err := s.FileRepository.ListBuckets(ctx)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to list buckets: %v", err))
+		return nil, err
+	}
+
+	resp := &pb.ListBucketsResponse{Buckets: make([]*pb.Bucket, 0, len(buckets))}
+	for _, b := range buckets {
+		resp.Buckets = append(resp.Buckets, bucketToProto(b))
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteBucket(ctx context.Context, req *pb.DeleteBucketRequest) (*pb.DeleteBucketResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Name == DefaultBucket {
+		return nil, status.Error(codes.FailedPrecondition, "default bucket cannot be deleted")
+	}
+	if !bucketNameRegexp.MatchString(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid bucket name %q", req.Name)
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	files, err := s.FileRepository.DeleteBucket(ctx, req.Name, req.Force)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to delete bucket %s: %v", req.Name, err))
+		return nil, bucketError(err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Bucket deleted: %s with %d files", req.Name, files))
+	return &pb.DeleteBucketResponse{DeletedFiles: files}, nil
+}
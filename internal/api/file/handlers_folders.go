@@ -0,0 +1,246 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) CreateFolder(ctx context.Context, req *pb.CreateFolderRequest) (*pb.CreateFolderResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateFolderName(req.Name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateFolderID(req.ParentId); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	folder := Folder{ParentID: req.ParentId, Name: req.Name}
+	if err = s.FileRepository.CreateFolder(ctx, &folder); err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to create folder: %v", err))
+		return nil, folderError(err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Folder created: %s (%s)", folder.Name, folder.ID))
+	return &pb.CreateFolderResponse{Folder: folderMetadata(folder)}, nil
+}
+
+func (s *Server) ListFolder(ctx context.Context, req *pb.ListFolderRequest) (*pb.ListFolderResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateFolderID(req.FolderId); err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 || req.PageSize > maxListPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page size must be between 0 and %d", maxListPageSize)
+	}
+	afterPath, afterID, err := parseFolderPageToken(req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if req.FolderId != "" {
+		folder, err := s.FileRepository.FindFolder(ctx, req.FolderId)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to find folder: %v", err))
+			return nil, err
+		}
+		if folder.ID == "" {
+			return nil, status.Errorf(codes.NotFound, "folder %s not found", req.FolderId)
+		}
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = defaultListPageSize
+	}
+
+	// лишняя запись показывает, есть ли следующая страница
+	entries, err := s.FileRepository.ListFolder(ctx, req.FolderId, req.Recursive, afterPath, afterID, pageSize+1)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to list folder: %v", err))
+		return nil, err
+	}
+
+	var nextPageToken string
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		nextPageToken = formatFolderPageToken(entries[pageSize-1])
+	}
+
+	res := &pb.ListFolderResponse{Entries: make([]*pb.FolderEntry, 0, len(entries)), NextPageToken: nextPageToken}
+	for _, entry := range entries {
+		item := &pb.FolderEntry{Path: entry.Path}
+		if entry.Folder != nil {
+			item.Entry = &pb.FolderEntry_Folder{Folder: folderMetadata(*entry.Folder)}
+		} else {
+			item.Entry = &pb.FolderEntry_File{File: fileMetadata(entry.File.File, entry.File.Size)}
+		}
+		res.Entries = append(res.Entries, item)
+	}
+
+	return res, nil
+}
+
+func (s *Server) MoveFile(ctx context.Context, req *pb.MoveFileRequest) (*pb.MoveFileResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateFolderID(req.FolderId); err != nil {
+		return nil, err
+	}
+	revision, err := parseETag(req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fl, err := s.FileRepository.MoveFile(ctx, req.Id, req.FolderId, revision)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to move file: %v", err))
+		return nil, preconditionError(err, req.Id)
+	}
+	if fl.ID == "" {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("File %s moved to folder %q", fl.ID, fl.FolderID))
+	return &pb.MoveFileResponse{File: fileMetadata(fl.File, fl.Size)}, nil
+}
+
+func (s *Server) DeleteFolder(ctx context.Context, req *pb.DeleteFolderRequest) (*pb.DeleteFolderResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "folder id is required")
+	}
+	if err := validateFolderID(req.Id); err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	folders, files, err := s.FileRepository.DeleteFolder(ctx, req.Id, req.Recursive)
+	if errors.Is(err, ErrFolderNotEmpty) {
+		return nil, status.Errorf(codes.FailedPrecondition, "folder %s is not empty: delete its contents first or pass recursive", req.Id)
+	}
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to delete folder: %v", err))
+		return nil, err
+	}
+	if folders == 0 {
+		return nil, status.Errorf(codes.NotFound, "folder %s not found", req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Folder %s deleted with %d subfolders and %d files", req.Id, folders-1, files))
+	return &pb.DeleteFolderResponse{DeletedFolders: folders, DeletedFiles: files}, nil
+}
+
+// folderError переводит ошибки папок и отсутствие бакета в статусы gRPC;
+// остальные ошибки - как quotaError.
+func folderError(err error) error {
+	switch {
+	case errors.Is(err, ErrFolderNotFound), errors.Is(err, ErrBucketNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrNameExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return quotaError(err)
+}
+
+func validateFolderName(name string) error {
+	if name == "" {
+		return errors.New("folder name is required")
+	}
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		return fmt.Errorf("folder name is longer than %d characters", maxFileNameLength)
+	}
+	// '/' разделяет путь в ListFolder
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("invalid folder name %q", name)
+	}
+	return nil
+}
+
+// validateFolderID допускает пустой ID - корень.
+func validateFolderID(id string) error {
+	if id != "" && !uuidRegexp.MatchString(id) {
+		return status.Errorf(codes.InvalidArgument, "invalid folder id %q", id)
+	}
+	return nil
+}
+
+func folderMetadata(folder Folder) *pb.Folder {
+	return &pb.Folder{
+		Id:        folder.ID,
+		ParentId:  folder.ParentID,
+		Name:      folder.Name,
+		CreatedAt: folder.CreatedAt.Unix(),
+	}
+}
+
+// Токен страницы содержит путь и ID последней записи; путь не может содержать нулевой байт.
+func formatFolderPageToken(last FolderEntry) string {
+	var id string
+	if last.Folder != nil {
+		id = last.Folder.ID
+	} else {
+		id = last.File.ID
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(last.Path + "\x00" + id))
+}
+
+func parseFolderPageToken(token string) (path, id string, err error) {
+	if token == "" {
+		return "", "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		var ok bool
+		path, id, ok = strings.Cut(string(raw), "\x00")
+		if ok && path != "" && uuidRegexp.MatchString(id) {
+			return path, id, nil
+		}
+	}
+	return "", "", status.Error(codes.InvalidArgument, "invalid page token")
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) CreateDownloadLink(ctx context.Context, req *pb.CreateDownloadLinkRequest) (*pb.CreateDownloadLinkResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LinkSigner == nil {
+		return nil, status.Error(codes.FailedPrecondition, "download links are not configured")
+	}
+
+	ttl := time.Duration(req.TtlSeconds) * time.Second
+	if ttl == 0 {
+		ttl = s.DefaultLinkTTL
+	}
+	if ttl < 0 || ttl > s.MaxLinkTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "ttl must be between 1 and %d seconds", int64(s.MaxLinkTTL.Seconds()))
+	}
+	if req.MaxUses < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max uses must not be negative")
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	revision, err := s.FileRepository.FindRevision(ctx, req.Id)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find file revision: %v", err))
+		return nil, err
+	}
+	if revision == 0 {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	claims := LinkClaims{FileID: req.Id, ExpiresAt: time.Now().Add(ttl).Unix()}
+	if req.MaxUses > 0 {
+		link := DownloadLink{FileID: req.Id, MaxUses: req.MaxUses}
+		if err = s.FileRepository.CreateDownloadLink(ctx, &link, ttl); err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to create download link: %v", err))
+			return nil, err
+		}
+		claims.LinkID = link.ID
+	}
+
+	token, err := s.LinkSigner.Sign(claims)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to sign download link: %v", err))
+		return nil, err
+	}
+
+	s.Logger.Info(fmt.Sprintf("Download link created for %s, expires at %d", req.Id, claims.ExpiresAt))
+	return &pb.CreateDownloadLinkResponse{
+		Url:       strings.TrimSuffix(s.LinkBaseURL, "/") + "/links/" + token,
+		Token:     token,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// DownloadByLink не требует других учетных данных: доступ дает сама подписанная ссылка.
+func (s *Server) DownloadByLink(ctx context.Context, req *pb.DownloadByLinkRequest) (*pb.DownloadFileResponse, error) {
+	if s.LinkSigner == nil {
+		return nil, status.Error(codes.FailedPrecondition, "download links are not configured")
+	}
+
+	claims, err := s.LinkSigner.Verify(req.Token, time.Now())
+	if err != nil {
+		return nil, linkError(err)
+	}
+
+	release, err := s.admitDownload(ctx, []string{claims.FileID}, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// использование ссылки списывается, только если файл удалось прочитать
+	var fl File
+	err = s.FileRepository.WithTx(ctx, func(ctx context.Context) error {
+		if claims.LinkID != "" {
+			if err := s.FileRepository.UseDownloadLink(ctx, claims.LinkID); err != nil {
+				if !errors.Is(err, ErrLinkExpired) {
+					s.Logger.Error(fmt.Sprintf("Failed to use download link: %v", err))
+				}
+				return linkError(err)
+			}
+		}
+
+		var err error
+		fl, err = s.FileRepository.FindOne(ctx, claims.FileID)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to find file: %v", err))
+			return err
+		}
+		if fl.ID == "" {
+			return status.Errorf(codes.NotFound, "file %s not found", claims.FileID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.chargeBytes(ctx, downloadClass, int64(len(fl.Data)))
+	return &pb.DownloadFileResponse{FileName: fl.Name, Data: fl.Data, CreatedAt: fl.CreatedAt.Unix(), UpdatedAt: fl.UpdatedAt.Unix(), Version: fl.Version, Etag: formatETag(fl.Revision)}, nil
+}
+
+func linkError(err error) error {
+	if errors.Is(err, ErrLinkInvalid) || errors.Is(err, ErrLinkExpired) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return err
+}
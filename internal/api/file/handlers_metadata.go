@@ -0,0 +1,196 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxTags              = 50
+	maxTagLength         = 64
+	maxDescriptionLength = 1000
+	// совпадает с ограничением files_metadata_keys_check (миграция 14_metadata_key_limit)
+	maxMetadataKeys      = 50
+	maxMetadataValueSize = 1024
+
+	metadataPathPrefix = "metadata."
+)
+
+// ключ должен оставаться допустимым путем маски metadata.<ключ>
+var metadataKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func (s *Server) UpdateFileMetadata(ctx context.Context, req *pb.UpdateFileMetadataRequest) (*pb.UpdateFileMetadataResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := parseETag(req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	update, err := metadataUpdate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fl, err := s.FileRepository.UpdateMetadata(ctx, req.Id, update, revision)
+	if errors.Is(err, ErrTooManyMetadataKeys) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to update file metadata: %v", err))
+		return nil, preconditionError(err, req.Id)
+	}
+	if fl.ID == "" {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.Id)
+	}
+
+	s.Logger.Info(fmt.Sprintf("File metadata updated: %s", fl.ID))
+	return &pb.UpdateFileMetadataResponse{File: fileMetadata(fl.File, fl.Size)}, nil
+}
+
+// metadataUpdate переводит маску полей запроса в MetadataUpdate и проверяет изменяемые значения.
+func metadataUpdate(req *pb.UpdateFileMetadataRequest) (MetadataUpdate, error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"tags", "description", "metadata"}
+	}
+
+	var update MetadataUpdate
+	var err error
+	keyPaths := false
+	for _, path := range paths {
+		switch {
+		case path == "tags":
+			update.SetTags = true
+			if update.Tags, err = normalizeTags(req.Tags); err != nil {
+				return MetadataUpdate{}, err
+			}
+		case path == "description":
+			update.SetDescription = true
+			update.Description = req.Description
+		case path == "metadata":
+			update.ReplaceMetadata = true
+			update.Metadata = req.Metadata
+		case strings.HasPrefix(path, metadataPathPrefix):
+			keyPaths = true
+			key := strings.TrimPrefix(path, metadataPathPrefix)
+			if value, ok := req.Metadata[key]; ok {
+				if update.Metadata == nil {
+					update.Metadata = make(map[string]string)
+				}
+				update.Metadata[key] = value
+			} else {
+				update.DeleteKeys = append(update.DeleteKeys, key)
+			}
+		default:
+			return MetadataUpdate{}, status.Errorf(codes.InvalidArgument, "unknown update mask path %q", path)
+		}
+	}
+
+	if update.ReplaceMetadata && keyPaths {
+		return MetadataUpdate{}, status.Error(codes.InvalidArgument, "update mask cannot contain both metadata and metadata.<key>")
+	}
+	for _, key := range update.DeleteKeys {
+		if !metadataKeyRegexp.MatchString(key) {
+			return MetadataUpdate{}, status.Errorf(codes.InvalidArgument, "invalid metadata key %q", key)
+		}
+	}
+	if err = validateDescription(update.Description); err != nil {
+		return MetadataUpdate{}, err
+	}
+	if err = validateMetadata(update.Metadata); err != nil {
+		return MetadataUpdate{}, err
+	}
+	return update, nil
+}
+
+// normalizeTags приводит теги к нижнему регистру и убирает повторы, сохраняя порядок.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d tags are allowed", maxTags)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.ContainsFunc(tag, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		}) {
+			return nil, status.Errorf(codes.InvalidArgument, "tag %q must be 1 to %d characters without spaces and commas", tag, maxTagLength)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+func validateDescription(description string) error {
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return status.Errorf(codes.InvalidArgument, "description is longer than %d characters", maxDescriptionLength)
+	}
+	return nil
+}
+
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return status.Errorf(codes.InvalidArgument, "at most %d metadata keys are allowed", maxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if !metadataKeyRegexp.MatchString(key) {
+			return status.Errorf(codes.InvalidArgument, "invalid metadata key %q: use up to 64 letters, digits, '_', '.' and '-'", key)
+		}
+		if len(value) > maxMetadataValueSize {
+			return status.Errorf(codes.InvalidArgument, "metadata value of %q is longer than %d bytes", key, maxMetadataValueSize)
+		}
+	}
+	return nil
+}
+
+// fileFilter оставляет файлы со всеми тегами и парами метаданных из запроса.
+func fileFilter(req *pb.ListFilesRequest) (FileFilter, error) {
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return FileFilter{}, err
+	}
+	if err = validateMetadata(req.Metadata); err != nil {
+		return FileFilter{}, err
+	}
+	return FileFilter{Tags: tags, Metadata: req.Metadata}, nil
+}
+
+func fileMetadata(fl File, size int64) *pb.FileMetadata {
+	return &pb.FileMetadata{
+		Id:          fl.ID,
+		Name:        fl.Name,
+		Size:        size,
+		CreatedAt:   fl.CreatedAt.Unix(),
+		UpdatedAt:   fl.UpdatedAt.Unix(),
+		Etag:        formatETag(fl.Revision),
+		Tags:        fl.Tags,
+		Description: fl.Description,
+		Metadata:    fl.Metadata,
+		FolderId:    fl.FolderID,
+	}
+}
@@ -0,0 +1,237 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"app/pkg/client/postgresql"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ownerHeader      = "x-owner"
+	adminTokenHeader = "x-admin-token"
+	maxOwnerLength   = 100
+)
+
+// ownerFromContext возвращает владельца по токену из OwnerTokens, без токена - DefaultOwner.
+// Другого владельца в метаданных x-owner может указать только администратор.
+func (s *Server) ownerFromContext(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	owner := DefaultOwner
+	if tokenOwner, ok := lookupToken(md, s.OwnerTokens); ok {
+		owner = tokenOwner
+	}
+	if values := md.Get(ownerHeader); len(values) > 0 && values[0] != "" && values[0] != owner {
+		if !s.isAdmin(ctx) {
+			return "", status.Errorf(codes.PermissionDenied, "credentials do not allow acting as owner %s", values[0])
+		}
+		owner = values[0]
+	}
+
+	if err := validateOwner(owner); err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+func validateOwner(owner string) error {
+	if owner == GlobalOwner || len(owner) > maxOwnerLength {
+		return status.Errorf(codes.InvalidArgument, "owner must not be %q or longer than %d characters", GlobalOwner, maxOwnerLength)
+	}
+	return nil
+}
+
+// requireAdmin пропускает запрос с метаданными x-admin-token, совпадающими с AdminToken.
+// Пустой AdminToken отключает административные методы.
+func (s *Server) requireAdmin(ctx context.Context) error {
+	if s.AdminToken == "" {
+		return status.Error(codes.PermissionDenied, "admin API is disabled")
+	}
+	if !s.isAdmin(ctx) {
+		return status.Error(codes.PermissionDenied, "admin token is required")
+	}
+	return nil
+}
+
+func (s *Server) isAdmin(ctx context.Context) bool {
+	if s.AdminToken == "" {
+		return false
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, token := range md.Get(adminTokenHeader) {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// quotaError переводит *QuotaExceededError в ResourceExhausted с подробностями QuotaFailure,
+// а ErrFileTooLarge - в InvalidArgument.
+func quotaError(err error) error {
+	if errors.Is(err, ErrFileTooLarge) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return err
+	}
+
+	subject := "owner:" + quotaErr.Owner
+	switch {
+	case quotaErr.Bucket == DeploymentBucket:
+		subject = "deployment"
+	case quotaErr.Owner == GlobalOwner:
+		subject = "bucket:" + quotaErr.Bucket
+	}
+
+	st := status.New(codes.ResourceExhausted, quotaErr.Error())
+	detailed, detailsErr := st.WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     subject,
+			Description: fmt.Sprintf("bytes %d/%d, files %d/%d (0 - unlimited)", quotaErr.Bytes, quotaErr.MaxBytes, quotaErr.Files, quotaErr.MaxFiles),
+		}},
+	})
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func (s *Server) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := s.ownerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Owner != "" && req.Owner != owner {
+		if err = s.requireAdmin(ctx); err != nil {
+			return nil, err
+		}
+		owner = req.Owner
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	usage, err := s.FileRepository.GetUsage(ctx, owner)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to get usage: %v", err))
+		return nil, err
+	}
+	global, err := s.FileRepository.GetUsage(ctx, GlobalOwner)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to get global usage: %v", err))
+		return nil, err
+	}
+
+	res := &pb.GetUsageResponse{Usage: usageToProto(usage), Global: usageToProto(global)}
+	if s.isAdmin(ctx) {
+		// квота всего сервиса читается без бакета: RLS скрывает ее от роли бакета
+		deployment, err := s.FileRepository.GetDeploymentUsage(postgresql.WithTenant(ctx, ""))
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to get deployment usage: %v", err))
+			return nil, err
+		}
+		res.Deployment = usageToProto(deployment)
+	}
+
+	return res, nil
+}
+
+func (s *Server) SetQuota(ctx context.Context, req *pb.SetQuotaRequest) (*pb.SetQuotaResponse, error) {
+	if req.Deployment {
+		return s.setDeploymentQuota(ctx, req)
+	}
+
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Owner == "" || (req.Owner != GlobalOwner && len(req.Owner) > maxOwnerLength) {
+		return nil, status.Errorf(codes.InvalidArgument, "owner is required and must not be longer than %d characters", maxOwnerLength)
+	}
+	if req.MaxBytes < 0 || req.MaxFiles < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limits must not be negative")
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := s.FileRepository.SetQuota(ctx, req.Owner, req.MaxBytes, req.MaxFiles); err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to set quota: %v", err))
+		return nil, err
+	}
+
+	usage, err := s.FileRepository.GetUsage(ctx, req.Owner)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to get usage: %v", err))
+		return nil, err
+	}
+
+	s.Logger.Info(fmt.Sprintf("Quota set for %s: %d bytes, %d files", req.Owner, req.MaxBytes, req.MaxFiles))
+	return &pb.SetQuotaResponse{Usage: usageToProto(usage)}, nil
+}
+
+// setDeploymentQuota задает квоту всех бакетов вместе; она проверяется вместе с квотой
+// бакета, поэтому бакеты не могут в сумме превысить ее.
+func (s *Server) setDeploymentQuota(ctx context.Context, req *pb.SetQuotaRequest) (*pb.SetQuotaResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Owner != "" && req.Owner != GlobalOwner {
+		return nil, status.Error(codes.InvalidArgument, "deployment quota applies to all owners, owner must be empty")
+	}
+	if req.MaxBytes < 0 || req.MaxFiles < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limits must not be negative")
+	}
+	ctx = postgresql.WithTenant(ctx, "")
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := s.FileRepository.SetDeploymentQuota(ctx, req.MaxBytes, req.MaxFiles); err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to set deployment quota: %v", err))
+		return nil, err
+	}
+
+	usage, err := s.FileRepository.GetDeploymentUsage(ctx)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to get deployment usage: %v", err))
+		return nil, err
+	}
+
+	s.Logger.Info(fmt.Sprintf("Deployment quota set: %d bytes, %d files", req.MaxBytes, req.MaxFiles))
+	return &pb.SetQuotaResponse{Usage: usageToProto(usage)}, nil
+}
+
+func usageToProto(u Usage) *pb.Usage {
+	return &pb.Usage{Owner: u.Owner, Bucket: u.Bucket, Bytes: u.Bytes, Files: u.Files, MaxBytes: u.MaxBytes, MaxFiles: u.MaxFiles}
+}
@@ -0,0 +1,194 @@
+package file
+
+import (
+	"app/internal/api/ratelimit"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	retryAfterHeader = "retry-after"
+	priorityHeader   = "x-priority"
+
+	// за это время ожидания приоритет запроса в очереди растет на уровень
+	defaultPriorityAging = 5 * time.Second
+)
+
+// RateLimit - бюджеты одного класса методов на клиента; nil-лимитер не ограничивает.
+// Bytes учитывает переданные данные: загрузки списываются до обработки, скачивания - после.
+type RateLimit struct {
+	Requests *ratelimit.Limiter
+	Bytes    *ratelimit.Limiter
+}
+
+// rpcClass - группа методов с общими слотами и лимитами.
+type rpcClass int
+
+const (
+	uploadClass rpcClass = iota
+	downloadClass
+	listClass
+)
+
+func (s *Server) class(class rpcClass) (*ratelimit.FairQueue, RateLimit) {
+	switch class {
+	case uploadClass:
+		return s.UploadSemaphore, s.UploadRateLimit
+	case downloadClass:
+		return s.DownloadSemaphore, s.DownloadRateLimit
+	default:
+		return s.ListSemaphore, s.ListRateLimit
+	}
+}
+
+// admit проверяет лимиты клиента и занимает слот класса. transfer - объем данных запроса,
+// списываемый с лимита байтов; weight - объем данных в памяти на время обработки,
+// занимающий бюджет класса. Вызывающий обязан вызвать release после обработки.
+func (s *Server) admit(ctx context.Context, class rpcClass, transfer, weight int64) (release func(), err error) {
+	if err = s.checkRate(ctx, class, transfer); err != nil {
+		return nil, err
+	}
+	return s.acquire(ctx, class, weight)
+}
+
+// admitDownload занимает слот скачивания с весом, равным размеру файлов ids
+// (версии version, 0 - текущей). Без бюджета скачиваний размер не запрашивается.
+func (s *Server) admitDownload(ctx context.Context, ids []string, version int32) (release func(), err error) {
+	if err = s.checkRate(ctx, downloadClass, 0); err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if s.DownloadSemaphore.Budget() > 0 {
+		size, err = s.FileRepository.SumSizes(ctx, validUUIDs(ids), version)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to find file sizes: %v", err))
+			return nil, err
+		}
+	}
+	return s.acquire(ctx, downloadClass, size)
+}
+
+func (s *Server) checkRate(ctx context.Context, class rpcClass, transfer int64) error {
+	// неверный приоритет отклоняется до списания бюджета клиента
+	if _, err := s.requestPriority(ctx); err != nil {
+		return err
+	}
+
+	_, limit := s.class(class)
+	key := s.clientKey(ctx)
+
+	if wait, ok := limit.Requests.Take(key, 1); !ok {
+		return rateLimitError(ctx, wait, "request rate")
+	}
+	// при transfer == 0 проверяется только долг за ранее отданные данные
+	if wait, ok := limit.Bytes.Take(key, float64(transfer)); !ok {
+		return rateLimitError(ctx, wait, "byte rate")
+	}
+	return nil
+}
+
+func (s *Server) acquire(ctx context.Context, class rpcClass, weight int64) (func(), error) {
+	priority, err := s.requestPriority(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, _ := s.class(class)
+	release, err := slots.Acquire(ctx, s.clientKey(ctx), priority, weight)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return release, nil
+}
+
+// QueueDepth возвращает число ожидающих слота запросов по классам и приоритетам.
+func (s *Server) QueueDepth() map[string]map[string]int {
+	depth := make(map[string]map[string]int, 3)
+	for name, slots := range map[string]*ratelimit.FairQueue{"upload": s.UploadSemaphore, "download": s.DownloadSemaphore, "list": s.ListSemaphore} {
+		byPriority := make(map[string]int)
+		for priority, n := range slots.WaitingByPriority() {
+			byPriority[priority.String()] = n
+		}
+		depth[name] = byPriority
+	}
+	return depth
+}
+
+// chargeBytes списывает объем ответа, который неизвестен до обработки запроса.
+func (s *Server) chargeBytes(ctx context.Context, class rpcClass, bytes int64) {
+	_, limit := s.class(class)
+	limit.Bytes.Charge(s.clientKey(ctx), float64(bytes))
+}
+
+// requestPriority берет приоритет из метаданных x-priority (low, normal или high),
+// по умолчанию normal. Высокий приоритет доступен только с x-admin-token,
+// низкий клиенты выбирают сами для фоновых задач вроде импорта.
+func (s *Server) requestPriority(ctx context.Context) (ratelimit.Priority, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(priorityHeader)
+	if len(values) == 0 || values[0] == "" {
+		return ratelimit.PriorityNormal, nil
+	}
+
+	priority, ok := ratelimit.ParsePriority(values[0])
+	if !ok {
+		return 0, status.Errorf(codes.InvalidArgument, "unknown priority %q", values[0])
+	}
+	if priority == ratelimit.PriorityHigh && !s.isAdmin(ctx) {
+		return 0, status.Error(codes.PermissionDenied, "high priority requires admin token")
+	}
+	return priority, nil
+}
+
+// clientKey определяет клиента по токену из BucketTokens или OwnerTokens, а без проверенного
+// токена - по IP-адресу: непроверенный заголовок authorization не должен давать новый бюджет.
+func (s *Server) clientKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	_, bucketToken := s.tokenBucket(md)
+	_, ownerToken := lookupToken(md, s.OwnerTokens)
+	if bucketToken || ownerToken {
+		sum := sha256.Sum256([]byte(md.Get("authorization")[0]))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "ip:" + addr
+	}
+	return "unknown"
+}
+
+// rateLimitError сообщает время до повтора и в RetryInfo, и в заголовке retry-after
+// (целые секунды) для клиентов, не разбирающих детали ошибки.
+func rateLimitError(ctx context.Context, wait time.Duration, budget string) error {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	// вне gRPC-вызова (например, из HTTP-шлюза) заголовок установить некуда
+	_ = grpc.SetHeader(ctx, metadata.Pairs(retryAfterHeader, strconv.FormatInt(seconds, 10)))
+
+	st := status.Newf(codes.ResourceExhausted, "%s limit exceeded, retry in %ds", budget, seconds)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,153 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxSearchQueryLength = 200
+	// результаты ранжируются, поэтому страницы выбираются смещением; глубокие смещения дороги
+	maxSearchResults = 10000
+)
+
+func (s *Server) SearchFiles(ctx context.Context, req *pb.SearchFilesRequest) (*pb.SearchFilesResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.TrimSpace(req.Query)
+	if query == "" || utf8.RuneCountInString(query) > maxSearchQueryLength {
+		return nil, status.Errorf(codes.InvalidArgument, "query must be between 1 and %d characters", maxSearchQueryLength)
+	}
+	if req.PageSize < 0 || req.PageSize > maxListPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page size must be between 0 and %d", maxListPageSize)
+	}
+	offset, err := parseSearchPageToken(req.PageToken, query)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = defaultListPageSize
+	}
+	pageSize = min(pageSize, maxSearchResults-offset)
+
+	// лишняя запись показывает, есть ли следующая страница
+	found, err := s.FileRepository.Search(ctx, query, pageSize+1, offset)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to search files: %v", err))
+		return nil, err
+	}
+
+	var nextPageToken string
+	if len(found) > pageSize {
+		found = found[:pageSize]
+		if offset+pageSize < maxSearchResults {
+			nextPageToken = formatSearchPageToken(offset+pageSize, query)
+		}
+	}
+
+	terms := searchTerms(query)
+	results := make([]*pb.SearchResult, 0, len(found))
+	for _, res := range found {
+		results = append(results, &pb.SearchResult{
+			File:        fileMetadata(res.File, res.Size),
+			Rank:        res.Rank,
+			NameMatches: matchRanges(res.Name, terms),
+			Highlight:   res.Highlight,
+		})
+	}
+
+	return &pb.SearchFilesResponse{Results: results, NextPageToken: nextPageToken}, nil
+}
+
+// Токен страницы содержит смещение и хеш запроса, чтобы его нельзя было применить к другому запросу.
+func formatSearchPageToken(offset int, query string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + queryHash(query)))
+}
+
+func parseSearchPageToken(token, query string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		offsetStr, hash, ok := strings.Cut(string(raw), ":")
+		offset, convErr := strconv.Atoi(offsetStr)
+		if ok && convErr == nil && offset > 0 && offset < maxSearchResults && hash == queryHash(query) {
+			return offset, nil
+		}
+	}
+	return 0, status.Error(codes.InvalidArgument, "invalid page token")
+}
+
+func queryHash(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(query))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// searchTerms выделяет из запроса слова в нижнем регистре, пропуская исключенные (-слово),
+// операторы и знаки препинания.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(strings.Map(unicode.ToLower, query)) {
+		if strings.HasPrefix(field, "-") || field == "or" {
+			continue
+		}
+		terms = append(terms, strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+	return terms
+}
+
+// matchRanges находит вхождения terms в name без учета регистра и объединяет пересекающиеся.
+// Диапазоны считаются в символах, а не байтах.
+func matchRanges(name string, terms []string) []*pb.TextRange {
+	// посимвольно, чтобы смена регистра не сдвигала позиции
+	runes := []rune(strings.Map(unicode.ToLower, name))
+	matched := make([]bool, len(runes))
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(runes); i++ {
+			if string(runes[i:i+len(t)]) == term {
+				for j := i; j < i+len(t); j++ {
+					matched[j] = true
+				}
+			}
+		}
+	}
+
+	var ranges []*pb.TextRange
+	for i := 0; i < len(matched); i++ {
+		if !matched[i] {
+			continue
+		}
+		start := i
+		for i < len(matched) && matched[i] {
+			i++
+		}
+		ranges = append(ranges, &pb.TextRange{Start: int32(start), End: int32(i)})
+	}
+	return ranges
+}
@@ -0,0 +1,163 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) CreateUploadSession(ctx context.Context, req *pb.CreateUploadSessionRequest) (*pb.CreateUploadSessionResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = validateFileName(req.FileName); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.TotalSize <= 0 || req.TotalSize > s.MaxUploadSessionSize {
+		return nil, status.Errorf(codes.InvalidArgument, "total size must be between 1 and %d bytes", s.MaxUploadSessionSize)
+	}
+	checksum, err := hex.DecodeString(req.Sha256)
+	if err != nil || len(checksum) != 32 {
+		return nil, status.Error(codes.InvalidArgument, "sha256 must be a hex-encoded SHA-256 digest")
+	}
+	if err = validateFolderID(req.FolderId); err != nil {
+		return nil, err
+	}
+
+	owner, err := s.ownerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, uploadClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// квота проверяется при завершении сессии, когда файл записывается в базу
+	session := UploadSession{Name: req.FileName, TotalSize: req.TotalSize, Checksum: hex.EncodeToString(checksum), Owner: owner, FolderID: req.FolderId}
+
+	err = s.FileRepository.CreateUploadSession(ctx, &session, s.UploadSessionTTL)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to create upload session: %v", err))
+		return nil, folderError(err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Upload session created: %s for %s (%d bytes)", session.ID, session.Name, session.TotalSize))
+	return &pb.CreateUploadSessionResponse{SessionId: session.ID, ExpiresAt: session.ExpiresAt.Unix()}, nil
+}
+
+func (s *Server) UploadChunk(ctx context.Context, req *pb.UploadChunkRequest) (*pb.UploadChunkResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Data) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "chunk data is empty")
+	}
+
+	size := int64(len(req.Data))
+	release, err := s.admit(ctx, uploadClass, size, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	session, err := s.FileRepository.AppendUploadChunk(ctx, req.SessionId, req.Offset, req.Data, s.UploadSessionTTL)
+	if err != nil {
+		return nil, s.uploadSessionError(err, req.SessionId)
+	}
+
+	return &pb.UploadChunkResponse{ReceivedSize: session.ReceivedSize, ExpiresAt: session.ExpiresAt.Unix()}, nil
+}
+
+func (s *Server) GetUploadSessionStatus(ctx context.Context, req *pb.GetUploadSessionStatusRequest) (*pb.GetUploadSessionStatusResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.admit(ctx, listClass, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	session, err := s.FileRepository.FindUploadSession(ctx, req.SessionId)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("Failed to find upload session: %v", err))
+		return nil, err
+	}
+	if session.ID == "" {
+		return nil, status.Errorf(codes.NotFound, "upload session %s not found or expired", req.SessionId)
+	}
+
+	return &pb.GetUploadSessionStatusResponse{
+		FileName:     session.Name,
+		TotalSize:    session.TotalSize,
+		ReceivedSize: session.ReceivedSize,
+		ExpiresAt:    session.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *Server) CompleteUploadSession(ctx context.Context, req *pb.CompleteUploadSessionRequest) (*pb.CompleteUploadSessionResponse, error) {
+	ctx, err := s.bucketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkRate(ctx, uploadClass, 0); err != nil {
+		return nil, err
+	}
+
+	// файл собирается из частей в памяти; с лимитом байтов части списаны при загрузке
+	var size int64
+	if s.UploadSemaphore.Budget() > 0 {
+		session, err := s.FileRepository.FindUploadSession(ctx, req.SessionId)
+		if err != nil {
+			return nil, s.uploadSessionError(err, req.SessionId)
+		}
+		size = session.TotalSize
+	}
+
+	release, err := s.acquire(ctx, uploadClass, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fl, err := s.FileRepository.CompleteUploadSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, s.uploadSessionError(err, req.SessionId)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Upload session %s completed: %s", req.SessionId, fl.ID))
+	return &pb.CompleteUploadSessionResponse{Id: fl.ID}, nil
+}
+
+func (s *Server) uploadSessionError(err error, id string) error {
+	switch {
+	case errors.Is(err, ErrUploadSessionNotFound):
+		return status.Errorf(codes.NotFound, "upload session %s not found or expired", id)
+	case errors.Is(err, ErrUploadOffsetMismatch), errors.Is(err, ErrUploadIncomplete):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrUploadSizeExceeded):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, ErrChecksumMismatch):
+		return status.Errorf(codes.DataLoss, "upload session %s: %v", id, err)
+	case errors.As(err, new(*QuotaExceededError)), errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrFolderNotFound), errors.Is(err, ErrNameExists):
+		return folderError(err)
+	}
+
+	s.Logger.Error(fmt.Sprintf("Upload session %s failed: %v", id, err))
+	return err
+}
@@ -0,0 +1,172 @@
+package file
+
+import (
+	pb "app/api/proto"
+	"app/pkg/client/postgresql"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const watchBatchSize = 100
+
+// WatchFiles отправляет события журнала file_events бакета клиента. Подключение проходит
+// проверку частоты, но поток не занимает слотов: ожидание событий не расходует ресурсов.
+// Фильтры применяются к состоянию файла в событии.
+func (s *Server) WatchFiles(req *pb.WatchFilesRequest, stream grpc.ServerStreamingServer[pb.WatchFilesResponse]) error {
+	ctx, err := s.bucketContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return err
+	}
+	cursor, err := parseEventCursor(req.Cursor)
+	if err != nil {
+		return err
+	}
+
+	if err = s.checkRate(ctx, listClass, 0); err != nil {
+		return err
+	}
+
+	// подписка раньше чтения журнала: событие между ними не останется незамеченным
+	wake, cancel := s.Events.subscribe(postgresql.TenantFromContext(ctx))
+	defer cancel()
+
+	if req.Cursor == "" {
+		cursor, err = s.FileRepository.LatestFileEventCursor(ctx)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to find latest file event: %v", err))
+			return err
+		}
+	} else {
+		expired, err := s.FileRepository.FileEventCursorExpired(ctx, cursor)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("Failed to check file event cursor: %v", err))
+			return err
+		}
+		// события после курсора удалены, и клиент мог их пропустить
+		if expired {
+			return status.Error(codes.OutOfRange, "cursor has expired, list files again and watch without cursor")
+		}
+	}
+
+	// без опроса (WatchPollInterval = 0) наблюдатель ждет только уведомлений
+	var poll <-chan time.Time
+	if s.WatchPollInterval > 0 {
+		ticker := time.NewTicker(s.WatchPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	// пульс несет только курсор: клиент, которому фильтр редко пропускает события,
+	// сохраняет свежий курсор, и тот не устаревает вместе с последним полученным событием
+	var heartbeat <-chan time.Time
+	if s.WatchHeartbeatInterval > 0 {
+		ticker := time.NewTicker(s.WatchHeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		for {
+			events, err := s.FileRepository.ListFileEvents(ctx, cursor, watchBatchSize)
+			if err != nil {
+				s.Logger.Error(fmt.Sprintf("Failed to list file events: %v", err))
+				return err
+			}
+
+			for _, e := range events {
+				cursor = e.Cursor
+				if !eventMatches(e, req.NamePrefix, tags) {
+					continue
+				}
+				if err = stream.Send(fileEventToProto(e)); err != nil {
+					return err
+				}
+			}
+
+			if len(events) < watchBatchSize {
+				break
+			}
+		}
+
+		// уведомление может не прийти, например, пока событие ждет завершения более
+		// ранней транзакции; тогда его найдет следующий опрос
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-wake:
+		case <-poll:
+		case <-heartbeat:
+			if err = stream.Send(&pb.WatchFilesResponse{Cursor: formatEventCursor(cursor)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventMatches(e FileEvent, namePrefix string, tags []string) bool {
+	if !strings.HasPrefix(e.File.Name, namePrefix) {
+		return false
+	}
+	for _, tag := range tags {
+		if !slices.Contains(e.File.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func fileEventToProto(e FileEvent) *pb.WatchFilesResponse {
+	var eventType pb.FileEventType
+	switch e.Type {
+	case FileCreated:
+		eventType = pb.FileEventType_FILE_EVENT_TYPE_CREATED
+	case FileUpdated:
+		eventType = pb.FileEventType_FILE_EVENT_TYPE_UPDATED
+	case FileDeleted:
+		eventType = pb.FileEventType_FILE_EVENT_TYPE_DELETED
+	}
+
+	return &pb.WatchFilesResponse{
+		Type:      eventType,
+		File:      fileMetadata(e.File.File, e.File.Size),
+		EventTime: e.CreatedAt.Unix(),
+		Cursor:    formatEventCursor(e.Cursor),
+	}
+}
+
+func formatEventCursor(c FileEventCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(c.TxID, 10) + ":" + strconv.FormatInt(c.ID, 10)))
+}
+
+func parseEventCursor(cursor string) (FileEventCursor, error) {
+	if cursor == "" {
+		return FileEventCursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if txID, id, ok := strings.Cut(string(raw), ":"); ok {
+			var c FileEventCursor
+			c.TxID, err = strconv.ParseUint(txID, 10, 64)
+			if err == nil {
+				c.ID, err = strconv.ParseInt(id, 10, 64)
+			}
+			// ID = 0 - позиция без события, ее отправляет пульс до первого события
+			if err == nil && c.TxID > 0 && c.ID >= 0 {
+				return c, nil
+			}
+		}
+	}
+	return FileEventCursor{}, status.Error(codes.InvalidArgument, "invalid cursor")
+}
@@ -0,0 +1,103 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"app/pkg/logging"
+)
+
+func runPeriodically(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunVersionPruning периодически удаляет версии файлов, вышедшие за пределы политики хранения.
+// Блокируется до отмены ctx.
+func RunVersionPruning(ctx context.Context, logger *logging.Logger, fileRepository FileRepository, keepLast, keepDays int, interval time.Duration) {
+	runPeriodically(ctx, interval, func(ctx context.Context) {
+		n, err := fileRepository.PruneVersions(ctx, keepLast, keepDays)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to prune file versions: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Pruned file versions: %d", n))
+		}
+	})
+}
+
+// RunIdempotencyKeyCleanup периодически удаляет ключи идемпотентности старше ttl.
+// Блокируется до отмены ctx.
+func RunIdempotencyKeyCleanup(ctx context.Context, logger *logging.Logger, fileRepository FileRepository, ttl, interval time.Duration) {
+	runPeriodically(ctx, interval, func(ctx context.Context) {
+		n, err := fileRepository.DeleteExpiredIdempotencyKeys(ctx, ttl)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to delete expired idempotency keys: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Deleted expired idempotency keys: %d", n))
+		}
+	})
+}
+
+// RunUploadSessionCleanup периодически удаляет истекшие сессии загрузки вместе с принятыми частями.
+// Блокируется до отмены ctx.
+func RunUploadSessionCleanup(ctx context.Context, logger *logging.Logger, fileRepository FileRepository, interval time.Duration) {
+	runPeriodically(ctx, interval, func(ctx context.Context) {
+		n, err := fileRepository.DeleteExpiredUploadSessions(ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to delete expired upload sessions: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Deleted expired upload sessions: %d", n))
+		}
+	})
+}
+
+// RunDownloadLinkCleanup периодически удаляет истекшие и исчерпанные ссылки на скачивание.
+// Блокируется до отмены ctx.
+func RunDownloadLinkCleanup(ctx context.Context, logger *logging.Logger, fileRepository FileRepository, interval time.Duration) {
+	runPeriodically(ctx, interval, func(ctx context.Context) {
+		n, err := fileRepository.DeleteExpiredDownloadLinks(ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to delete expired download links: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Deleted expired download links: %d", n))
+		}
+	})
+}
+
+// RunFileEventCleanup периодически удаляет события журнала старше retention.
+// Блокируется до отмены ctx.
+func RunFileEventCleanup(ctx context.Context, logger *logging.Logger, fileRepository FileRepository, retention, interval time.Duration) {
+	runPeriodically(ctx, interval, func(ctx context.Context) {
+		n, err := fileRepository.DeleteExpiredFileEvents(ctx, retention)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to delete expired file events: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Deleted expired file events: %d", n))
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package file
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const minLinkKeyLength = 32
+
+// LinkClaims - подписанное содержимое ссылки на скачивание.
+// LinkID заполняется только для ссылок с ограничением числа скачиваний.
+type LinkClaims struct {
+	KeyID     string `json:"k"`
+	FileID    string `json:"f"`
+	ExpiresAt int64  `json:"e"`
+	LinkID    string `json:"l,omitempty"`
+}
+
+// LinkSigner подписывает ссылки активным ключом и проверяет подпись любым из ключей,
+// что позволяет менять ключи без отзыва уже выданных ссылок.
+type LinkSigner struct {
+	activeKey string
+	keys      map[string][]byte
+}
+
+func NewLinkSigner(activeKey string, keys map[string]string) (*LinkSigner, error) {
+	if _, ok := keys[activeKey]; !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeKey)
+	}
+
+	signer := &LinkSigner{activeKey: activeKey, keys: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		if len(secret) < minLinkKeyLength {
+			return nil, fmt.Errorf("signing key %q must be at least %d bytes", id, minLinkKeyLength)
+		}
+		signer.keys[id] = []byte(secret)
+	}
+
+	return signer, nil
+}
+
+// Sign возвращает токен вида base64(claims).base64(hmac).
+func (s *LinkSigner) Sign(claims LinkClaims) (string, error) {
+	claims.KeyID = s.activeKey
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.keys[s.activeKey], encoded)), nil
+}
+
+func (s *LinkSigner) Verify(token string, now time.Time) (LinkClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return LinkClaims{}, ErrLinkInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return LinkClaims{}, ErrLinkInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return LinkClaims{}, ErrLinkInvalid
+	}
+
+	var claims LinkClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return LinkClaims{}, ErrLinkInvalid
+	}
+	key, ok := s.keys[claims.KeyID]
+	if !ok || !hmac.Equal(mac, s.mac(key, encoded)) {
+		return LinkClaims{}, ErrLinkInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return LinkClaims{}, ErrLinkExpired
+	}
+
+	return claims, nil
+}
+
+func (s *LinkSigner) mac(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
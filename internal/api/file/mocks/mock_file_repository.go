@@ -8,6 +8,7 @@ import (
 	file "app/internal/api/file"
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -35,62 +36,343 @@ func (m *MockFileRepository) EXPECT() *MockFileRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AppendUploadChunk mocks base method.
+func (m *MockFileRepository) AppendUploadChunk(ctx context.Context, id string, offset int64, data []byte, ttl time.Duration) (file.UploadSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendUploadChunk", ctx, id, offset, data, ttl)
+	ret0, _ := ret[0].(file.UploadSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AppendUploadChunk indicates an expected call of AppendUploadChunk.
+func (mr *MockFileRepositoryMockRecorder) AppendUploadChunk(ctx, id, offset, data, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendUploadChunk", reflect.TypeOf((*MockFileRepository)(nil).AppendUploadChunk), ctx, id, offset, data, ttl)
+}
+
+// CompleteUploadSession mocks base method.
+func (m *MockFileRepository) CompleteUploadSession(ctx context.Context, id string) (file.File, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteUploadSession", ctx, id)
+	ret0, _ := ret[0].(file.File)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompleteUploadSession indicates an expected call of CompleteUploadSession.
+func (mr *MockFileRepositoryMockRecorder) CompleteUploadSession(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteUploadSession", reflect.TypeOf((*MockFileRepository)(nil).CompleteUploadSession), ctx, id)
+}
+
 // Create mocks base method.
-func (m *MockFileRepository) Create(ctx context.Context, file *file.File) error {
+func (m *MockFileRepository) Create(ctx context.Context, fl *file.File) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", ctx, file)
+	ret := m.ctrl.Call(m, "Create", ctx, fl)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Create indicates an expected call of Create.
-func (mr *MockFileRepositoryMockRecorder) Create(ctx, file interface{}) *gomock.Call {
+func (mr *MockFileRepositoryMockRecorder) Create(ctx, fl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockFileRepository)(nil).Create), ctx, fl)
+}
+
+// CreateBucket mocks base method.
+func (m *MockFileRepository) CreateBucket(ctx context.Context, bucket *file.Bucket) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBucket", ctx, bucket)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBucket indicates an expected call of CreateBucket.
+func (mr *MockFileRepositoryMockRecorder) CreateBucket(ctx, bucket interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBucket", reflect.TypeOf((*MockFileRepository)(nil).CreateBucket), ctx, bucket)
+}
+
+// CreateDownloadLink mocks base method.
+func (m *MockFileRepository) CreateDownloadLink(ctx context.Context, link *file.DownloadLink, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDownloadLink", ctx, link, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDownloadLink indicates an expected call of CreateDownloadLink.
+func (mr *MockFileRepositoryMockRecorder) CreateDownloadLink(ctx, link, ttl interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockFileRepository)(nil).Create), ctx, file)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDownloadLink", reflect.TypeOf((*MockFileRepository)(nil).CreateDownloadLink), ctx, link, ttl)
 }
 
-// CreateTables mocks base method.
-func (m *MockFileRepository) CreateTables(ctx context.Context) error {
+// CreateEach mocks base method.
+func (m *MockFileRepository) CreateEach(ctx context.Context, files []*file.File) ([]error, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateTables", ctx)
+	ret := m.ctrl.Call(m, "CreateEach", ctx, files)
+	ret0, _ := ret[0].([]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateEach indicates an expected call of CreateEach.
+func (mr *MockFileRepositoryMockRecorder) CreateEach(ctx, files interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEach", reflect.TypeOf((*MockFileRepository)(nil).CreateEach), ctx, files)
+}
+
+// CreateFolder mocks base method.
+func (m *MockFileRepository) CreateFolder(ctx context.Context, folder *file.Folder) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateFolder", ctx, folder)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// CreateTables indicates an expected call of CreateTables.
-func (mr *MockFileRepositoryMockRecorder) CreateTables(ctx interface{}) *gomock.Call {
+// CreateFolder indicates an expected call of CreateFolder.
+func (mr *MockFileRepositoryMockRecorder) CreateFolder(ctx, folder interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTables", reflect.TypeOf((*MockFileRepository)(nil).CreateTables), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateFolder", reflect.TypeOf((*MockFileRepository)(nil).CreateFolder), ctx, folder)
+}
+
+// CreateIdempotent mocks base method.
+func (m *MockFileRepository) CreateIdempotent(ctx context.Context, fl *file.File, key, fingerprint string, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdempotent", ctx, fl, key, fingerprint, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateIdempotent indicates an expected call of CreateIdempotent.
+func (mr *MockFileRepositoryMockRecorder) CreateIdempotent(ctx, fl, key, fingerprint, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdempotent", reflect.TypeOf((*MockFileRepository)(nil).CreateIdempotent), ctx, fl, key, fingerprint, ttl)
+}
+
+// CreateMany mocks base method.
+func (m *MockFileRepository) CreateMany(ctx context.Context, files []*file.File) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMany", ctx, files)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateMany indicates an expected call of CreateMany.
+func (mr *MockFileRepositoryMockRecorder) CreateMany(ctx, files interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMany", reflect.TypeOf((*MockFileRepository)(nil).CreateMany), ctx, files)
+}
+
+// CreateUploadSession mocks base method.
+func (m *MockFileRepository) CreateUploadSession(ctx context.Context, session *file.UploadSession, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUploadSession", ctx, session, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUploadSession indicates an expected call of CreateUploadSession.
+func (mr *MockFileRepositoryMockRecorder) CreateUploadSession(ctx, session, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUploadSession", reflect.TypeOf((*MockFileRepository)(nil).CreateUploadSession), ctx, session, ttl)
 }
 
 // Delete mocks base method.
-func (m *MockFileRepository) Delete(ctx context.Context, id string) ([]string, error) {
+func (m *MockFileRepository) Delete(ctx context.Context, id string, revision int64) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", ctx, id)
+	ret := m.ctrl.Call(m, "Delete", ctx, id, revision)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockFileRepositoryMockRecorder) Delete(ctx, id interface{}) *gomock.Call {
+func (mr *MockFileRepositoryMockRecorder) Delete(ctx, id, revision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFileRepository)(nil).Delete), ctx, id, revision)
+}
+
+// DeleteBucket mocks base method.
+func (m *MockFileRepository) DeleteBucket(ctx context.Context, name string, force bool) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBucket", ctx, name, force)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteBucket indicates an expected call of DeleteBucket.
+func (mr *MockFileRepositoryMockRecorder) DeleteBucket(ctx, name, force interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBucket", reflect.TypeOf((*MockFileRepository)(nil).DeleteBucket), ctx, name, force)
+}
+
+// DeleteExpiredDownloadLinks mocks base method.
+func (m *MockFileRepository) DeleteExpiredDownloadLinks(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredDownloadLinks", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredDownloadLinks indicates an expected call of DeleteExpiredDownloadLinks.
+func (mr *MockFileRepositoryMockRecorder) DeleteExpiredDownloadLinks(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredDownloadLinks", reflect.TypeOf((*MockFileRepository)(nil).DeleteExpiredDownloadLinks), ctx)
+}
+
+// DeleteExpiredFileEvents mocks base method.
+func (m *MockFileRepository) DeleteExpiredFileEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredFileEvents", ctx, retention)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredFileEvents indicates an expected call of DeleteExpiredFileEvents.
+func (mr *MockFileRepositoryMockRecorder) DeleteExpiredFileEvents(ctx, retention interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredFileEvents", reflect.TypeOf((*MockFileRepository)(nil).DeleteExpiredFileEvents), ctx, retention)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockFileRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx, ttl)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockFileRepositoryMockRecorder) DeleteExpiredIdempotencyKeys(ctx, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockFileRepository)(nil).DeleteExpiredIdempotencyKeys), ctx, ttl)
+}
+
+// DeleteExpiredUploadSessions mocks base method.
+func (m *MockFileRepository) DeleteExpiredUploadSessions(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredUploadSessions", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredUploadSessions indicates an expected call of DeleteExpiredUploadSessions.
+func (mr *MockFileRepositoryMockRecorder) DeleteExpiredUploadSessions(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredUploadSessions", reflect.TypeOf((*MockFileRepository)(nil).DeleteExpiredUploadSessions), ctx)
+}
+
+// DeleteFolder mocks base method.
+func (m *MockFileRepository) DeleteFolder(ctx context.Context, id string, recursive bool) (int64, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFolder", ctx, id, recursive)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// DeleteFolder indicates an expected call of DeleteFolder.
+func (mr *MockFileRepositoryMockRecorder) DeleteFolder(ctx, id, recursive interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFolder", reflect.TypeOf((*MockFileRepository)(nil).DeleteFolder), ctx, id, recursive)
+}
+
+// DeleteMany mocks base method.
+func (m *MockFileRepository) DeleteMany(ctx context.Context, ids []string, atomic bool) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMany", ctx, ids, atomic)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMany indicates an expected call of DeleteMany.
+func (mr *MockFileRepositoryMockRecorder) DeleteMany(ctx, ids, atomic interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFileRepository)(nil).Delete), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMany", reflect.TypeOf((*MockFileRepository)(nil).DeleteMany), ctx, ids, atomic)
+}
+
+// FileEventCursorExpired mocks base method.
+func (m *MockFileRepository) FileEventCursorExpired(ctx context.Context, cursor file.FileEventCursor) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileEventCursorExpired", ctx, cursor)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FileEventCursorExpired indicates an expected call of FileEventCursorExpired.
+func (mr *MockFileRepositoryMockRecorder) FileEventCursorExpired(ctx, cursor interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileEventCursorExpired", reflect.TypeOf((*MockFileRepository)(nil).FileEventCursorExpired), ctx, cursor)
 }
 
 // FindAll mocks base method.
-func (m *MockFileRepository) FindAll(ctx context.Context) ([]file.File, error) {
+func (m *MockFileRepository) FindAll(ctx context.Context, filter file.FileFilter) ([]file.FileInfo, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindAll", ctx)
-	ret0, _ := ret[0].([]file.File)
+	ret := m.ctrl.Call(m, "FindAll", ctx, filter)
+	ret0, _ := ret[0].([]file.FileInfo)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindAll indicates an expected call of FindAll.
-func (mr *MockFileRepositoryMockRecorder) FindAll(ctx interface{}) *gomock.Call {
+func (mr *MockFileRepositoryMockRecorder) FindAll(ctx, filter interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAll", reflect.TypeOf((*MockFileRepository)(nil).FindAll), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAll", reflect.TypeOf((*MockFileRepository)(nil).FindAll), ctx, filter)
+}
+
+// FindBucket mocks base method.
+func (m *MockFileRepository) FindBucket(ctx context.Context, name string) (file.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindBucket", ctx, name)
+	ret0, _ := ret[0].(file.Bucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindBucket indicates an expected call of FindBucket.
+func (mr *MockFileRepositoryMockRecorder) FindBucket(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindBucket", reflect.TypeOf((*MockFileRepository)(nil).FindBucket), ctx, name)
+}
+
+// FindFolder mocks base method.
+func (m *MockFileRepository) FindFolder(ctx context.Context, id string) (file.Folder, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindFolder", ctx, id)
+	ret0, _ := ret[0].(file.Folder)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindFolder indicates an expected call of FindFolder.
+func (mr *MockFileRepositoryMockRecorder) FindFolder(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindFolder", reflect.TypeOf((*MockFileRepository)(nil).FindFolder), ctx, id)
+}
+
+// FindMany mocks base method.
+func (m *MockFileRepository) FindMany(ctx context.Context, ids []string) ([]file.File, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindMany", ctx, ids)
+	ret0, _ := ret[0].([]file.File)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindMany indicates an expected call of FindMany.
+func (mr *MockFileRepositoryMockRecorder) FindMany(ctx, ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindMany", reflect.TypeOf((*MockFileRepository)(nil).FindMany), ctx, ids)
 }
 
 // FindOne mocks base method.
@@ -108,17 +390,371 @@ func (mr *MockFileRepositoryMockRecorder) FindOne(ctx, id interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindOne", reflect.TypeOf((*MockFileRepository)(nil).FindOne), ctx, id)
 }
 
+// FindPage mocks base method.
+func (m *MockFileRepository) FindPage(ctx context.Context, filter file.FileFilter, afterID string, limit int) ([]file.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindPage", ctx, filter, afterID, limit)
+	ret0, _ := ret[0].([]file.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindPage indicates an expected call of FindPage.
+func (mr *MockFileRepositoryMockRecorder) FindPage(ctx, filter, afterID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindPage", reflect.TypeOf((*MockFileRepository)(nil).FindPage), ctx, filter, afterID, limit)
+}
+
+// FindRevision mocks base method.
+func (m *MockFileRepository) FindRevision(ctx context.Context, id string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindRevision", ctx, id)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindRevision indicates an expected call of FindRevision.
+func (mr *MockFileRepositoryMockRecorder) FindRevision(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindRevision", reflect.TypeOf((*MockFileRepository)(nil).FindRevision), ctx, id)
+}
+
+// FindUploadSession mocks base method.
+func (m *MockFileRepository) FindUploadSession(ctx context.Context, id string) (file.UploadSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindUploadSession", ctx, id)
+	ret0, _ := ret[0].(file.UploadSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindUploadSession indicates an expected call of FindUploadSession.
+func (mr *MockFileRepositoryMockRecorder) FindUploadSession(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindUploadSession", reflect.TypeOf((*MockFileRepository)(nil).FindUploadSession), ctx, id)
+}
+
+// FindVersion mocks base method.
+func (m *MockFileRepository) FindVersion(ctx context.Context, id string, version int32) (file.FileVersion, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindVersion", ctx, id, version)
+	ret0, _ := ret[0].(file.FileVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindVersion indicates an expected call of FindVersion.
+func (mr *MockFileRepositoryMockRecorder) FindVersion(ctx, id, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindVersion", reflect.TypeOf((*MockFileRepository)(nil).FindVersion), ctx, id, version)
+}
+
+// FindVersions mocks base method.
+func (m *MockFileRepository) FindVersions(ctx context.Context, id string) ([]file.FileVersion, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindVersions", ctx, id)
+	ret0, _ := ret[0].([]file.FileVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindVersions indicates an expected call of FindVersions.
+func (mr *MockFileRepositoryMockRecorder) FindVersions(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindVersions", reflect.TypeOf((*MockFileRepository)(nil).FindVersions), ctx, id)
+}
+
+// ForEach mocks base method.
+func (m *MockFileRepository) ForEach(ctx context.Context, ids []string, namePrefix string, fn func(file.File) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForEach", ctx, ids, namePrefix, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForEach indicates an expected call of ForEach.
+func (mr *MockFileRepositoryMockRecorder) ForEach(ctx, ids, namePrefix, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForEach", reflect.TypeOf((*MockFileRepository)(nil).ForEach), ctx, ids, namePrefix, fn)
+}
+
+// GetDeploymentUsage mocks base method.
+func (m *MockFileRepository) GetDeploymentUsage(ctx context.Context) (file.Usage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeploymentUsage", ctx)
+	ret0, _ := ret[0].(file.Usage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeploymentUsage indicates an expected call of GetDeploymentUsage.
+func (mr *MockFileRepositoryMockRecorder) GetDeploymentUsage(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeploymentUsage", reflect.TypeOf((*MockFileRepository)(nil).GetDeploymentUsage), ctx)
+}
+
+// GetUsage mocks base method.
+func (m *MockFileRepository) GetUsage(ctx context.Context, owner string) (file.Usage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsage", ctx, owner)
+	ret0, _ := ret[0].(file.Usage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsage indicates an expected call of GetUsage.
+func (mr *MockFileRepositoryMockRecorder) GetUsage(ctx, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsage", reflect.TypeOf((*MockFileRepository)(nil).GetUsage), ctx, owner)
+}
+
+// LatestFileEventCursor mocks base method.
+func (m *MockFileRepository) LatestFileEventCursor(ctx context.Context) (file.FileEventCursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LatestFileEventCursor", ctx)
+	ret0, _ := ret[0].(file.FileEventCursor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LatestFileEventCursor indicates an expected call of LatestFileEventCursor.
+func (mr *MockFileRepositoryMockRecorder) LatestFileEventCursor(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LatestFileEventCursor", reflect.TypeOf((*MockFileRepository)(nil).LatestFileEventCursor), ctx)
+}
+
+// ListBuckets mocks base method.
+func (m *MockFileRepository) ListBuckets(ctx context.Context) ([]file.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBuckets", ctx)
+	ret0, _ := ret[0].([]file.Bucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBuckets indicates an expected call of ListBuckets.
+func (mr *MockFileRepositoryMockRecorder) ListBuckets(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBuckets", reflect.TypeOf((*MockFileRepository)(nil).ListBuckets), ctx)
+}
+
+// ListFileEvents mocks base method.
+func (m *MockFileRepository) ListFileEvents(ctx context.Context, after file.FileEventCursor, limit int) ([]file.FileEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFileEvents", ctx, after, limit)
+	ret0, _ := ret[0].([]file.FileEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFileEvents indicates an expected call of ListFileEvents.
+func (mr *MockFileRepositoryMockRecorder) ListFileEvents(ctx, after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFileEvents", reflect.TypeOf((*MockFileRepository)(nil).ListFileEvents), ctx, after, limit)
+}
+
+// ListFolder mocks base method.
+func (m *MockFileRepository) ListFolder(ctx context.Context, folderID string, recursive bool, afterPath, afterID string, limit int) ([]file.FolderEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFolder", ctx, folderID, recursive, afterPath, afterID, limit)
+	ret0, _ := ret[0].([]file.FolderEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFolder indicates an expected call of ListFolder.
+func (mr *MockFileRepositoryMockRecorder) ListFolder(ctx, folderID, recursive, afterPath, afterID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFolder", reflect.TypeOf((*MockFileRepository)(nil).ListFolder), ctx, folderID, recursive, afterPath, afterID, limit)
+}
+
+// MeasureArchive mocks base method.
+func (m *MockFileRepository) MeasureArchive(ctx context.Context, ids []string, namePrefix string) (file.ArchiveSize, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MeasureArchive", ctx, ids, namePrefix)
+	ret0, _ := ret[0].(file.ArchiveSize)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MeasureArchive indicates an expected call of MeasureArchive.
+func (mr *MockFileRepositoryMockRecorder) MeasureArchive(ctx, ids, namePrefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MeasureArchive", reflect.TypeOf((*MockFileRepository)(nil).MeasureArchive), ctx, ids, namePrefix)
+}
+
+// MoveFile mocks base method.
+func (m *MockFileRepository) MoveFile(ctx context.Context, id, folderID string, revision int64) (file.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MoveFile", ctx, id, folderID, revision)
+	ret0, _ := ret[0].(file.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MoveFile indicates an expected call of MoveFile.
+func (mr *MockFileRepositoryMockRecorder) MoveFile(ctx, id, folderID, revision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoveFile", reflect.TypeOf((*MockFileRepository)(nil).MoveFile), ctx, id, folderID, revision)
+}
+
+// PruneVersions mocks base method.
+func (m *MockFileRepository) PruneVersions(ctx context.Context, keepLast, keepDays int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneVersions", ctx, keepLast, keepDays)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneVersions indicates an expected call of PruneVersions.
+func (mr *MockFileRepositoryMockRecorder) PruneVersions(ctx, keepLast, keepDays interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneVersions", reflect.TypeOf((*MockFileRepository)(nil).PruneVersions), ctx, keepLast, keepDays)
+}
+
+// RestoreVersion mocks base method.
+func (m *MockFileRepository) RestoreVersion(ctx context.Context, id string, version int32, revision int64) (file.File, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreVersion", ctx, id, version, revision)
+	ret0, _ := ret[0].(file.File)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreVersion indicates an expected call of RestoreVersion.
+func (mr *MockFileRepositoryMockRecorder) RestoreVersion(ctx, id, version, revision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreVersion", reflect.TypeOf((*MockFileRepository)(nil).RestoreVersion), ctx, id, version, revision)
+}
+
+// Search mocks base method.
+func (m *MockFileRepository) Search(ctx context.Context, query string, limit, offset int) ([]file.SearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", ctx, query, limit, offset)
+	ret0, _ := ret[0].([]file.SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockFileRepositoryMockRecorder) Search(ctx, query, limit, offset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockFileRepository)(nil).Search), ctx, query, limit, offset)
+}
+
+// SetDeploymentQuota mocks base method.
+func (m *MockFileRepository) SetDeploymentQuota(ctx context.Context, maxBytes, maxFiles int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetDeploymentQuota", ctx, maxBytes, maxFiles)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDeploymentQuota indicates an expected call of SetDeploymentQuota.
+func (mr *MockFileRepositoryMockRecorder) SetDeploymentQuota(ctx, maxBytes, maxFiles interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDeploymentQuota", reflect.TypeOf((*MockFileRepository)(nil).SetDeploymentQuota), ctx, maxBytes, maxFiles)
+}
+
+// SetQuota mocks base method.
+func (m *MockFileRepository) SetQuota(ctx context.Context, owner string, maxBytes, maxFiles int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetQuota", ctx, owner, maxBytes, maxFiles)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetQuota indicates an expected call of SetQuota.
+func (mr *MockFileRepositoryMockRecorder) SetQuota(ctx, owner, maxBytes, maxFiles interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetQuota", reflect.TypeOf((*MockFileRepository)(nil).SetQuota), ctx, owner, maxBytes, maxFiles)
+}
+
+// SumSizes mocks base method.
+func (m *MockFileRepository) SumSizes(ctx context.Context, ids []string, version int32) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SumSizes", ctx, ids, version)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SumSizes indicates an expected call of SumSizes.
+func (mr *MockFileRepositoryMockRecorder) SumSizes(ctx, ids, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SumSizes", reflect.TypeOf((*MockFileRepository)(nil).SumSizes), ctx, ids, version)
+}
+
 // Update mocks base method.
-func (m *MockFileRepository) Update(ctx context.Context, curUser *file.File) ([]file.File, error) {
+func (m *MockFileRepository) Update(ctx context.Context, fl *file.File, setData bool) ([]file.File, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update", ctx, curUser)
+	ret := m.ctrl.Call(m, "Update", ctx, fl, setData)
 	ret0, _ := ret[0].([]file.File)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Update indicates an expected call of Update.
-func (mr *MockFileRepositoryMockRecorder) Update(ctx, curUser interface{}) *gomock.Call {
+func (mr *MockFileRepositoryMockRecorder) Update(ctx, fl, setData interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockFileRepository)(nil).Update), ctx, fl, setData)
+}
+
+// UpdateBucket mocks base method.
+func (m *MockFileRepository) UpdateBucket(ctx context.Context, bucket *file.Bucket) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBucket", ctx, bucket)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateBucket indicates an expected call of UpdateBucket.
+func (mr *MockFileRepositoryMockRecorder) UpdateBucket(ctx, bucket interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBucket", reflect.TypeOf((*MockFileRepository)(nil).UpdateBucket), ctx, bucket)
+}
+
+// UpdateMetadata mocks base method.
+func (m *MockFileRepository) UpdateMetadata(ctx context.Context, id string, update file.MetadataUpdate, revision int64) (file.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMetadata", ctx, id, update, revision)
+	ret0, _ := ret[0].(file.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateMetadata indicates an expected call of UpdateMetadata.
+func (mr *MockFileRepositoryMockRecorder) UpdateMetadata(ctx, id, update, revision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetadata", reflect.TypeOf((*MockFileRepository)(nil).UpdateMetadata), ctx, id, update, revision)
+}
+
+// UseDownloadLink mocks base method.
+func (m *MockFileRepository) UseDownloadLink(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseDownloadLink", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseDownloadLink indicates an expected call of UseDownloadLink.
+func (mr *MockFileRepositoryMockRecorder) UseDownloadLink(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseDownloadLink", reflect.TypeOf((*MockFileRepository)(nil).UseDownloadLink), ctx, id)
+}
+
+// WithTx mocks base method.
+func (m *MockFileRepository) WithTx(ctx context.Context, fn func(context.Context) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithTx", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithTx indicates an expected call of WithTx.
+func (mr *MockFileRepositoryMockRecorder) WithTx(ctx, fn interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockFileRepository)(nil).Update), ctx, curUser)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTx", reflect.TypeOf((*MockFileRepository)(nil).WithTx), ctx, fn)
 }
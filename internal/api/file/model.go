@@ -6,6 +6,149 @@ type File struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	Data      []byte    `json:"data"`
+	Owner     string    `json:"owner"`
+	Version   int32     `json:"version"`
+	Revision  int64     `json:"revision"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// пустой - файл в корне
+	FolderID string `json:"folder_id,omitempty"`
+
+	// не версионируются: относятся к файлу, а не к содержимому
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// FileFilter отбирает файлы, у которых есть все теги Tags и все пары Metadata.
+type FileFilter struct {
+	Tags     []string
+	Metadata map[string]string
+}
+
+// MetadataUpdate - изменение тегов, описания и метаданных файла. Поля без флага Set*
+// не меняются. При ReplaceMetadata метаданные заменяются на Metadata целиком, иначе
+// ключи DeleteKeys удаляются, а ключи Metadata задаются.
+type MetadataUpdate struct {
+	SetTags         bool
+	Tags            []string
+	SetDescription  bool
+	Description     string
+	ReplaceMetadata bool
+	Metadata        map[string]string
+	DeleteKeys      []string
+}
+
+// FileInfo - файл без содержимого: Data пуст, размер - в Size.
+type FileInfo struct {
+	File
+	Size int64 `json:"size"`
+}
+
+// ArchiveSize - число файлов архива, их суммарный размер и размер наибольшего из них.
+type ArchiveSize struct {
+	Files   int
+	Bytes   int64
+	Largest int64
+}
+
+// SearchResult - найденный файл; Rank - релевантность, больше - лучше. Highlight - фрагменты
+// имени и описания в HTML: слова запроса выделены <b></b>, остальной текст экранирован.
+type SearchResult struct {
+	FileInfo
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+type Folder struct {
+	ID string `json:"id"`
+	// пустой - папка в корне
+	ParentID  string    `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FolderEntry - папка или файл из содержимого папки; заполнено одно из Folder и File.
+// Path - путь относительно просматриваемой папки через '/'.
+type FolderEntry struct {
+	Path   string
+	Folder *Folder
+	File   *FileInfo
+}
+
+type FileVersion struct {
+	FileID    string    `json:"file_id"`
+	Version   int32     `json:"version"`
+	Name      string    `json:"name"`
+	Data      []byte    `json:"data"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UploadSession struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	TotalSize    int64     `json:"total_size"`
+	Checksum     string    `json:"checksum"`
+	ReceivedSize int64     `json:"received_size"`
+	Owner        string    `json:"owner"`
+	FolderID     string    `json:"folder_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type DownloadLink struct {
+	ID        string    `json:"id"`
+	FileID    string    `json:"file_id"`
+	MaxUses   int32     `json:"max_uses"`
+	Uses      int32     `json:"uses"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Usage - использование и квота владельца в бакете; нулевой лимит означает отсутствие ограничения.
+type Usage struct {
+	Bucket   string `json:"bucket"`
+	Owner    string `json:"owner"`
+	Bytes    int64  `json:"bytes"`
+	Files    int64  `json:"files"`
+	MaxBytes int64  `json:"max_bytes"`
+	MaxFiles int64  `json:"max_files"`
+}
+
+// Bucket - изолированное пространство файлов арендатора с его настройками; нулевые
+// значения - общие настройки сервиса. MaxBytes и MaxFiles - квота всего бакета
+// (владелец GlobalOwner), Bytes и Files - его использование.
+type Bucket struct {
+	Name             string
+	MaxBytes         int64
+	MaxFiles         int64
+	MaxFileSize      int64
+	KeepVersions     int32
+	KeepVersionsDays int32
+	Bytes            int64
+	Files            int64
+	CreatedAt        time.Time
+}
+
+// FileEventCursor - позиция в журнале событий; события упорядочены по (TxID, ID).
+type FileEventCursor struct {
+	TxID uint64
+	ID   int64
+}
+
+type FileEventType string
+
+const (
+	FileCreated FileEventType = "created"
+	FileUpdated FileEventType = "updated"
+	FileDeleted FileEventType = "deleted"
+)
+
+type FileEvent struct {
+	Cursor FileEventCursor
+	Type   FileEventType
+	// состояние файла после изменения, для удаления - до него; Data не заполняется
+	File      FileInfo
+	CreatedAt time.Time
 }
@@ -2,9 +2,11 @@ package file
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"app/pkg/client/postgresql"
 	"app/pkg/logging"
@@ -25,24 +27,86 @@ func NewRepository(logger *logging.Logger, client postgresql.Client) FileReposit
 	}
 }
 
+// conn возвращает транзакцию WithTx из ctx или клиент по умолчанию.
+func (r *repository) conn(ctx context.Context) postgresql.Client {
+	return postgresql.Conn(ctx, r.client)
+}
+
+// reader возвращает клиент для чтения: реплику, если они настроены и в запросе еще не было записи.
+func (r *repository) reader(ctx context.Context) postgresql.Client {
+	return postgresql.Reader(ctx, r.client)
+}
+
+// WithTx выполняет fn в транзакции; методы репозитория, вызванные с ctx из fn,
+// выполняются в ней же. Вложенные вызовы присоединяются к внешней транзакции.
+func (r *repository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.inTx(ctx, func(ctx context.Context, _ pgx.Tx) error {
+		return fn(ctx)
+	})
+}
+
+func (r *repository) inTx(ctx context.Context, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	return postgresql.WithTx(ctx, r.client, postgresql.TxOptions{}, fn)
+}
+
+// createFileQuery вставляет файл вместе с его первой версией.
+const createFileQuery = `
+	WITH f AS (
+		INSERT INTO files 
+			(name, data, owner, tags, description, metadata, folder_id)
+		VALUES 
+		    ($1, $2, $3, COALESCE($4::text[], '{}'), $5, $6::jsonb, NULLIF($7, '')::uuid)
+		RETURNING id, name, data, current_version, create_time
+	)
+	INSERT INTO file_versions
+		(file_id, version, name, data, create_time)
+	SELECT id, current_version, name, data, create_time FROM f
+	RETURNING file_id, version;
+`
+
+// metadataJSON кодирует метаданные для столбца jsonb; nil - пустой объект, а не null.
+func metadataJSON(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// filterArgs возвращает параметры фильтра FindAll и FindPage; пустые фильтры - NULL.
+func filterArgs(filter FileFilter) (tags, metadata interface{}) {
+	if len(filter.Tags) > 0 {
+		tags = filter.Tags
+	}
+	if len(filter.Metadata) > 0 {
+		metadata = metadataJSON(filter.Metadata)
+	}
+	return tags, metadata
+}
+
 func formatQuery(q string) string {
 	return strings.ReplaceAll(strings.ReplaceAll(q, "\t", ""), "\n", " ")
 }
 
 func (r *repository) Create(ctx context.Context, curFile *File) error {
-	q := `
-		INSERT INTO files 
-			(name, data)
-		VALUES 
-		    ($1, $2)
-		RETURNING id;
-	`
+	return r.create(ctx, r.conn(ctx), curFile)
+}
+
+func (r *repository) create(ctx context.Context, client postgresql.Client, curFile *File) error {
+	q := createFileQuery
 
-	if err := r.client.QueryRow(ctx, q, curFile.Name, curFile.Data).Scan(&curFile.ID); err != nil {
+	curFile.Owner = ownerOrDefault(curFile.Owner)
+	args := []interface{}{curFile.Name, curFile.Data, curFile.Owner, curFile.Tags, curFile.Description, metadataJSON(curFile.Metadata), curFile.FolderID}
+	if err := client.QueryRow(ctx, q, args...).Scan(&curFile.ID, &curFile.Version); err != nil {
+		if limitErr := limitExceeded(err); limitErr != nil {
+			return limitErr
+		}
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return constraintErr
+		}
 
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
-			pgErr = err.(*pgconn.PgError)
 			newErr := fmt.Errorf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s", pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState())
 			r.logger.Error(newErr)
 			return newErr
@@ -51,42 +115,145 @@ func (r *repository) Create(ctx context.Context, curFile *File) error {
 		return err
 	}
 
-	response := fmt.Sprintf("SQL Query: %s\n\tResult: adding tool %s", formatQuery(q), curFile)
+	response := fmt.Sprintf("SQL Query: %s\n\tResult: adding tool %v", formatQuery(q), curFile)
 
 	r.logger.Debug(response)
 
 	return nil
 }
 
-func (r *repository) FindAll(ctx context.Context) (files []File, err error) {
+// CreateIdempotent создает файл, запоминая ключ идемпотентности и отпечаток запроса на время ttl.
+// Повтор с тем же ключом и отпечатком возвращает ID исходного файла без повторной вставки,
+// с тем же ключом и другим отпечатком - ErrIdempotencyKeyReused.
+func (r *repository) CreateIdempotent(ctx context.Context, curFile *File, key, fingerprint string, ttl time.Duration) error {
+	return r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		// Конкурентный запрос с тем же ключом ждет на уникальном индексе до завершения этой транзакции.
+		// Просроченный ключ перезаписывается, как если бы его не было.
+		q := `
+		INSERT INTO idempotency_keys 
+			(key, fingerprint)
+		VALUES 
+			($1, $2)
+		ON CONFLICT (bucket, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			file_id = NULL,
+			create_time = current_timestamp
+		WHERE idempotency_keys.create_time < current_timestamp - make_interval(secs => $3)
+		RETURNING key;
+		`
+
+		var inserted string
+		err := tx.QueryRow(ctx, q, key, fingerprint, ttl.Seconds()).Scan(&inserted)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error(err)
+			return err
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			q = `
+			SELECT file_id, fingerprint FROM idempotency_keys WHERE key = $1;
+			`
+
+			var storedID *string
+			var storedFingerprint string
+			if err = tx.QueryRow(ctx, q, key).Scan(&storedID, &storedFingerprint); err != nil {
+				r.logger.Error(err)
+				return err
+			}
+			if storedFingerprint != fingerprint {
+				return ErrIdempotencyKeyReused
+			}
+
+			if storedID != nil {
+				curFile.ID = *storedID
+				r.logger.Debug(fmt.Sprintf("Idempotent replay for key %s: file %s", key, curFile.ID))
+				return nil
+			}
+			// ключ без файла (file_id допускает NULL) не подтверждает загрузку; строка ключа
+			// заблокирована ON CONFLICT, поэтому файл создается заново под этим ключом
+			r.logger.Debug(fmt.Sprintf("Idempotency key %s has no file, creating it", key))
+		}
+
+		if err = r.create(ctx, tx, curFile); err != nil {
+			return err
+		}
+
+		q = `
+		UPDATE idempotency_keys SET file_id = $2 WHERE key = $1;
+		`
+
+		if _, err = tx.Exec(ctx, q, key, curFile.ID); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (r *repository) DeleteExpiredIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	q := `
+	DELETE FROM idempotency_keys
+	WHERE create_time < current_timestamp - make_interval(secs => $1);
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q, ttl.Seconds())
+	if err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return res.RowsAffected(), nil
+}
+
+func (r *repository) FindAll(ctx context.Context, filter FileFilter) (files []FileInfo, err error) {
 	q := `
 		SELECT 
 			id,
 			name,
-			data,
+			octet_length(data),
+			current_version,
+			revision,
 			create_time, 
-			update_time
+			update_time,
+			tags,
+			description,
+			metadata,
+			COALESCE(folder_id::text, '')
 		FROM 
 			files
+		WHERE
+			($1::text[] IS NULL OR tags @> $1::text[]) AND
+			($2::jsonb IS NULL OR metadata @> $2::jsonb)
 	`
 
-	rows, err := r.client.Query(ctx, q)
+	tags, metadata := filterArgs(filter)
+	rows, err := r.reader(ctx).Query(ctx, q, tags, metadata)
 	if err != nil {
 		r.logger.Error(rows.CommandTag(), err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	files = make([]File, 0)
+	files = make([]FileInfo, 0)
 	for rows.Next() {
-		var fl File
+		var fl FileInfo
 
 		err = rows.Scan(
 			&fl.ID,
 			&fl.Name,
-			&fl.Data,
+			&fl.Size,
+			&fl.Version,
+			&fl.Revision,
 			&fl.CreatedAt,
 			&fl.UpdatedAt,
+			&fl.Tags,
+			&fl.Description,
+			&fl.Metadata,
+			&fl.FolderID,
 		)
 		if err != nil {
 			r.logger.Error(err)
@@ -108,13 +275,88 @@ func (r *repository) FindAll(ctx context.Context) (files []File, err error) {
 	return files, nil
 }
 
+// FindPage возвращает до limit файлов с ID больше afterID в порядке ID,
+// пустой afterID означает начало списка.
+func (r *repository) FindPage(ctx context.Context, filter FileFilter, afterID string, limit int) (files []FileInfo, err error) {
+	q := `
+		SELECT 
+			id,
+			name,
+			octet_length(data),
+			current_version,
+			revision,
+			create_time, 
+			update_time,
+			tags,
+			description,
+			metadata,
+			COALESCE(folder_id::text, '')
+		FROM 
+			files
+		WHERE 
+			($1::uuid IS NULL OR id > $1::uuid) AND
+			($3::text[] IS NULL OR tags @> $3::text[]) AND
+			($4::jsonb IS NULL OR metadata @> $4::jsonb)
+		ORDER BY 
+			id
+		LIMIT $2
+	`
+
+	var after interface{}
+	if afterID != "" {
+		after = afterID
+	}
+
+	tags, metadata := filterArgs(filter)
+	rows, err := r.reader(ctx).Query(ctx, q, after, limit, tags, metadata)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	files = make([]FileInfo, 0, limit)
+	for rows.Next() {
+		var fl FileInfo
+
+		err = rows.Scan(
+			&fl.ID,
+			&fl.Name,
+			&fl.Size,
+			&fl.Version,
+			&fl.Revision,
+			&fl.CreatedAt,
+			&fl.UpdatedAt,
+			&fl.Tags,
+			&fl.Description,
+			&fl.Metadata,
+			&fl.FolderID,
+		)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+
+		files = append(files, fl)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+
+	return files, nil
+}
+
 func (r *repository) FindOne(ctx context.Context, id string) (File, error) {
 	q := `
-	SELECT id, name, data, create_time, update_time FROM files WHERE id = $1;
+	SELECT id, name, data, current_version, revision, create_time, update_time FROM files WHERE id = $1;
 	`
 
 	var fl File
-	err := r.client.QueryRow(ctx, q, id).Scan(&fl.ID, &fl.Name, &fl.Data, &fl.CreatedAt, &fl.UpdatedAt)
+	err := r.reader(ctx).QueryRow(ctx, q, id).Scan(&fl.ID, &fl.Name, &fl.Data, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return File{}, nil
@@ -126,19 +368,88 @@ func (r *repository) FindOne(ctx context.Context, id string) (File, error) {
 	return fl, nil
 }
 
-func (r *repository) Update(ctx context.Context, curFile *File) (files []File, err error) {
+func (r *repository) FindRevision(ctx context.Context, id string) (int64, error) {
+	return r.findRevision(ctx, r.reader(ctx), id)
+}
+
+func (r *repository) findRevision(ctx context.Context, client postgresql.Client, id string) (int64, error) {
 	q := `
-	UPDATE files SET
-		name = $1,
-		data = $2
-	WHERE id = $3
-	RETURNING 
+	SELECT revision FROM files WHERE id = $1;
+	`
+
+	var revision int64
+	err := client.QueryRow(ctx, q, id).Scan(&revision)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// checkRevision определяет причину того, что условное изменение не затронуло ни одной строки:
+// файл отсутствует (nil) или его ревизия отличается от ожидаемой (ErrPreconditionFailed).
+func (r *repository) checkRevision(ctx context.Context, id string, revision int64) error {
+	if revision == 0 {
+		return nil
+	}
+
+	// сразу после неудачной записи реплика может еще не видеть текущую ревизию
+	current, err := r.findRevision(ctx, r.conn(ctx), id)
+	if err != nil {
+		return err
+	}
+	if current != 0 && current != revision {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func (r *repository) Update(ctx context.Context, curFile *File, setData bool) (files []File, err error) {
+	// Пустое имя оставляет текущее, без setData - текущее содержимое без новой версии.
+	// Каждое изменение содержимого сохраняется отдельной неизменяемой версией.
+	q := `
+	WITH f AS (
+		UPDATE files SET
+			name = COALESCE(NULLIF($1, ''), name),
+			data = COALESCE($2, data),
+			current_version = current_version + CASE WHEN $2::bytea IS NULL THEN 0 ELSE 1 END,
+			revision = revision + 1,
+			update_time = current_timestamp
+		WHERE id = $3 AND ($4::bigint = 0 OR revision = $4)
+		RETURNING id, name, data, current_version, revision, create_time, update_time
+	), v AS (
+		INSERT INTO file_versions
+			(file_id, version, name, data, create_time)
+		SELECT id, current_version, name, data, update_time FROM f
+		WHERE $2::bytea IS NOT NULL
+	)
+	SELECT 
+		current_version,
+		revision,
 		create_time,
-		update_time;
+		update_time
+	FROM f;
 	`
 
-	rows, err := r.client.Query(ctx, q, curFile.Name, curFile.Data, curFile.ID)
+	// nil передается как NULL, пустой срез - как пустое содержимое
+	var data []byte
+	if setData {
+		data = curFile.Data
+		if data == nil {
+			data = []byte{}
+		}
+	}
+
+	rows, err := r.conn(ctx).Query(ctx, q, curFile.Name, data, curFile.ID, curFile.Revision)
 	if err != nil {
+		if limitErr := limitExceeded(err); limitErr != nil {
+			return nil, limitErr
+		}
 		return nil, err
 	}
 	defer rows.Close()
@@ -147,6 +458,8 @@ func (r *repository) Update(ctx context.Context, curFile *File) (files []File, e
 	for rows.Next() {
 		var fl File
 		err = rows.Scan(
+			&fl.Version,
+			&fl.Revision,
 			&fl.CreatedAt,
 			&fl.UpdatedAt,
 		)
@@ -157,15 +470,26 @@ func (r *repository) Update(ctx context.Context, curFile *File) (files []File, e
 		files = append(files, fl)
 	}
 	if err = rows.Err(); err != nil {
+		if limitErr := limitExceeded(err); limitErr != nil {
+			return nil, limitErr
+		}
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return nil, constraintErr
+		}
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
-			pgErr = err.(*pgconn.PgError)
 			newErr := fmt.Errorf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s", pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState())
 			r.logger.Error(newErr)
 		}
 		return nil, err
 	}
 
+	if len(files) == 0 {
+		if err = r.checkRevision(ctx, curFile.ID, curFile.Revision); err != nil {
+			return nil, err
+		}
+	}
+
 	res := rows.CommandTag()
 	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
 	r.logger.Debug(response)
@@ -173,14 +497,14 @@ func (r *repository) Update(ctx context.Context, curFile *File) (files []File, e
 	return files, nil
 }
 
-func (r *repository) Delete(ctx context.Context, id string) ([]string, error) {
+func (r *repository) Delete(ctx context.Context, id string, revision int64) ([]string, error) {
 	q := `
 	DELETE FROM files
-	WHERE id = $1
+	WHERE id = $1 AND ($2::bigint = 0 OR revision = $2)
 	RETURNING id;
 	`
 
-	rows, err := r.client.Query(ctx, q, id)
+	rows, err := r.conn(ctx).Query(ctx, q, id, revision)
 	if err != nil {
 		r.logger.Error(err)
 		return nil, err
@@ -197,6 +521,16 @@ func (r *repository) Delete(ctx context.Context, id string) ([]string, error) {
 		}
 		ids = append(ids, id)
 	}
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		if err = r.checkRevision(ctx, id, revision); err != nil {
+			return nil, err
+		}
+	}
 
 	res := rows.CommandTag()
 	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
@@ -204,3 +538,150 @@ func (r *repository) Delete(ctx context.Context, id string) ([]string, error) {
 
 	return ids, nil
 }
+
+func (r *repository) FindVersions(ctx context.Context, id string) ([]FileVersion, error) {
+	q := `
+	SELECT 
+		file_id,
+		version,
+		name,
+		octet_length(data),
+		create_time
+	FROM 
+		file_versions
+	WHERE file_id = $1
+	ORDER BY version DESC;
+	`
+
+	rows, err := r.reader(ctx).Query(ctx, q, id)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]FileVersion, 0)
+	for rows.Next() {
+		var fv FileVersion
+		err = rows.Scan(
+			&fv.FileID,
+			&fv.Version,
+			&fv.Name,
+			&fv.Size,
+			&fv.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		versions = append(versions, fv)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	res := rows.CommandTag()
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return versions, nil
+}
+
+func (r *repository) FindVersion(ctx context.Context, id string, version int32) (FileVersion, error) {
+	q := `
+	SELECT file_id, version, name, data, octet_length(data), create_time FROM file_versions WHERE file_id = $1 AND version = $2;
+	`
+
+	var fv FileVersion
+	err := r.reader(ctx).QueryRow(ctx, q, id, version).Scan(&fv.FileID, &fv.Version, &fv.Name, &fv.Data, &fv.Size, &fv.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return FileVersion{}, nil
+		}
+		r.logger.Error(err)
+		return FileVersion{}, err
+	}
+
+	return fv, nil
+}
+
+// RestoreVersion не переписывает историю: содержимое выбранной версии
+// становится новой текущей версией файла.
+func (r *repository) RestoreVersion(ctx context.Context, id string, version int32, revision int64) (File, error) {
+	q := `
+	WITH src AS (
+		SELECT name, data FROM file_versions WHERE file_id = $1 AND version = $2
+	), f AS (
+		UPDATE files SET
+			name = src.name,
+			data = src.data,
+			current_version = files.current_version + 1,
+			revision = files.revision + 1,
+			update_time = current_timestamp
+		FROM src
+		WHERE files.id = $1 AND ($3::bigint = 0 OR files.revision = $3)
+		RETURNING files.id, files.name, files.data, files.current_version, files.revision, files.create_time, files.update_time
+	), v AS (
+		INSERT INTO file_versions
+			(file_id, version, name, data, create_time)
+		SELECT id, current_version, name, data, update_time FROM f
+	)
+	SELECT id, name, current_version, revision, create_time, update_time FROM f;
+	`
+
+	var fl File
+	err := r.conn(ctx).QueryRow(ctx, q, id, version, revision).Scan(&fl.ID, &fl.Name, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return File{}, r.checkRevision(ctx, id, revision)
+		}
+		if limitErr := limitExceeded(err); limitErr != nil {
+			return File{}, limitErr
+		}
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return File{}, constraintErr
+		}
+		r.logger.Error(err)
+		return File{}, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s\n\tResult: restored version %d of %s as %d", formatQuery(q), version, id, fl.Version)
+	r.logger.Debug(response)
+
+	return fl, nil
+}
+
+// PruneVersions удаляет версии, которые не входят в последние keepLast и
+// старше keepDays дней. Текущая версия файла не удаляется никогда.
+// Нулевое значение параметра отключает соответствующее условие.
+func (r *repository) PruneVersions(ctx context.Context, keepLast, keepDays int) (int64, error) {
+	// настройки бакета, если заданы, заменяют общие; без обоих ограничений версии не удаляются
+	q := `
+	DELETE FROM file_versions fv
+	USING files f
+	JOIN buckets b ON b.name = f.bucket
+	CROSS JOIN LATERAL (
+		SELECT
+			COALESCE(NULLIF(b.keep_versions, 0), $1) AS keep_last,
+			COALESCE(NULLIF(b.keep_versions_days, 0), $2) AS keep_days
+	) k
+	WHERE fv.file_id = f.id
+		AND fv.version <> f.current_version
+		AND (k.keep_last > 0 OR k.keep_days > 0)
+		AND (k.keep_last <= 0 OR fv.version <= f.current_version - k.keep_last)
+		AND (k.keep_days <= 0 OR fv.create_time < current_timestamp - make_interval(days => k.keep_days));
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q, keepLast, keepDays)
+	if err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,284 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func (r *repository) CreateMany(ctx context.Context, files []*File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	err := r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for _, fl := range files {
+			fl.Owner = ownerOrDefault(fl.Owner)
+			batch.Queue(createFileQuery, fl.Name, fl.Data, fl.Owner, fl.Tags, fl.Description, metadataJSON(fl.Metadata), fl.FolderID)
+		}
+
+		br := tx.SendBatch(ctx, batch)
+		for _, fl := range files {
+			if err := br.QueryRow().Scan(&fl.ID, &fl.Version); err != nil {
+				br.Close()
+				if limitErr := limitExceeded(err); limitErr != nil {
+					return limitErr
+				}
+				if constraintErr := constraintError(err); constraintErr != nil {
+					return constraintErr
+				}
+				r.logger.Error(err)
+				return err
+			}
+		}
+		if err := br.Close(); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: batch of %d files", formatQuery(createFileQuery), len(files)))
+
+	return nil
+}
+
+// CreateEach вставляет файлы в одной транзакции, но каждый под своей точкой сохранения:
+// отказ триггера или ограничения на одном файле откатывает только его.
+func (r *repository) CreateEach(ctx context.Context, files []*File) ([]error, error) {
+	errs := make([]error, len(files))
+	err := r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		for i, fl := range files {
+			savepoint, err := tx.Begin(ctx)
+			if err != nil {
+				r.logger.Error(err)
+				return err
+			}
+			if errs[i] = r.create(ctx, savepoint, fl); errs[i] != nil {
+				if err = savepoint.Rollback(ctx); err != nil {
+					r.logger.Error(err)
+					return err
+				}
+				continue
+			}
+			if err = savepoint.Commit(ctx); err != nil {
+				r.logger.Error(err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: %d files, each under a savepoint", formatQuery(createFileQuery), len(files)))
+
+	return errs, nil
+}
+
+func (r *repository) FindMany(ctx context.Context, ids []string) ([]File, error) {
+	q := `
+	SELECT id, name, data, current_version, revision, create_time, update_time 
+	FROM files 
+	WHERE id = ANY($1::uuid[]);
+	`
+
+	rows, err := r.reader(ctx).Query(ctx, q, ids)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]File, 0, len(ids))
+	for rows.Next() {
+		var fl File
+		err = rows.Scan(&fl.ID, &fl.Name, &fl.Data, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		files = append(files, fl)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	res := rows.CommandTag()
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return files, nil
+}
+
+// DeleteMany возвращает ID удаленных файлов. При atomic и отсутствии части файлов транзакция
+// откатывается, а вместе с ErrBatchAborted возвращаются ID найденных, но не удаленных файлов.
+func (r *repository) DeleteMany(ctx context.Context, ids []string, atomic bool) ([]string, error) {
+	q := `
+	DELETE FROM files
+	WHERE id = ANY($1::uuid[])
+	RETURNING id;
+	`
+
+	var (
+		deleted []string
+		res     pgconn.CommandTag
+	)
+	err := r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, q, ids)
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		defer rows.Close()
+
+		deleted = make([]string, 0, len(ids))
+		for rows.Next() {
+			var id string
+			if err = rows.Scan(&id); err != nil {
+				r.logger.Error(err)
+				return err
+			}
+			deleted = append(deleted, id)
+		}
+		if err = rows.Err(); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		res = rows.CommandTag()
+
+		if atomic && len(deleted) != len(uniqueStrings(ids)) {
+			return ErrBatchAborted
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrBatchAborted) {
+			return deleted, err
+		}
+		return nil, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return deleted, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+func (r *repository) SumSizes(ctx context.Context, ids []string, version int32) (int64, error) {
+	q := `
+	SELECT COALESCE(sum(octet_length(data)), 0) FROM files WHERE id = ANY($1::uuid[]);
+	`
+	args := []interface{}{ids}
+	if version != 0 {
+		q = `
+	SELECT COALESCE(sum(octet_length(data)), 0) FROM file_versions WHERE file_id = ANY($1::uuid[]) AND version = $2;
+	`
+		args = append(args, version)
+	}
+
+	var size int64
+	if err := r.reader(ctx).QueryRow(ctx, q, args...).Scan(&size); err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+	return size, nil
+}
+
+func (r *repository) ForEach(ctx context.Context, ids []string, namePrefix string, fn func(File) error) error {
+	q := `
+	SELECT id, name, data, current_version, revision, create_time, update_time 
+	FROM files 
+	WHERE (cardinality($1::uuid[]) = 0 OR id = ANY($1::uuid[]))
+		AND name LIKE $2 || '%'
+	ORDER BY create_time, id;
+	`
+
+	if ids == nil {
+		ids = []string{}
+	}
+
+	rows, err := r.reader(ctx).Query(ctx, q, ids, escapeLike(namePrefix))
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fl File
+		err = rows.Scan(&fl.ID, &fl.Name, &fl.Data, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt)
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		if err = fn(fl); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	res := rows.CommandTag()
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return nil
+}
+
+func (r *repository) MeasureArchive(ctx context.Context, ids []string, namePrefix string) (ArchiveSize, error) {
+	q := `
+	SELECT count(*), COALESCE(sum(octet_length(data)), 0), COALESCE(max(octet_length(data)), 0)
+	FROM files 
+	WHERE (cardinality($1::uuid[]) = 0 OR id = ANY($1::uuid[]))
+		AND name LIKE $2 || '%';
+	`
+
+	if ids == nil {
+		ids = []string{}
+	}
+
+	var size ArchiveSize
+	err := r.reader(ctx).QueryRow(ctx, q, ids, escapeLike(namePrefix)).Scan(&size.Files, &size.Bytes, &size.Largest)
+	if err != nil {
+		r.logger.Error(err)
+		return ArchiveSize{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+	return size, nil
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE (экранирующий символ по умолчанию - обратный слеш).
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
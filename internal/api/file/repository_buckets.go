@@ -0,0 +1,219 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// bucketQuery выбирает бакеты с квотой и использованием всего бакета.
+const bucketQuery = `
+	SELECT
+		b.name,
+		COALESCE(q.max_bytes, 0),
+		COALESCE(q.max_files, 0),
+		b.max_file_size,
+		b.keep_versions,
+		b.keep_versions_days,
+		COALESCE(u.bytes, 0),
+		COALESCE(u.files, 0),
+		b.create_time
+	FROM buckets b
+	LEFT JOIN quotas q ON q.bucket = b.name AND q.owner = '*'
+	LEFT JOIN quota_usage u ON u.bucket = b.name AND u.owner = '*'
+`
+
+func scanBucket(row pgx.Row) (Bucket, error) {
+	var b Bucket
+	err := row.Scan(&b.Name, &b.MaxBytes, &b.MaxFiles, &b.MaxFileSize, &b.KeepVersions, &b.KeepVersionsDays, &b.Bytes, &b.Files, &b.CreatedAt)
+	return b, err
+}
+
+// setBucketQuota задает квоту всего бакета; она хранится вместе с квотами владельцев.
+func (r *repository) setBucketQuota(ctx context.Context, tx pgx.Tx, bucket *Bucket) error {
+	q := `
+	INSERT INTO quotas
+		(bucket, owner, max_bytes, max_files)
+	VALUES
+		($1, '*', $2, $3)
+	ON CONFLICT (bucket, owner) DO UPDATE SET
+		max_bytes = EXCLUDED.max_bytes,
+		max_files = EXCLUDED.max_files;
+	`
+
+	if _, err := tx.Exec(ctx, q, bucket.Name, bucket.MaxBytes, bucket.MaxFiles); err != nil {
+		r.logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) CreateBucket(ctx context.Context, bucket *Bucket) error {
+	return r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		q := `
+		INSERT INTO buckets
+			(name, max_file_size, keep_versions, keep_versions_days)
+		VALUES
+			($1, $2, $3, $4)
+		RETURNING create_time;
+		`
+
+		err := tx.QueryRow(ctx, q, bucket.Name, bucket.MaxFileSize, bucket.KeepVersions, bucket.KeepVersionsDays).Scan(&bucket.CreatedAt)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrBucketExists
+		}
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: created bucket %s", formatQuery(q), bucket.Name))
+
+		return r.setBucketQuota(ctx, tx, bucket)
+	})
+}
+
+// UpdateBucket заменяет все настройки бакета; использование в bucket не меняется.
+func (r *repository) UpdateBucket(ctx context.Context, bucket *Bucket) error {
+	return r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		q := `
+		UPDATE buckets SET
+			max_file_size = $2,
+			keep_versions = $3,
+			keep_versions_days = $4
+		WHERE name = $1
+		RETURNING create_time;
+		`
+
+		err := tx.QueryRow(ctx, q, bucket.Name, bucket.MaxFileSize, bucket.KeepVersions, bucket.KeepVersionsDays).Scan(&bucket.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrBucketNotFound
+		}
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: updated bucket %s", formatQuery(q), bucket.Name))
+
+		return r.setBucketQuota(ctx, tx, bucket)
+	})
+}
+
+// FindBucket возвращает пустой бакет, если он не найден.
+func (r *repository) FindBucket(ctx context.Context, name string) (Bucket, error) {
+	q := bucketQuery + `WHERE b.name = $1;`
+
+	bucket, err := scanBucket(r.reader(ctx).QueryRow(ctx, q, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Bucket{}, nil
+	}
+	if err != nil {
+		r.logger.Error(err)
+		return Bucket{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+
+	return bucket, nil
+}
+
+func (r *repository) ListBuckets(ctx context.Context) ([]Bucket, error) {
+	q := bucketQuery + `ORDER BY b.name;`
+
+	rows, err := r.reader(ctx).Query(ctx, q)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]Bucket, 0)
+	for rows.Next() {
+		bucket, err := scanBucket(rows)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+
+	return buckets, nil
+}
+
+// DeleteBucket удаляет бакет и возвращает число удаленных файлов. Без force непустой
+// бакет не удаляется (ErrBucketNotEmpty), с force удаляется все его содержимое.
+func (r *repository) DeleteBucket(ctx context.Context, name string, force bool) (files int64, err error) {
+	err = r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		// Блокировка строки бакета заставляет параллельные записи в него дождаться
+		// удаления и завершиться ошибкой внешнего ключа.
+		err := tx.QueryRow(ctx, `SELECT name FROM buckets WHERE name = $1 FOR UPDATE;`, name).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrBucketNotFound
+		}
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		if !force {
+			q := `
+			SELECT EXISTS (SELECT 1 FROM files WHERE bucket = $1)
+				OR EXISTS (SELECT 1 FROM folders WHERE bucket = $1)
+				OR EXISTS (SELECT 1 FROM upload_sessions WHERE bucket = $1);
+			`
+
+			var notEmpty bool
+			if err = tx.QueryRow(ctx, q, name).Scan(&notEmpty); err != nil {
+				r.logger.Error(err)
+				return err
+			}
+			if notEmpty {
+				return ErrBucketNotEmpty
+			}
+		}
+
+		// версии, ссылки и части загрузок удаляются каскадно; файлы удаляются раньше
+		// счетчиков, так как триггер квот обновляет их при удалении
+		res, err := tx.Exec(ctx, `DELETE FROM files WHERE bucket = $1;`, name)
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		files = res.RowsAffected()
+
+		for _, q := range []string{
+			`DELETE FROM upload_sessions WHERE bucket = $1;`,
+			`DELETE FROM folders WHERE bucket = $1;`,
+			`DELETE FROM idempotency_keys WHERE bucket = $1;`,
+			`DELETE FROM quotas WHERE bucket = $1;`,
+			`DELETE FROM quota_usage WHERE bucket = $1;`,
+			`DELETE FROM file_events WHERE bucket = $1;`,
+			`DELETE FROM buckets WHERE name = $1;`,
+		} {
+			if _, err = tx.Exec(ctx, q, name); err != nil {
+				r.logger.Error(err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("Bucket %s deleted with %d files", name, files))
+
+	return files, nil
+}
@@ -0,0 +1,139 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// События читаются с основного сервера: на реплике они появлялись бы с задержкой.
+
+// LatestFileEventCursor возвращает позицию, после которой идут события, еще не видные читателям.
+func (r *repository) LatestFileEventCursor(ctx context.Context) (FileEventCursor, error) {
+	q := `SELECT pg_snapshot_xmin(pg_current_snapshot())::text;`
+
+	var xmin string
+	if err := r.conn(ctx).QueryRow(ctx, q).Scan(&xmin); err != nil {
+		r.logger.Error(err)
+		return FileEventCursor{}, err
+	}
+
+	txID, err := strconv.ParseUint(xmin, 10, 64)
+	if err != nil {
+		return FileEventCursor{}, err
+	}
+	return FileEventCursor{TxID: txID}, nil
+}
+
+// Курсор устаревает, только если удалено событие после него: курсор события, которое
+// удалено без следующих, как и курсор без события из пульса, остается действительным.
+func (r *repository) FileEventCursorExpired(ctx context.Context, cursor FileEventCursor) (bool, error) {
+	q := `
+	SELECT EXISTS (
+		SELECT 1 FROM file_events_pruned
+		WHERE bucket = current_bucket() AND (tx_id, id) > ($1::text::xid8, $2)
+	);
+	`
+
+	var expired bool
+	if err := r.conn(ctx).QueryRow(ctx, q, strconv.FormatUint(cursor.TxID, 10), cursor.ID).Scan(&expired); err != nil {
+		r.logger.Error(err)
+		return false, err
+	}
+	return expired, nil
+}
+
+// ListFileEvents возвращает события после cursor, транзакции до которых завершены. Событие
+// транзакции, которая еще выполняется, задерживает все следующие: иначе курсор читателя
+// обогнал бы его, и после фиксации событие было бы пропущено. Граница - xmin снимка всего
+// кластера, поэтому долгая или простаивающая в транзакции сессия любого бакета и любой
+// базы сервера задерживает события всех наблюдателей до своего завершения.
+func (r *repository) ListFileEvents(ctx context.Context, after FileEventCursor, limit int) ([]FileEvent, error) {
+	q := `
+	SELECT
+		tx_id::text,
+		id,
+		type,
+		file_id,
+		name,
+		size,
+		revision,
+		tags,
+		description,
+		metadata,
+		COALESCE(folder_id::text, ''),
+		COALESCE(file_create_time, create_time),
+		COALESCE(file_update_time, create_time),
+		create_time
+	FROM file_events
+	WHERE (tx_id, id) > ($1::text::xid8, $2)
+		AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+	ORDER BY tx_id, id
+	LIMIT $3;
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, q, strconv.FormatUint(after.TxID, 10), after.ID, limit)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]FileEvent, 0)
+	for rows.Next() {
+		var (
+			e    FileEvent
+			txID string
+			fl   = &e.File
+		)
+		err = rows.Scan(&txID, &e.Cursor.ID, &e.Type, &fl.ID, &fl.Name, &fl.Size, &fl.Revision, &fl.Tags, &fl.Description, &fl.Metadata, &fl.FolderID, &fl.CreatedAt, &fl.UpdatedAt, &e.CreatedAt)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		if e.Cursor.TxID, err = strconv.ParseUint(txID, 10, 64); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: %d events", formatQuery(q), len(events)))
+
+	return events, nil
+}
+
+func (r *repository) DeleteExpiredFileEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	// позиция последнего удаленного события бакета отмечает курсоры, которые до нее не дошли
+	q := `
+	WITH d AS (
+		DELETE FROM file_events
+		WHERE create_time < current_timestamp - make_interval(secs => $1)
+		RETURNING bucket, tx_id, id
+	), p AS (
+		INSERT INTO file_events_pruned AS cur
+			(bucket, tx_id, id)
+		SELECT DISTINCT ON (bucket) bucket, tx_id, id FROM d ORDER BY bucket, tx_id DESC, id DESC
+		ON CONFLICT (bucket) DO UPDATE SET
+			tx_id = EXCLUDED.tx_id,
+			id = EXCLUDED.id
+		WHERE (EXCLUDED.tx_id, EXCLUDED.id) > (cur.tx_id, cur.id)
+	)
+	SELECT count(*) FROM d;
+	`
+
+	var deleted int64
+	if err := r.conn(ctx).QueryRow(ctx, q, retention.Seconds()).Scan(&deleted); err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: deleted %d events", formatQuery(q), deleted))
+
+	return deleted, nil
+}
@@ -0,0 +1,282 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+	checkViolation      = "23514"
+)
+
+// constraintError переводит нарушения ограничений папок и бакетов при записи
+// в ErrNameExists, ErrFolderNotFound и ErrBucketNotFound; для остальных ошибок возвращает nil.
+func constraintError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	switch {
+	case pgErr.Code == uniqueViolation && (pgErr.ConstraintName == "files_folder_name_idx" || pgErr.ConstraintName == "files_root_name_idx" ||
+		pgErr.ConstraintName == "folders_parent_name_idx" || pgErr.ConstraintName == "folders_root_name_idx" ||
+		// файл и папка с одним именем, см. 15_unique_names
+		pgErr.ConstraintName == "entries_name_check"):
+		return ErrNameExists
+	case pgErr.Code == foreignKeyViolation && (pgErr.ConstraintName == "files_folder_id_fkey" ||
+		pgErr.ConstraintName == "folders_parent_id_fkey" || pgErr.ConstraintName == "upload_sessions_folder_id_fkey"):
+		return ErrFolderNotFound
+	case pgErr.Code == foreignKeyViolation && (pgErr.ConstraintName == "files_bucket_fkey" ||
+		pgErr.ConstraintName == "folders_bucket_fkey" || pgErr.ConstraintName == "upload_sessions_bucket_fkey"):
+		return ErrBucketNotFound
+	}
+	return nil
+}
+
+func (r *repository) CreateFolder(ctx context.Context, folder *Folder) error {
+	q := `
+	INSERT INTO folders 
+		(parent_id, name)
+	VALUES 
+		(NULLIF($1, '')::uuid, $2)
+	RETURNING id, create_time;
+	`
+
+	err := r.conn(ctx).QueryRow(ctx, q, folder.ParentID, folder.Name).Scan(&folder.ID, &folder.CreatedAt)
+	if err != nil {
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return constraintErr
+		}
+		r.logger.Error(err)
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: created folder %s", formatQuery(q), folder.ID))
+
+	return nil
+}
+
+// FindFolder возвращает пустую папку, если она не найдена.
+func (r *repository) FindFolder(ctx context.Context, id string) (Folder, error) {
+	q := `
+	SELECT id, COALESCE(parent_id::text, ''), name, create_time FROM folders WHERE id = $1;
+	`
+
+	var folder Folder
+	err := r.reader(ctx).QueryRow(ctx, q, id).Scan(&folder.ID, &folder.ParentID, &folder.Name, &folder.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Folder{}, nil
+		}
+		r.logger.Error(err)
+		return Folder{}, err
+	}
+
+	return folder, nil
+}
+
+// ListFolder возвращает до limit записей папки folderID (пустой - корня), следующих
+// за (afterPath, afterID) в порядке пути и ID; пустой afterPath означает начало.
+// При recursive в список входит содержимое вложенных папок на любой глубине.
+func (r *repository) ListFolder(ctx context.Context, folderID string, recursive bool, afterPath, afterID string, limit int) ([]FolderEntry, error) {
+	// пути сравниваются побайтово (COLLATE "C"), чтобы порядок не зависел от локали базы
+	q := `
+	WITH RECURSIVE tree AS (
+		SELECT id, name::text AS path FROM folders
+		WHERE ($1::uuid IS NULL AND parent_id IS NULL) OR parent_id = $1::uuid
+		UNION ALL
+		SELECT f.id, t.path || '/' || f.name FROM folders f JOIN tree t ON f.parent_id = t.id
+		WHERE $2::boolean
+	), entries AS (
+		SELECT 
+			t.path, f.id, f.name::text, COALESCE(f.parent_id::text, '') AS parent, true AS is_folder,
+			0::bigint AS size, 0 AS version, 0::bigint AS revision, f.create_time, f.create_time AS update_time,
+			'{}'::text[] AS tags, '' AS description, '{}'::jsonb AS metadata
+		FROM tree t JOIN folders f ON f.id = t.id
+		UNION ALL
+		SELECT 
+			fl.name::text, fl.id, fl.name::text, COALESCE(fl.folder_id::text, ''), false,
+			octet_length(fl.data), fl.current_version, fl.revision, fl.create_time, fl.update_time,
+			fl.tags, fl.description, fl.metadata
+		FROM files fl
+		WHERE ($1::uuid IS NULL AND fl.folder_id IS NULL) OR fl.folder_id = $1::uuid
+		UNION ALL
+		SELECT 
+			t.path || '/' || fl.name, fl.id, fl.name::text, fl.folder_id::text, false,
+			octet_length(fl.data), fl.current_version, fl.revision, fl.create_time, fl.update_time,
+			fl.tags, fl.description, fl.metadata
+		FROM files fl JOIN tree t ON fl.folder_id = t.id
+		WHERE $2::boolean
+	)
+	SELECT 
+		path, id, name, parent, is_folder, size, version, revision, create_time, update_time, tags, description, metadata
+	FROM entries
+	WHERE $3::text IS NULL OR (path COLLATE "C", id) > ($3::text COLLATE "C", $4::uuid)
+	ORDER BY path COLLATE "C", id
+	LIMIT $5;
+	`
+
+	var folder, after, afterKey interface{}
+	if folderID != "" {
+		folder = folderID
+	}
+	if afterPath != "" {
+		after, afterKey = afterPath, afterID
+	}
+
+	rows, err := r.reader(ctx).Query(ctx, q, folder, recursive, after, afterKey, limit)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]FolderEntry, 0, limit)
+	for rows.Next() {
+		var (
+			entry    FolderEntry
+			fl       FileInfo
+			parent   string
+			isFolder bool
+		)
+		err = rows.Scan(&entry.Path, &fl.ID, &fl.Name, &parent, &isFolder, &fl.Size, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt, &fl.Tags, &fl.Description, &fl.Metadata)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+
+		if isFolder {
+			entry.Folder = &Folder{ID: fl.ID, ParentID: parent, Name: fl.Name, CreatedAt: fl.CreatedAt}
+		} else {
+			fl.FolderID = parent
+			entry.File = &fl
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)))
+
+	return entries, nil
+}
+
+// MoveFile переносит файл в папку folderID (пустой - в корень) одним запросом,
+// поэтому файл не может оказаться в двух папках или ни в одной.
+func (r *repository) MoveFile(ctx context.Context, id, folderID string, revision int64) (FileInfo, error) {
+	q := `
+	UPDATE files SET
+		folder_id = NULLIF($2, '')::uuid,
+		revision = revision + 1,
+		update_time = current_timestamp
+	WHERE id = $1 AND ($3::bigint = 0 OR revision = $3)
+	RETURNING id, name, octet_length(data), current_version, revision, create_time, update_time, tags, description, metadata, COALESCE(folder_id::text, '');
+	`
+
+	var fl FileInfo
+	err := r.conn(ctx).QueryRow(ctx, q, id, folderID, revision).
+		Scan(&fl.ID, &fl.Name, &fl.Size, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt, &fl.Tags, &fl.Description, &fl.Metadata, &fl.FolderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return FileInfo{}, r.checkRevision(ctx, id, revision)
+	}
+	if err != nil {
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return FileInfo{}, constraintErr
+		}
+		r.logger.Error(err)
+		return FileInfo{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: file %s moved to %q", formatQuery(q), fl.ID, fl.FolderID))
+
+	return fl, nil
+}
+
+// DeleteFolder удаляет папку и возвращает число удаленных папок и файлов; 0 папок - папка
+// не найдена. Без recursive непустая папка не удаляется (ErrFolderNotEmpty), с recursive
+// удаляются все вложенные папки и файлы в одной транзакции.
+func (r *repository) DeleteFolder(ctx context.Context, id string, recursive bool) (folders, files int64, err error) {
+	if !recursive {
+		// содержимое не дает удалить папку по внешним ключам files и folders
+		res, err := r.conn(ctx).Exec(ctx, `DELETE FROM folders WHERE id = $1;`, id)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return 0, 0, ErrFolderNotEmpty
+		}
+		if err != nil {
+			r.logger.Error(err)
+			return 0, 0, err
+		}
+		return res.RowsAffected(), 0, nil
+	}
+
+	err = r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		// Блокировка папок поддерева заставляет параллельные загрузки и создание папок в нем
+		// дождаться удаления и завершиться ошибкой внешнего ключа, а не оставить сирот.
+		lockQ := `
+		WITH RECURSIVE tree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION ALL
+			SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT id FROM folders WHERE id IN (SELECT id FROM tree) FOR UPDATE;
+		`
+
+		rows, err := tx.Query(ctx, lockQ, id)
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var folderID string
+			if err = rows.Scan(&folderID); err != nil {
+				rows.Close()
+				r.logger.Error(err)
+				return err
+			}
+			ids = append(ids, folderID)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		if len(ids) == 0 {
+			folders, files = 0, 0
+			return nil
+		}
+
+		// версии файлов удаляются каскадно, счетчики квот - триггером
+		deleteQ := `
+		WITH f AS (
+			DELETE FROM files WHERE folder_id = ANY($1::uuid[]) RETURNING id
+		), d AS (
+			DELETE FROM folders WHERE id = ANY($1::uuid[]) RETURNING id
+		)
+		SELECT (SELECT count(*) FROM d), (SELECT count(*) FROM f);
+		`
+
+		if err = tx.QueryRow(ctx, deleteQ, ids).Scan(&folders, &files); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("Folder %s deleted: %d folders, %d files", id, folders, files))
+
+	return folders, files, nil
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func (r *repository) CreateDownloadLink(ctx context.Context, link *DownloadLink, ttl time.Duration) error {
+	q := `
+	INSERT INTO download_links 
+		(file_id, max_uses, expire_time)
+	VALUES 
+		($1, $2, current_timestamp + make_interval(secs => $3))
+	RETURNING id, create_time, expire_time;
+	`
+
+	err := r.conn(ctx).QueryRow(ctx, q, link.FileID, link.MaxUses, ttl.Seconds()).Scan(&link.ID, &link.CreatedAt, &link.ExpiresAt)
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: created download link %s", formatQuery(q), link.ID))
+
+	return nil
+}
+
+// UseDownloadLink засчитывает одно скачивание. Возвращает ErrLinkExpired,
+// если ссылка истекла, исчерпана или удалена вместе с файлом.
+func (r *repository) UseDownloadLink(ctx context.Context, id string) error {
+	q := `
+	UPDATE download_links 
+	SET uses = uses + 1
+	WHERE id = $1 AND uses < max_uses AND expire_time > current_timestamp
+	RETURNING uses;
+	`
+
+	var uses int32
+	err := r.conn(ctx).QueryRow(ctx, q, id).Scan(&uses)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrLinkExpired
+	}
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: download link %s used %d times", formatQuery(q), id, uses))
+
+	return nil
+}
+
+func (r *repository) DeleteExpiredDownloadLinks(ctx context.Context) (int64, error) {
+	q := `
+	DELETE FROM download_links
+	WHERE expire_time <= current_timestamp OR uses >= max_uses;
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q)
+	if err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return res.RowsAffected(), nil
+}
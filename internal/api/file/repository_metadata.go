@@ -0,0 +1,49 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func (r *repository) UpdateMetadata(ctx context.Context, id string, update MetadataUpdate, revision int64) (FileInfo, error) {
+	// Метаданные не версионируются, но меняют ревизию, чтобы параллельные изменения
+	// с If-Match не затирали друг друга.
+	q := `
+	UPDATE files SET
+		tags = CASE WHEN $2::boolean THEN COALESCE($3::text[], '{}') ELSE tags END,
+		description = CASE WHEN $4::boolean THEN $5::text ELSE description END,
+		metadata = CASE WHEN $6::boolean THEN $7::jsonb ELSE (metadata - COALESCE($8::text[], '{}')) || $7::jsonb END,
+		revision = revision + 1,
+		update_time = current_timestamp
+	WHERE id = $1 AND ($9::bigint = 0 OR revision = $9)
+	RETURNING id, name, octet_length(data), current_version, revision, create_time, update_time, tags, description, metadata, COALESCE(folder_id::text, '');
+	`
+
+	var fl FileInfo
+	err := r.conn(ctx).QueryRow(ctx, q,
+		id,
+		update.SetTags, update.Tags,
+		update.SetDescription, update.Description,
+		update.ReplaceMetadata, metadataJSON(update.Metadata), update.DeleteKeys,
+		revision,
+	).Scan(&fl.ID, &fl.Name, &fl.Size, &fl.Version, &fl.Revision, &fl.CreatedAt, &fl.UpdatedAt, &fl.Tags, &fl.Description, &fl.Metadata, &fl.FolderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return FileInfo{}, r.checkRevision(ctx, id, revision)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == checkViolation && pgErr.ConstraintName == "files_metadata_keys_check" {
+			return FileInfo{}, fmt.Errorf("%w: at most %d keys are allowed", ErrTooManyMetadataKeys, maxMetadataKeys)
+		}
+		r.logger.Error(err)
+		return FileInfo{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: file %s revision %d", formatQuery(q), fl.ID, fl.Revision))
+
+	return fl, nil
+}
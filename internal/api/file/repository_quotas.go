@@ -0,0 +1,147 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	// GlobalOwner - владелец, под которым хранятся квота и использование всего бакета.
+	GlobalOwner = "*"
+	// DeploymentBucket вместе с GlobalOwner - квота и использование всех бакетов сервиса.
+	DeploymentBucket = "*"
+	DefaultOwner     = "default"
+
+	// quotaExceededCode - SQLSTATE, с которым триггер files_track_usage отклоняет запись
+	quotaExceededCode = "QT001"
+	// fileTooLargeCode - SQLSTATE отказа по наибольшему размеру файла бакета
+	fileTooLargeCode = "QT002"
+)
+
+func ownerOrDefault(owner string) string {
+	if owner == "" {
+		return DefaultOwner
+	}
+	return owner
+}
+
+// limitExceeded возвращает *QuotaExceededError, если err - отказ триггера квот,
+// ErrFileTooLarge - если отказ по размеру файла бакета, и nil в остальных случаях.
+func limitExceeded(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	switch pgErr.Code {
+	case quotaExceededCode:
+		// без разобранных деталей ошибка все равно остается превышением квоты
+		quotaErr := &QuotaExceededError{}
+		json.Unmarshal([]byte(pgErr.Detail), &quotaErr.Usage)
+		return quotaErr
+	case fileTooLargeCode:
+		return fmt.Errorf("%w: limit is %s bytes", ErrFileTooLarge, pgErr.Detail)
+	}
+	return nil
+}
+
+// GetUsage возвращает использование владельца в текущем бакете (GlobalOwner - всего бакета)
+// или нулевое использование, если у владельца еще нет файлов.
+func (r *repository) GetUsage(ctx context.Context, owner string) (Usage, error) {
+	q := `
+	SELECT 
+		o.bucket,
+		COALESCE(u.bytes, 0),
+		COALESCE(u.files, 0),
+		COALESCE(q.max_bytes, 0),
+		COALESCE(q.max_files, 0)
+	FROM (SELECT current_bucket() AS bucket, $1::varchar AS owner) o
+	LEFT JOIN quota_usage u ON u.bucket = o.bucket AND u.owner = o.owner
+	LEFT JOIN quotas q ON q.bucket = o.bucket AND q.owner = o.owner;
+	`
+
+	usage := Usage{Owner: owner}
+	err := r.conn(ctx).QueryRow(ctx, q, owner).Scan(&usage.Bucket, &usage.Bytes, &usage.Files, &usage.MaxBytes, &usage.MaxFiles)
+	if err != nil {
+		r.logger.Error(err)
+		return Usage{}, err
+	}
+
+	return usage, nil
+}
+
+// GetDeploymentUsage возвращает использование и квоту всех бакетов вместе. Политики RLS
+// скрывают эту строку от запросов бакета, поэтому ctx не должен задавать бакет.
+func (r *repository) GetDeploymentUsage(ctx context.Context) (Usage, error) {
+	q := `
+	SELECT 
+		COALESCE(u.bytes, 0),
+		COALESCE(u.files, 0),
+		COALESCE(q.max_bytes, 0),
+		COALESCE(q.max_files, 0)
+	FROM (SELECT $1::varchar AS bucket, $2::varchar AS owner) o
+	LEFT JOIN quota_usage u ON u.bucket = o.bucket AND u.owner = o.owner
+	LEFT JOIN quotas q ON q.bucket = o.bucket AND q.owner = o.owner;
+	`
+
+	usage := Usage{Bucket: DeploymentBucket, Owner: GlobalOwner}
+	err := r.conn(ctx).QueryRow(ctx, q, DeploymentBucket, GlobalOwner).Scan(&usage.Bytes, &usage.Files, &usage.MaxBytes, &usage.MaxFiles)
+	if err != nil {
+		r.logger.Error(err)
+		return Usage{}, err
+	}
+
+	return usage, nil
+}
+
+// SetDeploymentQuota задает квоту всех бакетов вместе; ctx, как и в GetDeploymentUsage, без бакета.
+func (r *repository) SetDeploymentQuota(ctx context.Context, maxBytes, maxFiles int64) error {
+	q := `
+	INSERT INTO quotas 
+		(bucket, owner, max_bytes, max_files)
+	VALUES 
+		($1, $2, $3, $4)
+	ON CONFLICT (bucket, owner) DO UPDATE SET
+		max_bytes = EXCLUDED.max_bytes,
+		max_files = EXCLUDED.max_files;
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q, DeploymentBucket, GlobalOwner, maxBytes, maxFiles)
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String()))
+
+	return nil
+}
+
+// SetQuota задает квоту владельца; нулевые лимиты снимают ограничение.
+// Уже сохраненные файлы не проверяются, новая квота действует на следующие записи.
+func (r *repository) SetQuota(ctx context.Context, owner string, maxBytes, maxFiles int64) error {
+	q := `
+	INSERT INTO quotas 
+		(owner, max_bytes, max_files)
+	VALUES 
+		($1, $2, $3)
+	ON CONFLICT (bucket, owner) DO UPDATE SET
+		max_bytes = EXCLUDED.max_bytes,
+		max_files = EXCLUDED.max_files;
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q, owner, maxBytes, maxFiles)
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package file
+
+import (
+	"context"
+	"fmt"
+)
+
+// Search возвращает до limit файлов, найденных по query, начиная с offset, по убыванию
+// релевантности. Файл находится, если слова запроса есть в имени, тегах или описании
+// (полнотекстовый поиск, имя весит больше) или имя похоже на запрос (сходство триграмм
+// pg_trgm), поэтому находятся и части слов, и имена с опечатками. Содержимое файлов не читается.
+// Фрагменты с совпадениями строит ts_headline из имени и описания, экранированных для HTML.
+func (r *repository) Search(ctx context.Context, query string, limit, offset int) ([]SearchResult, error) {
+	q := `
+	WITH q AS (
+		SELECT websearch_to_tsquery('simple', $1) AS tsq
+	)
+	SELECT
+		f.id,
+		f.name,
+		octet_length(f.data),
+		f.current_version,
+		f.revision,
+		f.create_time,
+		f.update_time,
+		f.tags,
+		f.description,
+		f.metadata,
+		COALESCE(f.folder_id::text, ''),
+		ts_rank(f.search_vector, q.tsq) + word_similarity($1, f.name) AS rank,
+		ts_headline(
+			'simple',
+			replace(replace(replace(f.name || E'\n' || f.description, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+			q.tsq,
+			'MaxFragments=2, MaxWords=20, MinWords=5'
+		)
+	FROM files f, q
+	WHERE f.search_vector @@ q.tsq OR $1 <% f.name
+	ORDER BY rank DESC, f.id
+	LIMIT $2 OFFSET $3;
+	`
+
+	rows, err := r.reader(ctx).Query(ctx, q, query, limit, offset)
+	if err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0, limit)
+	for rows.Next() {
+		var res SearchResult
+		err = rows.Scan(&res.ID, &res.Name, &res.Size, &res.Version, &res.Revision, &res.CreatedAt, &res.UpdatedAt, &res.Tags, &res.Description, &res.Metadata, &res.FolderID, &res.Rank, &res.Highlight)
+		if err != nil {
+			r.logger.Error(err)
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error(err)
+		return nil, err
+	}
+
+	res := rows.CommandTag()
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return results, nil
+}
@@ -0,0 +1,222 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func (r *repository) CreateUploadSession(ctx context.Context, session *UploadSession, ttl time.Duration) error {
+	q := `
+	INSERT INTO upload_sessions 
+		(name, total_size, checksum, owner, expire_time, folder_id)
+	VALUES 
+		($1, $2, $3, $4, current_timestamp + make_interval(secs => $5), NULLIF($6, '')::uuid)
+	RETURNING id, create_time, expire_time;
+	`
+
+	session.Owner = ownerOrDefault(session.Owner)
+	err := r.conn(ctx).QueryRow(ctx, q, session.Name, session.TotalSize, session.Checksum, session.Owner, ttl.Seconds(), session.FolderID).Scan(&session.ID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		if constraintErr := constraintError(err); constraintErr != nil {
+			return constraintErr
+		}
+		if limitErr := limitExceeded(err); limitErr != nil {
+			return limitErr
+		}
+		r.logger.Error(err)
+		return err
+	}
+
+	r.logger.Debug(fmt.Sprintf("SQL Query: %s\n\tResult: created upload session %s", formatQuery(q), session.ID))
+
+	return nil
+}
+
+// FindUploadSession возвращает пустую сессию, если она не найдена или истекла.
+func (r *repository) FindUploadSession(ctx context.Context, id string) (UploadSession, error) {
+	q := `
+	SELECT id, name, total_size, checksum, received_size, owner, COALESCE(folder_id::text, ''), create_time, expire_time 
+	FROM upload_sessions 
+	WHERE id = $1 AND expire_time > current_timestamp;
+	`
+
+	var session UploadSession
+	err := r.conn(ctx).QueryRow(ctx, q, id).Scan(&session.ID, &session.Name, &session.TotalSize, &session.Checksum, &session.ReceivedSize, &session.Owner, &session.FolderID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return UploadSession{}, nil
+		}
+		r.logger.Error(err)
+		return UploadSession{}, err
+	}
+
+	return session, nil
+}
+
+// lockUploadSession блокирует строку сессии до конца транзакции, чтобы параллельные
+// запросы к одной сессии выполнялись последовательно.
+func (r *repository) lockUploadSession(ctx context.Context, tx pgx.Tx, id string) (UploadSession, error) {
+	q := `
+	SELECT id, name, total_size, checksum, received_size, owner, COALESCE(folder_id::text, ''), create_time, expire_time 
+	FROM upload_sessions 
+	WHERE id = $1 AND expire_time > current_timestamp
+	FOR UPDATE;
+	`
+
+	var session UploadSession
+	err := tx.QueryRow(ctx, q, id).Scan(&session.ID, &session.Name, &session.TotalSize, &session.Checksum, &session.ReceivedSize, &session.Owner, &session.FolderID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return UploadSession{}, ErrUploadSessionNotFound
+		}
+		r.logger.Error(err)
+		return UploadSession{}, err
+	}
+
+	return session, nil
+}
+
+// AppendUploadChunk дописывает часть данных в сессию. Смещение должно совпадать с уже
+// принятым объемом, поэтому части принимаются строго по порядку. Каждая часть продлевает сессию на ttl.
+func (r *repository) AppendUploadChunk(ctx context.Context, id string, offset int64, data []byte, ttl time.Duration) (UploadSession, error) {
+	var session UploadSession
+	err := r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) (err error) {
+		session, err = r.lockUploadSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if offset != session.ReceivedSize {
+			return fmt.Errorf("%w: expected offset %d, got %d", ErrUploadOffsetMismatch, session.ReceivedSize, offset)
+		}
+		if session.ReceivedSize+int64(len(data)) > session.TotalSize {
+			return fmt.Errorf("%w: %d bytes left, got %d", ErrUploadSizeExceeded, session.TotalSize-session.ReceivedSize, len(data))
+		}
+
+		q := `
+		INSERT INTO upload_chunks 
+			(session_id, chunk_offset, data)
+		VALUES 
+			($1, $2, $3);
+		`
+
+		if _, err = tx.Exec(ctx, q, id, offset, data); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		q = `
+		UPDATE upload_sessions SET
+			received_size = received_size + $2,
+			expire_time = current_timestamp + make_interval(secs => $3)
+		WHERE id = $1
+		RETURNING received_size, expire_time;
+		`
+
+		// принятые части входят в квоту владельца сессии
+		if err = tx.QueryRow(ctx, q, id, int64(len(data)), ttl.Seconds()).Scan(&session.ReceivedSize, &session.ExpiresAt); err != nil {
+			if limitErr := limitExceeded(err); limitErr != nil {
+				return limitErr
+			}
+			r.logger.Error(err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrUploadOffsetMismatch) || errors.Is(err, ErrUploadSizeExceeded) {
+			return session, err
+		}
+		return UploadSession{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("Upload session %s: received %d of %d bytes", id, session.ReceivedSize, session.TotalSize))
+
+	return session, nil
+}
+
+// CompleteUploadSession собирает части в файл, проверяя размер и контрольную сумму.
+// Файл появляется в списке только после успешного завершения; сессия при этом удаляется.
+// При несовпадении контрольной суммы сессия также удаляется, так как исправить ее уже нельзя.
+func (r *repository) CompleteUploadSession(ctx context.Context, id string) (File, error) {
+	var (
+		fl       File
+		mismatch bool
+	)
+	err := r.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		session, err := r.lockUploadSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if session.ReceivedSize != session.TotalSize {
+			return fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, session.ReceivedSize, session.TotalSize)
+		}
+
+		q := `
+		SELECT COALESCE(string_agg(data, ''::bytea ORDER BY chunk_offset), ''::bytea) 
+		FROM upload_chunks 
+		WHERE session_id = $1;
+		`
+
+		var data []byte
+		if err = tx.QueryRow(ctx, q, id).Scan(&data); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		deleteQ := `
+		DELETE FROM upload_sessions WHERE id = $1;
+		`
+
+		// сессия удаляется до создания файла, чтобы принятые части не учитывались в квоте
+		// дважды. При несовпадении сессия удаляется и транзакция фиксируется, ошибка
+		// возвращается после нее
+		if _, err = tx.Exec(ctx, deleteQ, id); err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		mismatch = int64(len(data)) != session.TotalSize || hex.EncodeToString(sum[:]) != session.Checksum
+		if !mismatch {
+			fl = File{Name: session.Name, Data: data, Owner: session.Owner, FolderID: session.FolderID}
+			if err = r.create(ctx, tx, &fl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return File{}, err
+	}
+	if mismatch {
+		return File{}, ErrChecksumMismatch
+	}
+
+	r.logger.Debug(fmt.Sprintf("Upload session %s completed as file %s", id, fl.ID))
+
+	return fl, nil
+}
+
+func (r *repository) DeleteExpiredUploadSessions(ctx context.Context) (int64, error) {
+	q := `
+	DELETE FROM upload_sessions
+	WHERE expire_time <= current_timestamp;
+	`
+
+	res, err := r.conn(ctx).Exec(ctx, q)
+	if err != nil {
+		r.logger.Error(err)
+		return 0, err
+	}
+
+	response := fmt.Sprintf("SQL Query: %s", formatQuery(q)+"\n\tResult: "+res.String())
+	r.logger.Debug(response)
+
+	return res.RowsAffected(), nil
+}
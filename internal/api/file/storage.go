@@ -2,12 +2,96 @@ package file
 
 import (
 	"context"
+	"time"
 )
 
 type FileRepository interface {
+	// WithTx выполняет fn в одной транзакции: вызовы методов репозитория с ctx из fn
+	// фиксируются вместе, если fn вернула nil, и откатываются при ошибке.
+	// При конфликте сериализации fn может быть вызвана повторно.
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+
 	Create(ctx context.Context, fl *File) error
-	FindAll(ctx context.Context) (files []File, err error)
+	CreateIdempotent(ctx context.Context, fl *File, key, fingerprint string, ttl time.Duration) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error)
+	FindAll(ctx context.Context, filter FileFilter) (files []FileInfo, err error)
+	FindPage(ctx context.Context, filter FileFilter, afterID string, limit int) (files []FileInfo, err error)
 	FindOne(ctx context.Context, id string) (File, error)
-	Update(ctx context.Context, fl *File) (files []File, err error)
-	Delete(ctx context.Context, id string) ([]string, error)
+	FindRevision(ctx context.Context, id string) (int64, error)
+	Search(ctx context.Context, query string, limit, offset int) ([]SearchResult, error)
+	// Update и Delete при ненулевой ревизии возвращают ErrPreconditionFailed,
+	// если текущая ревизия файла отличается от ожидаемой.
+	// Update при setData сохраняет fl.Data новой версией, даже пустое; пустое имя не меняется.
+	Update(ctx context.Context, fl *File, setData bool) (files []File, err error)
+	Delete(ctx context.Context, id string, revision int64) ([]string, error)
+	// UpdateMetadata возвращает пустой FileInfo, если файл не найден, и ErrPreconditionFailed,
+	// если при ненулевой ревизии текущая ревизия файла отличается от ожидаемой.
+	UpdateMetadata(ctx context.Context, id string, update MetadataUpdate, revision int64) (FileInfo, error)
+
+	// Папки: ID пустой строкой означает корень. Запись с занятым в папке именем
+	// возвращает ErrNameExists, с несуществующей папкой - ErrFolderNotFound.
+	CreateFolder(ctx context.Context, folder *Folder) error
+	FindFolder(ctx context.Context, id string) (Folder, error)
+	ListFolder(ctx context.Context, folderID string, recursive bool, afterPath, afterID string, limit int) ([]FolderEntry, error)
+	// MoveFile возвращает пустой FileInfo, если файл не найден.
+	MoveFile(ctx context.Context, id, folderID string, revision int64) (FileInfo, error)
+	DeleteFolder(ctx context.Context, id string, recursive bool) (folders, files int64, err error)
+
+	// CreateMany вставляет все файлы в одной транзакции: либо все, либо ни одного.
+	CreateMany(ctx context.Context, files []*File) error
+	// CreateEach вставляет каждый файл отдельно и возвращает ошибки по позициям files;
+	// err - сбой, после которого не вставлен ни один файл.
+	CreateEach(ctx context.Context, files []*File) (errs []error, err error)
+	FindMany(ctx context.Context, ids []string) ([]File, error)
+	// DeleteMany при atomic удаляет файлы, только если найдены все, иначе ничего не удаляет.
+	DeleteMany(ctx context.Context, ids []string, atomic bool) ([]string, error)
+	// SumSizes возвращает суммарный размер версии version (0 - текущей) файлов ids;
+	// ненайденные файлы не учитываются.
+	SumSizes(ctx context.Context, ids []string, version int32) (int64, error)
+	// ForEach по одному читает файлы с указанными ID (или все, если ids пуст) с именем,
+	// начинающимся с namePrefix, не загружая их в память целиком. Ошибка fn прерывает обход.
+	ForEach(ctx context.Context, ids []string, namePrefix string, fn func(File) error) error
+	// MeasureArchive считает файлы, которые выберет ForEach с теми же ids и namePrefix.
+	MeasureArchive(ctx context.Context, ids []string, namePrefix string) (ArchiveSize, error)
+
+	FindVersions(ctx context.Context, id string) ([]FileVersion, error)
+	FindVersion(ctx context.Context, id string, version int32) (FileVersion, error)
+	RestoreVersion(ctx context.Context, id string, version int32, revision int64) (File, error)
+	// PruneVersions применяет keepLast и keepDays к бакетам без собственных настроек хранения.
+	PruneVersions(ctx context.Context, keepLast, keepDays int) (int64, error)
+
+	CreateUploadSession(ctx context.Context, session *UploadSession, ttl time.Duration) error
+	FindUploadSession(ctx context.Context, id string) (UploadSession, error)
+	AppendUploadChunk(ctx context.Context, id string, offset int64, data []byte, ttl time.Duration) (UploadSession, error)
+	CompleteUploadSession(ctx context.Context, id string) (File, error)
+	DeleteExpiredUploadSessions(ctx context.Context) (int64, error)
+
+	CreateDownloadLink(ctx context.Context, link *DownloadLink, ttl time.Duration) error
+	UseDownloadLink(ctx context.Context, id string) error
+	DeleteExpiredDownloadLinks(ctx context.Context) (int64, error)
+
+	// Журнал изменений файлов для WatchFiles, см. FileEventCursor.
+	LatestFileEventCursor(ctx context.Context) (FileEventCursor, error)
+	// FileEventCursorExpired сообщает, удалены ли события после cursor.
+	FileEventCursorExpired(ctx context.Context, cursor FileEventCursor) (bool, error)
+	ListFileEvents(ctx context.Context, after FileEventCursor, limit int) ([]FileEvent, error)
+	DeleteExpiredFileEvents(ctx context.Context, retention time.Duration) (int64, error)
+
+	// Бакеты. Остальные методы работают в бакете, заданном в ctx (см. Server.bucketContext),
+	// и не видят строк других бакетов; без бакета в ctx видны все бакеты, а новые строки
+	// попадают в DefaultBucket.
+	CreateBucket(ctx context.Context, bucket *Bucket) error
+	// UpdateBucket возвращает ErrBucketNotFound, если бакета нет.
+	UpdateBucket(ctx context.Context, bucket *Bucket) error
+	// FindBucket возвращает пустой Bucket, если бакет не найден.
+	FindBucket(ctx context.Context, name string) (Bucket, error)
+	ListBuckets(ctx context.Context) ([]Bucket, error)
+	DeleteBucket(ctx context.Context, name string, force bool) (files int64, err error)
+
+	// Использование ведется в базе при каждой записи; превышение квоты - *QuotaExceededError.
+	GetUsage(ctx context.Context, owner string) (Usage, error)
+	SetQuota(ctx context.Context, owner string, maxBytes, maxFiles int64) error
+	// Квота всех бакетов вместе; ctx без бакета.
+	GetDeploymentUsage(ctx context.Context) (Usage, error)
+	SetDeploymentQuota(ctx context.Context, maxBytes, maxFiles int64) error
 }
@@ -0,0 +1,604 @@
+// Package gateway отдает FileService по HTTP. Запросы переводятся в вызовы тех же
+// обработчиков file.Server, поэтому ограничения параллелизма и проверки общие с gRPC.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "app/api/proto"
+	"app/pkg/logging"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// параметр запроса meta.<ключ>=<значение> задает или фильтрует метаданные файла
+const metadataParamPrefix = "meta."
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// forwardedHeaders передаются обработчикам как входящие метаданные gRPC,
+// чтобы авторизация и идемпотентность работали так же, как для gRPC-клиентов.
+var forwardedHeaders = []string{"authorization", "idempotency-key", "x-owner", "x-bucket", "x-admin-token", "x-priority"}
+
+type Gateway struct {
+	server        pb.FileServiceServer
+	logger        *logging.Logger
+	maxUploadSize int64
+}
+
+func NewHandler(logger *logging.Logger, server pb.FileServiceServer, maxUploadSize int64) http.Handler {
+	g := &Gateway{server: server, logger: logger, maxUploadSize: maxUploadSize}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /files", g.upload)
+	mux.HandleFunc("GET /files", g.list)
+	mux.HandleFunc("GET /files/search", g.search)
+	mux.HandleFunc("GET /files/{id}", g.download)
+	mux.HandleFunc("PATCH /files/{id}", g.patch)
+	mux.HandleFunc("DELETE /files/{id}", g.delete)
+	mux.HandleFunc("POST /files/{id}/move", g.move)
+	mux.HandleFunc("POST /folders", g.createFolder)
+	mux.HandleFunc("GET /folders", g.listFolder)
+	mux.HandleFunc("GET /folders/{id}", g.listFolder)
+	mux.HandleFunc("DELETE /folders/{id}", g.deleteFolder)
+	mux.HandleFunc("GET /links/{token}", g.downloadByLink)
+	mux.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	return mux
+}
+
+func (g *Gateway) upload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, g.maxUploadSize)
+
+	name := r.URL.Query().Get("name")
+	var data []byte
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		name, data, err = readMultipart(r, name)
+	} else {
+		if name == "" {
+			if _, params, parseErr := mime.ParseMediaType(r.Header.Get("Content-Disposition")); parseErr == nil {
+				name = params["filename"]
+			}
+		}
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codes.InvalidArgument, fmt.Sprintf("request body is larger than %d bytes", g.maxUploadSize))
+			return
+		}
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, err.Error())
+		return
+	}
+	if name == "" {
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, "file name is required: use multipart filename, ?name= or Content-Disposition")
+		return
+	}
+
+	query := r.URL.Query()
+	res, err := g.server.UploadFile(incomingContext(r), &pb.UploadFileRequest{
+		FileName:    name,
+		Data:        data,
+		Tags:        query["tag"],
+		Description: query.Get("description"),
+		Metadata:    metadataParams(query),
+		FolderId:    query.Get("folder_id"),
+	})
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/files/"+res.Id)
+	writeJSON(w, http.StatusCreated, map[string]string{"id": res.Id})
+}
+
+// readMultipart берет первую часть с файлом; поле name, если есть, переопределяет имя файла.
+func readMultipart(r *http.Request, name string) (string, []byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var data []byte
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch {
+		case part.FormName() == "name" && part.FileName() == "":
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return "", nil, err
+			}
+			name = string(value)
+		case part.FileName() != "" && !found:
+			if data, err = io.ReadAll(part); err != nil {
+				return "", nil, err
+			}
+			if name == "" {
+				name = part.FileName()
+			}
+			found = true
+		}
+		part.Close()
+	}
+
+	if !found {
+		return "", nil, errors.New("multipart body has no file part")
+	}
+	return name, data, nil
+}
+
+func (g *Gateway) download(w http.ResponseWriter, r *http.Request) {
+	req := &pb.DownloadFileRequest{Id: r.PathValue("id"), IfNoneMatch: r.Header.Get("If-None-Match")}
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || version < 0 {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid version")
+			return
+		}
+		req.Version = int32(version)
+	}
+
+	res, err := g.server.DownloadFile(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	// у старых версий etag нет, и ServeContent не сравнивает с ним If-None-Match
+	if res.Etag != "" {
+		w.Header().Set("ETag", quoteETag(res.Etag))
+	}
+	if res.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// DownloadFile возвращает пустой ответ для несуществующего файла
+	if res.FileName == "" {
+		writeError(w, http.StatusNotFound, codes.NotFound, fmt.Sprintf("file %s not found", req.Id))
+		return
+	}
+
+	serveFile(w, r, res)
+}
+
+// downloadByLink отдает файл по подписанной ссылке без других учетных данных.
+// Для ссылок с ограничением каждый запрос, в том числе с Range, считается скачиванием.
+func (g *Gateway) downloadByLink(w http.ResponseWriter, r *http.Request) {
+	res, err := g.server.DownloadByLink(r.Context(), &pb.DownloadByLinkRequest{Token: r.PathValue("token")})
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(res.Etag))
+	serveFile(w, r, res)
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, res *pb.DownloadFileResponse) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": res.FileName}))
+	// ServeContent определяет Content-Type по расширению или содержимому,
+	// выставляет Last-Modified и обрабатывает Range и If-Range.
+	http.ServeContent(w, r, res.FileName, time.Unix(res.UpdatedAt, 0), bytes.NewReader(res.Data))
+}
+
+type fileJSON struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	ETag        string            `json:"etag"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	FolderID    string            `json:"folder_id,omitempty"`
+}
+
+type listJSON struct {
+	Files         []fileJSON `json:"files"`
+	NextPageToken string     `json:"next_page_token,omitempty"`
+}
+
+func toFileJSON(f *pb.FileMetadata) fileJSON {
+	return fileJSON{
+		ID:          f.Id,
+		Name:        f.Name,
+		Size:        f.Size,
+		ETag:        quoteETag(f.Etag),
+		CreatedAt:   time.Unix(f.CreatedAt, 0).UTC(),
+		UpdatedAt:   time.Unix(f.UpdatedAt, 0).UTC(),
+		Tags:        f.Tags,
+		Description: f.Description,
+		Metadata:    f.Metadata,
+		FolderID:    f.FolderId,
+	}
+}
+
+func metadataParams(query url.Values) map[string]string {
+	var m map[string]string
+	for key, values := range query {
+		if k, ok := strings.CutPrefix(key, metadataParamPrefix); ok {
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[k] = values[0]
+		}
+	}
+	return m
+}
+
+type searchResultJSON struct {
+	File        fileJSON   `json:"file"`
+	Rank        float64    `json:"rank"`
+	NameMatches [][2]int32 `json:"name_matches,omitempty"`
+	Highlight   string     `json:"highlight,omitempty"`
+}
+
+type searchJSON struct {
+	Results       []searchResultJSON `json:"results"`
+	NextPageToken string             `json:"next_page_token,omitempty"`
+}
+
+func (g *Gateway) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &pb.ListFilesRequest{PageToken: query.Get("page_token"), Tags: query["tag"], Metadata: metadataParams(query)}
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		pageSize, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid page_size")
+			return
+		}
+		req.PageSize = int32(pageSize)
+	}
+
+	res, err := g.server.ListFiles(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	out := listJSON{Files: make([]fileJSON, 0, len(res.Files)), NextPageToken: res.NextPageToken}
+	for _, f := range res.Files {
+		out.Files = append(out.Files, toFileJSON(f))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (g *Gateway) search(w http.ResponseWriter, r *http.Request) {
+	req := &pb.SearchFilesRequest{Query: r.URL.Query().Get("q"), PageToken: r.URL.Query().Get("page_token")}
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		pageSize, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid page_size")
+			return
+		}
+		req.PageSize = int32(pageSize)
+	}
+
+	res, err := g.server.SearchFiles(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	out := searchJSON{Results: make([]searchResultJSON, 0, len(res.Results)), NextPageToken: res.NextPageToken}
+	for _, result := range res.Results {
+		item := searchResultJSON{File: toFileJSON(result.File), Rank: result.Rank, Highlight: result.Highlight}
+		for _, m := range result.NameMatches {
+			item.NameMatches = append(item.NameMatches, [2]int32{m.Start, m.End})
+		}
+		out.Results = append(out.Results, item)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// patch меняет теги, описание и метаданные по правилам JSON Merge Patch (RFC 7396):
+// меняются только переданные поля, null очищает поле, а в metadata - удаляет ключ.
+func (g *Gateway) patch(w http.ResponseWriter, r *http.Request) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.maxUploadSize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	req := &pb.UpdateFileMetadataRequest{Id: r.PathValue("id"), IfMatch: r.Header.Get("If-Match"), UpdateMask: &fieldmaskpb.FieldMask{}}
+	for field, raw := range body {
+		var err error
+		switch field {
+		case "tags":
+			err = json.Unmarshal(raw, &req.Tags)
+			req.UpdateMask.Paths = append(req.UpdateMask.Paths, "tags")
+		case "description":
+			var description *string
+			if err = json.Unmarshal(raw, &description); description != nil {
+				req.Description = *description
+			}
+			req.UpdateMask.Paths = append(req.UpdateMask.Paths, "description")
+		case "metadata":
+			var values map[string]*string
+			if err = json.Unmarshal(raw, &values); err != nil {
+				break
+			}
+			if values == nil {
+				// null удаляет все метаданные
+				req.UpdateMask.Paths = append(req.UpdateMask.Paths, "metadata")
+				break
+			}
+			for key, value := range values {
+				if value != nil {
+					if req.Metadata == nil {
+						req.Metadata = make(map[string]string)
+					}
+					req.Metadata[key] = *value
+				}
+				req.UpdateMask.Paths = append(req.UpdateMask.Paths, "metadata."+key)
+			}
+		default:
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Sprintf("field %q cannot be changed", field))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", field, err))
+			return
+		}
+	}
+	if len(req.UpdateMask.Paths) == 0 {
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, "nothing to change: pass tags, description or metadata")
+		return
+	}
+
+	res, err := g.server.UpdateFileMetadata(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(res.File.Etag))
+	writeJSON(w, http.StatusOK, toFileJSON(res.File))
+}
+
+func (g *Gateway) delete(w http.ResponseWriter, r *http.Request) {
+	_, err := g.server.DeleteFile(incomingContext(r), &pb.DeleteFileRequest{Id: r.PathValue("id"), IfMatch: r.Header.Get("If-Match")})
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// move переносит файл в папку из тела {"folder_id": ...}; пустой или отсутствующий folder_id - корень.
+func (g *Gateway) move(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		FolderID string `json:"folder_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.maxUploadSize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	res, err := g.server.MoveFile(incomingContext(r), &pb.MoveFileRequest{Id: r.PathValue("id"), FolderId: body.FolderID, IfMatch: r.Header.Get("If-Match")})
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(res.File.Etag))
+	writeJSON(w, http.StatusOK, toFileJSON(res.File))
+}
+
+type folderJSON struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toFolderJSON(f *pb.Folder) folderJSON {
+	return folderJSON{ID: f.Id, ParentID: f.ParentId, Name: f.Name, CreatedAt: time.Unix(f.CreatedAt, 0).UTC()}
+}
+
+type folderEntryJSON struct {
+	Path   string      `json:"path"`
+	Folder *folderJSON `json:"folder,omitempty"`
+	File   *fileJSON   `json:"file,omitempty"`
+}
+
+type folderListJSON struct {
+	Entries       []folderEntryJSON `json:"entries"`
+	NextPageToken string            `json:"next_page_token,omitempty"`
+}
+
+func (g *Gateway) createFolder(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name     string `json:"name"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.maxUploadSize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	res, err := g.server.CreateFolder(incomingContext(r), &pb.CreateFolderRequest{Name: body.Name, ParentId: body.ParentID})
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/folders/"+res.Folder.Id)
+	writeJSON(w, http.StatusCreated, toFolderJSON(res.Folder))
+}
+
+// listFolder без {id} показывает корень.
+func (g *Gateway) listFolder(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &pb.ListFolderRequest{FolderId: r.PathValue("id"), PageToken: query.Get("page_token")}
+	if v := query.Get("recursive"); v != "" {
+		recursive, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid recursive")
+			return
+		}
+		req.Recursive = recursive
+	}
+	if v := query.Get("page_size"); v != "" {
+		pageSize, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid page_size")
+			return
+		}
+		req.PageSize = int32(pageSize)
+	}
+
+	res, err := g.server.ListFolder(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+
+	out := folderListJSON{Entries: make([]folderEntryJSON, 0, len(res.Entries)), NextPageToken: res.NextPageToken}
+	for _, entry := range res.Entries {
+		item := folderEntryJSON{Path: entry.Path}
+		if folder := entry.GetFolder(); folder != nil {
+			f := toFolderJSON(folder)
+			item.Folder = &f
+		} else {
+			f := toFileJSON(entry.GetFile())
+			item.File = &f
+		}
+		out.Entries = append(out.Entries, item)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (g *Gateway) deleteFolder(w http.ResponseWriter, r *http.Request) {
+	req := &pb.DeleteFolderRequest{Id: r.PathValue("id")}
+	if v := r.URL.Query().Get("recursive"); v != "" {
+		recursive, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid recursive")
+			return
+		}
+		req.Recursive = recursive
+	}
+
+	res, err := g.server.DeleteFolder(incomingContext(r), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted_folders": res.DeletedFolders, "deleted_files": res.DeletedFiles})
+}
+
+func incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, header := range forwardedHeaders {
+		if values := r.Header.Values(header); len(values) > 0 {
+			md.Set(header, values...)
+		}
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	// адрес клиента нужен лимитам частоты; X-Forwarded-For не учитывается, так как ему нельзя доверять
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+	}
+	return ctx
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// writeStatus переводит ошибку обработчика в HTTP-ответ. Ошибки без gRPC-статуса
+// (например, ошибки базы) отдаются как 500 без подробностей.
+func (g *Gateway) writeStatus(w http.ResponseWriter, err error) {
+	s, ok := status.FromError(err)
+	if !ok {
+		g.logger.Error(fmt.Sprintf("HTTP gateway: %v", err))
+		writeError(w, http.StatusInternalServerError, codes.Internal, "internal error")
+		return
+	}
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			seconds := int64(math.Ceil(info.RetryDelay.AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+		}
+	}
+	writeError(w, httpStatus(s.Code()), s.Code(), s.Message())
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, httpCode int, code codes.Code, message string) {
+	writeJSON(w, httpCode, map[string]string{"code": code.String(), "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
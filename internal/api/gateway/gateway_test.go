@@ -0,0 +1,484 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "app/api/proto"
+	"app/internal/api/file"
+	mock_file "app/internal/api/file/mocks"
+	"app/internal/api/gateway"
+	"app/internal/api/ratelimit"
+	"app/pkg/client/postgresql"
+	"app/pkg/logging"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+const fileID = "00000000-0000-0000-0000-000000000001"
+
+func newGateway(t *testing.T) (*mock_file.MockFileRepository, http.Handler) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+	passThroughTx(repo)
+	logger := logging.NewTestLogger()
+	return repo, gateway.NewHandler(logger, file.NewServer(logger, repo), 1024)
+}
+
+// passThroughTx выполняет fn транзакции сразу, без ожиданий на сам WithTx.
+func passThroughTx(repo *mock_file.MockFileRepository) {
+	repo.EXPECT().WithTx(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(ctx context.Context, fn func(context.Context) error) error {
+		return fn(ctx)
+	})
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadMultipart(t *testing.T) {
+	repo, h := newGateway(t)
+
+	repo.EXPECT().
+		CreateIdempotent(gomock.Any(), gomock.Any(), "key-1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, fl *file.File, key, fingerprint string, ttl time.Duration) error {
+			assert.Equal(t, "cat.jpg", fl.Name)
+			assert.Equal(t, []byte("meow"), fl.Data)
+			fl.ID = fileID
+			return nil
+		})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "cat.jpg")
+	require.NoError(t, err)
+	part.Write([]byte("meow"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/files", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Idempotency-Key", "key-1")
+	rec := serve(h, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/files/"+fileID, rec.Header().Get("Location"))
+	assert.JSONEq(t, `{"id":"`+fileID+`"}`, rec.Body.String())
+}
+
+func TestUploadRawBody(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		repo, h := newGateway(t)
+
+		repo.EXPECT().
+			Create(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, fl *file.File) error {
+				assert.Equal(t, "notes.txt", fl.Name)
+				fl.ID = fileID
+				return nil
+			})
+
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/files?name=notes.txt", bytes.NewBufferString("hello")))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("MissingName", func(t *testing.T) {
+		_, h := newGateway(t)
+
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/files", bytes.NewBufferString("hello")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		_, h := newGateway(t)
+
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/files?name=big.bin", bytes.NewReader(make([]byte, 1025))))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+}
+
+func TestDownload(t *testing.T) {
+	updated := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored := file.File{ID: fileID, Name: "notes.txt", Data: []byte("0123456789"), Version: 1, Revision: 7, CreatedAt: updated, UpdatedAt: updated}
+
+	t.Run("Full", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().FindOne(gomock.Any(), fileID).Return(stored, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodGet, "/files/"+fileID, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "0123456789", rec.Body.String())
+		assert.Equal(t, `"7"`, rec.Header().Get("ETag"))
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, updated.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().FindOne(gomock.Any(), fileID).Return(stored, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/files/"+fileID, nil)
+		req.Header.Set("Range", "bytes=2-4")
+		rec := serve(h, req)
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "234", rec.Body.String())
+		assert.Equal(t, "bytes 2-4/10", rec.Header().Get("Content-Range"))
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().FindRevision(gomock.Any(), fileID).Return(int64(7), nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/files/"+fileID, nil)
+		req.Header.Set("If-None-Match", `"7"`)
+		rec := serve(h, req)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("OldVersion", func(t *testing.T) {
+		repo, h := newGateway(t)
+		current := stored
+		current.Version = 2
+		repo.EXPECT().FindOne(gomock.Any(), fileID).Return(current, nil)
+		repo.EXPECT().FindVersion(gomock.Any(), fileID, int32(1)).Return(file.FileVersion{Version: 1, Name: "notes.txt", Data: []byte("old"), CreatedAt: updated}, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodGet, "/files/"+fileID+"?version=1", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "old", rec.Body.String())
+		assert.Empty(t, rec.Header().Get("ETag"))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().FindOne(gomock.Any(), fileID).Return(file.File{}, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodGet, "/files/"+fileID, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestList(t *testing.T) {
+	repo, h := newGateway(t)
+	repo.EXPECT().FindPage(gomock.Any(), file.FileFilter{}, "", 2).Return([]file.FileInfo{{File: file.File{ID: fileID, Name: "a.txt", Revision: 1}, Size: 3}, {File: file.File{ID: fileID}}}, nil)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/files?page_size=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res struct {
+		Files []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+			ETag string `json:"etag"`
+		} `json:"files"`
+		NextPageToken string `json:"next_page_token"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res.Files, 1)
+	assert.Equal(t, "a.txt", res.Files[0].Name)
+	assert.Equal(t, int64(3), res.Files[0].Size)
+	assert.Equal(t, `"1"`, res.Files[0].ETag)
+	assert.NotEmpty(t, res.NextPageToken)
+}
+
+func TestListFilter(t *testing.T) {
+	repo, h := newGateway(t)
+	filter := file.FileFilter{Tags: []string{"cats", "raw"}, Metadata: map[string]string{"project": "apollo"}}
+	repo.EXPECT().FindAll(gomock.Any(), filter).Return([]file.FileInfo{{File: file.File{ID: fileID, Tags: []string{"cats", "raw"}, Metadata: map[string]string{"project": "apollo"}}}}, nil)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/files?tag=Cats&tag=raw&meta.project=apollo", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res struct {
+		Files []struct {
+			Tags     []string          `json:"tags"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"files"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res.Files, 1)
+	assert.Equal(t, []string{"cats", "raw"}, res.Files[0].Tags)
+	assert.Equal(t, map[string]string{"project": "apollo"}, res.Files[0].Metadata)
+}
+
+func TestPatch(t *testing.T) {
+	t.Run("MergePatch", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().
+			UpdateMetadata(gomock.Any(), fileID, gomock.Any(), int64(2)).
+			DoAndReturn(func(ctx context.Context, id string, update file.MetadataUpdate, revision int64) (file.FileInfo, error) {
+				assert.True(t, update.SetTags)
+				assert.Equal(t, []string{"cats"}, update.Tags)
+				assert.False(t, update.SetDescription)
+				assert.False(t, update.ReplaceMetadata)
+				assert.Equal(t, map[string]string{"project": "apollo"}, update.Metadata)
+				assert.Equal(t, []string{"draft"}, update.DeleteKeys)
+				return file.FileInfo{File: file.File{ID: fileID, Name: "a.txt", Revision: 3, Tags: []string{"cats"}}, Size: 3}, nil
+			})
+
+		req := httptest.NewRequest(http.MethodPatch, "/files/"+fileID, bytes.NewBufferString(`{"tags":["Cats"],"metadata":{"project":"apollo","draft":null}}`))
+		req.Header.Set("If-Match", `"2"`)
+		rec := serve(h, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+		assert.Contains(t, rec.Body.String(), `"tags":["cats"]`)
+	})
+
+	t.Run("ClearMetadata", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().
+			UpdateMetadata(gomock.Any(), fileID, file.MetadataUpdate{ReplaceMetadata: true}, int64(0)).
+			Return(file.FileInfo{File: file.File{ID: fileID, Revision: 2}}, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodPatch, "/files/"+fileID, bytes.NewBufferString(`{"metadata":null}`)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		_, h := newGateway(t)
+		rec := serve(h, httptest.NewRequest(http.MethodPatch, "/files/"+fileID, bytes.NewBufferString(`{"name":"b.txt"}`)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestSearch(t *testing.T) {
+	repo, h := newGateway(t)
+	repo.EXPECT().Search(gomock.Any(), "cat", 101, 0).Return([]file.SearchResult{{FileInfo: file.FileInfo{File: file.File{ID: fileID, Name: "my cat.png", Revision: 1}, Size: 3}, Rank: 0.7, Highlight: "my <b>cat</b>.png"}}, nil)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/files/search?q=cat", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res struct {
+		Results []struct {
+			File struct {
+				ID   string `json:"id"`
+				Size int64  `json:"size"`
+			} `json:"file"`
+			Rank        float64    `json:"rank"`
+			NameMatches [][2]int32 `json:"name_matches"`
+			Highlight   string     `json:"highlight"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res.Results, 1)
+	assert.Equal(t, fileID, res.Results[0].File.ID)
+	assert.Equal(t, int64(3), res.Results[0].File.Size)
+	assert.Equal(t, 0.7, res.Results[0].Rank)
+	assert.Equal(t, [][2]int32{{3, 6}}, res.Results[0].NameMatches)
+	assert.Equal(t, "my <b>cat</b>.png", res.Results[0].Highlight)
+
+	rec = serve(h, httptest.NewRequest(http.MethodGet, "/files/search", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDelete(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		repo := mock_file.NewMockFileRepository(gomock.NewController(t))
+		passThroughTx(repo)
+		logger := logging.NewTestLogger()
+		srv := file.NewServer(logger, repo)
+		srv.BucketTokens = map[string]string{"secret": "acme"}
+		h := gateway.NewHandler(logger, srv, 1024)
+
+		repo.EXPECT().
+			Delete(gomock.Any(), fileID, int64(3)).
+			DoAndReturn(func(ctx context.Context, id string, revision int64) ([]string, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				assert.Equal(t, []string{"Bearer secret"}, md.Get("authorization"))
+				assert.Equal(t, "acme", postgresql.TenantFromContext(ctx))
+				return []string{id}, nil
+			})
+
+		req := httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil)
+		req.Header.Set("If-Match", `"3"`)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Bucket", "acme")
+		rec := serve(h, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("PreconditionFailed", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().Delete(gomock.Any(), fileID, int64(3)).Return(nil, file.ErrPreconditionFailed)
+
+		req := httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil)
+		req.Header.Set("If-Match", `"3"`)
+		rec := serve(h, req)
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"FailedPrecondition"`)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().Delete(gomock.Any(), fileID, int64(0)).Return(nil, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestFolders(t *testing.T) {
+	const folderID = "00000000-0000-0000-0000-0000000000f1"
+
+	t.Run("Create", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().
+			CreateFolder(gomock.Any(), &file.Folder{Name: "docs"}).
+			DoAndReturn(func(ctx context.Context, folder *file.Folder) error {
+				folder.ID = folderID
+				return nil
+			})
+
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/folders", bytes.NewBufferString(`{"name":"docs"}`)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "/folders/"+folderID, rec.Header().Get("Location"))
+		assert.Contains(t, rec.Body.String(), `"name":"docs"`)
+	})
+
+	t.Run("CreateExists", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().CreateFolder(gomock.Any(), gomock.Any()).Return(file.ErrNameExists)
+
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/folders", bytes.NewBufferString(`{"name":"docs"}`)))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("ListRecursive", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().FindFolder(gomock.Any(), folderID).Return(file.Folder{ID: folderID, Name: "docs"}, nil)
+		repo.EXPECT().
+			ListFolder(gomock.Any(), folderID, true, "", "", 3).
+			Return([]file.FolderEntry{
+				{Path: "a", Folder: &file.Folder{ID: "00000000-0000-0000-0000-0000000000f2", ParentID: folderID, Name: "a"}},
+				{Path: "a/b.txt", File: &file.FileInfo{File: file.File{ID: fileID, Name: "b.txt", Revision: 1}, Size: 3}},
+				{Path: "c.txt", File: &file.FileInfo{File: file.File{ID: "00000000-0000-0000-0000-000000000002", Name: "c.txt", Revision: 1}}},
+			}, nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodGet, "/folders/"+folderID+"?recursive=true&page_size=2", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var res struct {
+			Entries []struct {
+				Path   string `json:"path"`
+				Folder *struct {
+					ParentID string `json:"parent_id"`
+				} `json:"folder"`
+				File *struct {
+					ID string `json:"id"`
+				} `json:"file"`
+			} `json:"entries"`
+			NextPageToken string `json:"next_page_token"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		require.Len(t, res.Entries, 2)
+		require.NotNil(t, res.Entries[0].Folder)
+		assert.Equal(t, folderID, res.Entries[0].Folder.ParentID)
+		require.NotNil(t, res.Entries[1].File)
+		assert.Equal(t, fileID, res.Entries[1].File.ID)
+		assert.NotEmpty(t, res.NextPageToken)
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().
+			MoveFile(gomock.Any(), fileID, folderID, int64(2)).
+			Return(file.FileInfo{File: file.File{ID: fileID, Name: "a.txt", Revision: 3, FolderID: folderID}}, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/files/"+fileID+"/move", bytes.NewBufferString(`{"folder_id":"`+folderID+`"}`))
+		req.Header.Set("If-Match", `"2"`)
+		rec := serve(h, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+		assert.Contains(t, rec.Body.String(), `"folder_id":"`+folderID+`"`)
+	})
+
+	t.Run("DeleteNotEmpty", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().DeleteFolder(gomock.Any(), folderID, false).Return(int64(0), int64(0), file.ErrFolderNotEmpty)
+
+		rec := serve(h, httptest.NewRequest(http.MethodDelete, "/folders/"+folderID, nil))
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	})
+
+	t.Run("DeleteRecursive", func(t *testing.T) {
+		repo, h := newGateway(t)
+		repo.EXPECT().DeleteFolder(gomock.Any(), folderID, true).Return(int64(2), int64(5), nil)
+
+		rec := serve(h, httptest.NewRequest(http.MethodDelete, "/folders/"+folderID+"?recursive=true", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"deleted_folders":2,"deleted_files":5}`, rec.Body.String())
+	})
+}
+
+func TestDownloadByLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+	passThroughTx(repo)
+	logger := logging.NewTestLogger()
+
+	signer, err := file.NewLinkSigner("k1", map[string]string{"k1": "0123456789abcdef0123456789abcdef"})
+	require.NoError(t, err)
+	srv := file.NewServer(logger, repo)
+	srv.LinkSigner = signer
+	h := gateway.NewHandler(logger, srv, 1024)
+
+	repo.EXPECT().FindRevision(gomock.Any(), fileID).Return(int64(1), nil)
+	repo.EXPECT().FindOne(gomock.Any(), fileID).Return(file.File{ID: fileID, Name: "cat.png", Data: []byte("meow"), Revision: 1}, nil)
+
+	link, err := srv.CreateDownloadLink(context.Background(), &pb.CreateDownloadLinkRequest{Id: fileID})
+	require.NoError(t, err)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/links/"+link.Token, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "meow", rec.Body.String())
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+
+	rec = serve(h, httptest.NewRequest(http.MethodGet, "/links/garbage", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+	logger := logging.NewTestLogger()
+
+	srv := file.NewServer(logger, repo)
+	srv.ListRateLimit = file.RateLimit{Requests: ratelimit.NewLimiter(0.5, 1)}
+	h := gateway.NewHandler(logger, srv, 1024)
+
+	repo.EXPECT().FindAll(gomock.Any(), file.FileFilter{}).Return([]file.FileInfo{}, nil)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/files", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(h, httptest.NewRequest(http.MethodGet, "/files", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	// лимит ведется по адресу клиента
+	req := httptest.NewRequest(http.MethodGet, "/files", nil)
+	req.RemoteAddr = "198.51.100.7:4321"
+	repo.EXPECT().FindAll(gomock.Any(), file.FileFilter{}).Return([]file.FileInfo{}, nil)
+	assert.Equal(t, http.StatusOK, serve(h, req).Code)
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, h := newGateway(t)
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body, _ := io.ReadAll(rec.Body)
+	assert.Contains(t, string(body), "openapi: 3.0.3")
+}
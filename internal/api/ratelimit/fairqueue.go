@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority - приоритет запроса в очереди за слотами.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+var priorityNames = [numPriorities]string{"low", "normal", "high"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return "unknown"
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority разбирает имя приоритета: low, normal или high.
+func ParsePriority(s string) (Priority, bool) {
+	for p, name := range priorityNames {
+		if s == name {
+			return Priority(p), true
+		}
+	}
+	return PriorityNormal, false
+}
+
+// FairQueue ограничивает число одновременных запросов и, если задан budget, их суммарный
+// вес (например, размер данных в памяти). Когда ресурсов не хватает, запросы ждут.
+// Освободившиеся ресурсы достаются запросу с наибольшим приоритетом; приоритет ожидающего
+// запроса растет на уровень за каждый интервал aging, поэтому запросы с низким приоритетом
+// не ждут бесконечно. Среди запросов одного приоритета ресурсы достаются клиентам по кругу,
+// а внутри клиента - в порядке прихода, поэтому клиент с длинной очередью не вытесняет
+// остальных. Запрос, которому не хватает веса, не обгоняется следующими, иначе крупные
+// запросы могли бы ждать бесконечно.
+type FairQueue struct {
+	mu        sync.Mutex
+	free      int
+	budget    int64
+	freeBytes int64
+	aging     time.Duration
+	now       func() time.Time
+	levels    [numPriorities]fairLevel
+}
+
+// fairLevel - ожидающие запросы одного приоритета.
+type fairLevel struct {
+	queues map[string]*list.List
+	// клиенты с ожидающими запросами в порядке обхода
+	order []string
+	next  int
+	count int
+}
+
+type waiter struct {
+	key      string
+	priority Priority
+	weight   int64
+	enqueued time.Time
+	ready    chan struct{}
+	granted  bool
+	elem     *list.Element
+}
+
+// NewFairQueue создает очередь на slots запросов; budget 0 - без ограничения веса,
+// aging 0 - приоритет ожидающих запросов не растет.
+func NewFairQueue(slots int, budget int64, aging time.Duration) *FairQueue {
+	q := &FairQueue{free: slots, budget: budget, freeBytes: budget, aging: aging, now: time.Now}
+	for i := range q.levels {
+		q.levels[i].queues = make(map[string]*list.List)
+	}
+	return q
+}
+
+// Budget возвращает ограничение суммарного веса; 0 - вес не учитывается.
+func (q *FairQueue) Budget() int64 {
+	return q.budget
+}
+
+// Acquire ждет слота и weight единиц бюджета. Вес больше бюджета урезается до него:
+// такой запрос выполняется, когда остальные веса освобождены. При отмене ctx
+// возвращает ctx.Err() и ничего не занимает. Возвращенную функцию нужно вызвать
+// по завершении запроса.
+func (q *FairQueue) Acquire(ctx context.Context, key string, priority Priority, weight int64) (release func(), err error) {
+	if q.budget == 0 || weight < 0 {
+		weight = 0
+	}
+	weight = min(weight, q.budget)
+	priority = min(max(priority, PriorityLow), PriorityHigh)
+	release = func() { q.release(weight) }
+
+	q.mu.Lock()
+	if q.waiting() == 0 && q.fits(weight) {
+		q.take(weight)
+		q.mu.Unlock()
+		return release, nil
+	}
+
+	w := &waiter{key: key, priority: priority, weight: weight, enqueued: q.now(), ready: make(chan struct{})}
+	q.levels[priority].push(w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	if w.granted {
+		// ресурсы выданы одновременно с отменой - передаем их дальше
+		q.mu.Unlock()
+		release()
+		return nil, ctx.Err()
+	}
+	q.levels[priority].remove(w)
+	// ушедший запрос мог задерживать следующих
+	q.dispatch()
+	q.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+func (q *FairQueue) release(weight int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.free++
+	q.freeBytes += weight
+	q.dispatch()
+}
+
+// Waiting возвращает число запросов, ожидающих ресурсов.
+func (q *FairQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.waiting()
+}
+
+// WaitingByPriority возвращает число ожидающих запросов по исходному приоритету.
+func (q *FairQueue) WaitingByPriority() map[Priority]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depth := make(map[Priority]int, numPriorities)
+	for p := range q.levels {
+		depth[Priority(p)] = q.levels[p].count
+	}
+	return depth
+}
+
+func (q *FairQueue) waiting() int {
+	n := 0
+	for i := range q.levels {
+		n += q.levels[i].count
+	}
+	return n
+}
+
+func (q *FairQueue) fits(weight int64) bool {
+	return q.free > 0 && q.freeBytes >= weight
+}
+
+func (q *FairQueue) take(weight int64) {
+	q.free--
+	q.freeBytes -= weight
+}
+
+// dispatch выдает ресурсы ожидающим, пока следующему по очереди их хватает.
+func (q *FairQueue) dispatch() {
+	for {
+		w := q.head()
+		if w == nil || !q.fits(w.weight) {
+			return
+		}
+
+		q.take(w.weight)
+		q.levels[w.priority].advance(w)
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// head выбирает следующий запрос: с наибольшим приоритетом с учетом ожидания,
+// при равенстве - с большим исходным приоритетом.
+func (q *FairQueue) head() *waiter {
+	now := q.now()
+	var best *waiter
+	bestPriority := Priority(-1)
+	for p := numPriorities - 1; p >= 0; p-- {
+		w := q.levels[p].head()
+		if w == nil {
+			continue
+		}
+		if effective := q.effective(w, now); effective > bestPriority {
+			best, bestPriority = w, effective
+		}
+	}
+	return best
+}
+
+func (q *FairQueue) effective(w *waiter, now time.Time) Priority {
+	if q.aging <= 0 {
+		return w.priority
+	}
+	return w.priority + Priority(now.Sub(w.enqueued)/q.aging)
+}
+
+func (l *fairLevel) push(w *waiter) {
+	queue, ok := l.queues[w.key]
+	if !ok {
+		queue = list.New()
+		l.queues[w.key] = queue
+		l.order = append(l.order, w.key)
+	}
+	w.elem = queue.PushBack(w)
+	l.count++
+}
+
+func (l *fairLevel) head() *waiter {
+	if len(l.order) == 0 {
+		return nil
+	}
+	if l.next >= len(l.order) {
+		l.next = 0
+	}
+	return l.queues[l.order[l.next]].Front().Value.(*waiter)
+}
+
+// advance удаляет выданный запрос head и переходит к следующему клиенту.
+func (l *fairLevel) advance(w *waiter) {
+	l.next++
+	l.remove(w)
+}
+
+func (l *fairLevel) remove(w *waiter) {
+	l.count--
+	queue := l.queues[w.key]
+	queue.Remove(w.elem)
+	if queue.Len() > 0 {
+		return
+	}
+
+	delete(l.queues, w.key)
+	for i, key := range l.order {
+		if key == w.key {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			if i < l.next {
+				l.next--
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package ratelimit - ограничение частоты и справедливая очередь запросов по ключу клиента.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Простаивающие полные ведра неотличимы от новых и периодически удаляются.
+const sweepInterval = time.Minute
+
+// Limiter - token bucket для каждого ключа: ведро вмещает burst токенов
+// и пополняется со скоростью perSecond. Nil-лимитер пропускает все запросы.
+type Limiter struct {
+	perSecond float64
+	burst     float64
+	now       func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter возвращает nil, если perSecond не положителен, то есть ограничения нет.
+// Нулевой burst принимается равным perSecond.
+func NewLimiter(perSecond, burst float64) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perSecond
+	}
+	return &Limiter{perSecond: perSecond, burst: burst, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Take забирает n токенов. Если их не хватает, ничего не забирает и возвращает,
+// через сколько можно повторить запрос. Запрос больше burst пропускается при полном
+// ведре и уводит его в минус, чтобы крупные запросы не блокировались навсегда.
+func (l *Limiter) Take(key string, n float64) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key)
+	need := math.Min(n, l.burst)
+	if b.tokens >= need {
+		b.tokens -= n
+		return 0, true
+	}
+
+	wait := time.Duration((need - b.tokens) / l.perSecond * float64(time.Second))
+	return wait, false
+}
+
+// Charge списывает n токенов без проверки, например за уже отданные байты;
+// следующие запросы клиента будут ждать, пока ведро не пополнится.
+func (l *Limiter) Charge(key string, n float64) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(key).tokens -= n
+}
+
+func (l *Limiter) refill(key string) *bucket {
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.perSecond)
+	b.updated = now
+	return b
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
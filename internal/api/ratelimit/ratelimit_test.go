@@ -0,0 +1,250 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(perSecond, burst float64) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	l := NewLimiter(perSecond, burst)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("Burst", func(t *testing.T) {
+		l, clock := newTestLimiter(2, 3)
+
+		for i := 0; i < 3; i++ {
+			_, ok := l.Take("a", 1)
+			assert.True(t, ok)
+		}
+		wait, ok := l.Take("a", 1)
+		assert.False(t, ok)
+		assert.Equal(t, 500*time.Millisecond, wait)
+
+		// другие клиенты не затронуты
+		_, ok = l.Take("b", 1)
+		assert.True(t, ok)
+
+		clock.advance(500 * time.Millisecond)
+		_, ok = l.Take("a", 1)
+		assert.True(t, ok)
+	})
+
+	t.Run("LargerThanBurst", func(t *testing.T) {
+		l, clock := newTestLimiter(10, 10)
+
+		_, ok := l.Take("a", 25)
+		assert.True(t, ok)
+
+		// долг 15 токенов и 1 на следующий запрос
+		wait, ok := l.Take("a", 1)
+		assert.False(t, ok)
+		assert.Equal(t, 1600*time.Millisecond, wait)
+
+		clock.advance(wait)
+		_, ok = l.Take("a", 1)
+		assert.True(t, ok)
+	})
+
+	t.Run("Charge", func(t *testing.T) {
+		l, _ := newTestLimiter(100, 100)
+
+		l.Charge("a", 150)
+		wait, ok := l.Take("a", 10)
+		assert.False(t, ok)
+		assert.Equal(t, 600*time.Millisecond, wait)
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		l, clock := newTestLimiter(1, 1)
+
+		l.Take("a", 1)
+		clock.advance(2 * sweepInterval)
+		l.Take("b", 1)
+		assert.NotContains(t, l.buckets, "a")
+		assert.Contains(t, l.buckets, "b")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		l := NewLimiter(0, 10)
+		assert.Nil(t, l)
+
+		_, ok := l.Take("a", 1e9)
+		assert.True(t, ok)
+		l.Charge("a", 1e9)
+	})
+}
+
+type grant struct {
+	key     string
+	release func()
+}
+
+// enqueue запрашивает ресурсы в отдельной горутине и дожидается, пока запрос
+// встанет в очередь, чтобы порядок прихода был определенным.
+func enqueue(t *testing.T, ctx context.Context, q *FairQueue, key string, priority Priority, weight int64, granted chan<- grant) {
+	waiting := q.Waiting()
+	go func() {
+		release, err := q.Acquire(ctx, key, priority, weight)
+		if err == nil {
+			granted <- grant{key, release}
+		}
+	}()
+	assert.Eventually(t, func() bool { return q.Waiting() == waiting+1 }, time.Second, time.Millisecond)
+}
+
+func assertWaiting(t *testing.T, granted <-chan grant) {
+	select {
+	case g := <-granted:
+		t.Fatalf("request of %s should wait", g.key)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		q := NewFairQueue(1, 0, 0)
+		release, err := q.Acquire(ctx, "holder", PriorityNormal, 0)
+		assert.NoError(t, err)
+
+		// "a" ставит в очередь три запроса раньше, чем "b" и "c" по одному
+		keys := []string{"a", "a", "a", "b", "c"}
+		granted := make(chan grant, len(keys))
+		for _, key := range keys {
+			enqueue(t, ctx, q, key, PriorityNormal, 0, granted)
+		}
+
+		var got []string
+		for range keys {
+			release()
+			g := <-granted
+			got = append(got, g.key)
+			release = g.release
+		}
+		assert.Equal(t, []string{"a", "b", "c", "a", "a"}, got)
+		assert.Equal(t, 0, q.Waiting())
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		q := NewFairQueue(10, 100, 0)
+		release, err := q.Acquire(ctx, "a", PriorityNormal, 60)
+		assert.NoError(t, err)
+
+		granted := make(chan grant, 2)
+		enqueue(t, ctx, q, "b", PriorityNormal, 60, granted)
+		// легкий запрос не обгоняет ожидающий тяжелый
+		enqueue(t, ctx, q, "c", PriorityNormal, 10, granted)
+		assertWaiting(t, granted)
+
+		release()
+		got := map[string]bool{(<-granted).key: true, (<-granted).key: true}
+		assert.Equal(t, map[string]bool{"b": true, "c": true}, got)
+	})
+
+	t.Run("LargerThanBudget", func(t *testing.T) {
+		q := NewFairQueue(10, 100, 0)
+		release, err := q.Acquire(ctx, "a", PriorityNormal, 500)
+		assert.NoError(t, err)
+
+		granted := make(chan grant, 1)
+		enqueue(t, ctx, q, "b", PriorityNormal, 1, granted)
+		assertWaiting(t, granted)
+
+		release()
+		assert.Equal(t, "b", (<-granted).key)
+	})
+
+	t.Run("RequestCount", func(t *testing.T) {
+		q := NewFairQueue(2, 1000, 0)
+		for i := 0; i < 2; i++ {
+			_, err := q.Acquire(ctx, "a", PriorityNormal, 1)
+			assert.NoError(t, err)
+		}
+
+		granted := make(chan grant, 1)
+		enqueue(t, ctx, q, "b", PriorityNormal, 1, granted)
+		assertWaiting(t, granted)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		q := NewFairQueue(10, 100, 0)
+		release, err := q.Acquire(ctx, "a", PriorityNormal, 60)
+		assert.NoError(t, err)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		granted := make(chan grant, 2)
+		enqueue(t, timeoutCtx, q, "b", PriorityNormal, 60, granted)
+		enqueue(t, ctx, q, "c", PriorityNormal, 10, granted)
+
+		// после отмены "b" ничто не задерживает "c"
+		assert.Equal(t, "c", (<-granted).key)
+		assert.Equal(t, 0, q.Waiting())
+
+		// ресурсы не потеряны
+		release()
+		_, err = q.Acquire(ctx, "d", PriorityNormal, 90)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		q := NewFairQueue(1, 0, 0)
+		release, err := q.Acquire(ctx, "holder", PriorityNormal, 0)
+		assert.NoError(t, err)
+
+		granted := make(chan grant, 3)
+		enqueue(t, ctx, q, "bulk", PriorityLow, 0, granted)
+		enqueue(t, ctx, q, "user", PriorityNormal, 0, granted)
+		enqueue(t, ctx, q, "admin", PriorityHigh, 0, granted)
+		assert.Equal(t, map[Priority]int{PriorityLow: 1, PriorityNormal: 1, PriorityHigh: 1}, q.WaitingByPriority())
+
+		var got []string
+		for i := 0; i < 3; i++ {
+			release()
+			g := <-granted
+			got = append(got, g.key)
+			release = g.release
+		}
+		assert.Equal(t, []string{"admin", "user", "bulk"}, got)
+	})
+
+	t.Run("Aging", func(t *testing.T) {
+		clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+		q := NewFairQueue(1, 0, time.Second)
+		q.now = clock.now
+		release, err := q.Acquire(ctx, "holder", PriorityNormal, 0)
+		assert.NoError(t, err)
+
+		granted := make(chan grant, 3)
+		enqueue(t, ctx, q, "bulk", PriorityLow, 0, granted)
+		clock.advance(time.Second)
+		// за секунду низкий приоритет дорос до обычного; при равенстве выигрывает исходно более высокий
+		enqueue(t, ctx, q, "user", PriorityNormal, 0, granted)
+		release()
+		g := <-granted
+		assert.Equal(t, "user", g.key)
+
+		clock.advance(time.Second)
+		// "bulk" ждет две секунды и обгоняет только что пришедший запрос с обычным приоритетом
+		enqueue(t, ctx, q, "user", PriorityNormal, 0, granted)
+		g.release()
+		g = <-granted
+		assert.Equal(t, "bulk", g.key)
+
+		g.release()
+		assert.Equal(t, "user", (<-granted).key)
+	})
+}
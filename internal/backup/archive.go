@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	formatVersion = 1
+
+	manifestName = "manifest.json"
+	filesDir     = "files/"
+)
+
+var ErrIntegrity = errors.New("backup integrity check failed")
+
+type Manifest struct {
+	FormatVersion int        `json:"format_version"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Since         *time.Time `json:"since,omitempty"`
+	// все бакеты и папки, в том числе в инкрементальном архиве; родители идут раньше вложенных
+	Buckets []ManifestBucket `json:"buckets,omitempty"`
+	Folders []ManifestFolder `json:"folders,omitempty"`
+	Files   []ManifestFile   `json:"files"`
+}
+
+// ManifestBucket - настройки бакета вместе с его квотой.
+type ManifestBucket struct {
+	Name             string    `json:"name"`
+	MaxBytes         int64     `json:"max_bytes,omitempty"`
+	MaxFiles         int64     `json:"max_files,omitempty"`
+	MaxFileSize      int64     `json:"max_file_size,omitempty"`
+	KeepVersions     int32     `json:"keep_versions,omitempty"`
+	KeepVersionsDays int32     `json:"keep_versions_days,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Bucket папок и файлов пуст в архивах до появления бакетов и означает бакет default.
+
+type ManifestFolder struct {
+	Bucket    string    `json:"bucket,omitempty"`
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ManifestFile struct {
+	Bucket    string    `json:"bucket,omitempty"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	Version   int32     `json:"version"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	FolderID    string            `json:"folder_id,omitempty"`
+}
+
+// Архив - TAR, где первым идет manifest.json, а за ним содержимое файлов в files/<id>
+// в том же порядке, что и в манифесте. Манифест в начале позволяет проверять каждый
+// файл при восстановлении, не дочитывая архив до конца.
+type archiveWriter struct {
+	tw *tar.Writer
+}
+
+func newArchiveWriter(w io.Writer, manifest Manifest) (*archiveWriter, error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	if err = writeEntry(tw, manifestName, manifest.CreatedAt, data); err != nil {
+		return nil, err
+	}
+
+	return &archiveWriter{tw: tw}, nil
+}
+
+func (a *archiveWriter) WriteFile(f ManifestFile, data []byte) error {
+	return writeEntry(a.tw, filesDir+f.ID, f.UpdatedAt, data)
+}
+
+func (a *archiveWriter) Close() error {
+	return a.tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// readArchive читает манифест и передает в fn содержимое каждого файла после проверки
+// размера и контрольной суммы. Если в архиве не хватает файлов из манифеста или есть
+// лишние, возвращается ErrIntegrity.
+func readArchive(r io.Reader, fn func(Manifest, ManifestFile, []byte) error) (Manifest, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%w: read manifest: %v", ErrIntegrity, err)
+	}
+	if hdr.Name != manifestName {
+		return Manifest{}, fmt.Errorf("%w: first entry is %s, expected %s", ErrIntegrity, hdr.Name, manifestName)
+	}
+
+	var manifest Manifest
+	if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("%w: decode manifest: %v", ErrIntegrity, err)
+	}
+	if manifest.FormatVersion != formatVersion {
+		return Manifest{}, fmt.Errorf("unsupported backup format version %d", manifest.FormatVersion)
+	}
+
+	files := make(map[string]ManifestFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files[f.ID] = f
+	}
+
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("%w: %v", ErrIntegrity, err)
+		}
+
+		id := strings.TrimPrefix(hdr.Name, filesDir)
+		f, ok := files[id]
+		if !ok || id == hdr.Name {
+			return manifest, fmt.Errorf("%w: unexpected entry %s", ErrIntegrity, hdr.Name)
+		}
+		if hdr.Size != f.Size {
+			return manifest, fmt.Errorf("%w: file %s has size %d, manifest says %d", ErrIntegrity, id, hdr.Size, f.Size)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return manifest, fmt.Errorf("%w: read %s: %v", ErrIntegrity, hdr.Name, err)
+		}
+
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.SHA256 {
+			return manifest, fmt.Errorf("%w: checksum mismatch for file %s", ErrIntegrity, id)
+		}
+
+		if err = fn(manifest, f, data); err != nil {
+			return manifest, err
+		}
+		delete(files, id)
+	}
+
+	if len(files) > 0 {
+		return manifest, fmt.Errorf("%w: %d files from manifest are missing", ErrIntegrity, len(files))
+	}
+
+	return manifest, nil
+}
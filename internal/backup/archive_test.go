@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func manifestFile(id string, data []byte) ManifestFile {
+	sum := sha256.Sum256(data)
+	return ManifestFile{ID: id, Name: id + ".jpg", Version: 1, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:]), UpdatedAt: time.Now().UTC()}
+}
+
+func writeTestArchive(t *testing.T, manifest Manifest, data map[string][]byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, manifest)
+	assert.NoError(t, err)
+	for _, f := range manifest.Files {
+		if d, ok := data[f.ID]; ok {
+			assert.NoError(t, aw.WriteFile(f, d))
+		}
+	}
+	assert.NoError(t, aw.Close())
+	return &buf
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	data := map[string][]byte{"a": []byte("first"), "b": []byte("second")}
+	manifest := Manifest{FormatVersion: formatVersion, CreatedAt: time.Now().UTC(), Files: []ManifestFile{manifestFile("a", data["a"]), manifestFile("b", data["b"])}}
+	manifest.Buckets = []ManifestBucket{{Name: "default", CreatedAt: manifest.CreatedAt}, {Name: "acme", MaxFileSize: 1 << 20, KeepVersions: 3, CreatedAt: manifest.CreatedAt}}
+	manifest.Folders = []ManifestFolder{{Bucket: "acme", ID: "docs", Name: "docs", CreatedAt: manifest.CreatedAt}}
+	manifest.Files[1].FolderID = "docs"
+	manifest.Files[1].Bucket = "acme"
+
+	restored := map[string][]byte{}
+	got, err := readArchive(writeTestArchive(t, manifest, data), func(m Manifest, f ManifestFile, d []byte) error {
+		restored[f.ID] = d
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, got.Files, 2)
+	assert.Equal(t, manifest.Buckets, got.Buckets)
+	assert.Equal(t, manifest.Folders, got.Folders)
+	assert.Equal(t, "docs", got.Files[1].FolderID)
+	assert.Equal(t, "acme", got.Files[1].Bucket)
+	assert.Equal(t, data, restored)
+}
+
+func TestArchiveIntegrity(t *testing.T) {
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		f := manifestFile("a", []byte("first"))
+		manifest := Manifest{FormatVersion: formatVersion, Files: []ManifestFile{f}}
+
+		_, err := readArchive(writeTestArchive(t, manifest, map[string][]byte{"a": []byte("FIRST")}), func(Manifest, ManifestFile, []byte) error {
+			t.Fatal("tampered file must not be restored")
+			return nil
+		})
+
+		assert.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		manifest := Manifest{FormatVersion: formatVersion, Files: []ManifestFile{manifestFile("a", []byte("first")), manifestFile("b", []byte("second"))}}
+
+		_, err := readArchive(writeTestArchive(t, manifest, map[string][]byte{"a": []byte("first")}), func(Manifest, ManifestFile, []byte) error {
+			return nil
+		})
+
+		assert.True(t, errors.Is(err, ErrIntegrity))
+	})
+}
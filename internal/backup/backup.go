@@ -0,0 +1,310 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"app/pkg/client/postgresql"
+	"app/pkg/logging"
+)
+
+// Export пишет в w архив всех файлов, измененных после since (нулевое значение - все файлы).
+// Удаления в инкрементальный архив не попадают.
+func Export(ctx context.Context, logger *logging.Logger, client postgresql.Client, w io.Writer, since time.Time) (Manifest, error) {
+	tx, err := client.Begin(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Манифест и содержимое читаются из одного снимка базы.
+	if _, err = tx.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`); err != nil {
+		return Manifest{}, err
+	}
+
+	// Время снимка берется из базы, чтобы следующий инкрементальный экспорт
+	// сравнивал update_time с часами того же сервера.
+	manifest := Manifest{FormatVersion: formatVersion, Files: []ManifestFile{}}
+	if err = tx.QueryRow(ctx, `SELECT localtimestamp`).Scan(&manifest.CreatedAt); err != nil {
+		return Manifest{}, err
+	}
+
+	var sinceArg interface{}
+	if !since.IsZero() {
+		since = since.UTC()
+		manifest.Since = &since
+		sinceArg = since
+	}
+
+	// Бакеты и папки выгружаются всегда целиком, их немного, а без них нельзя
+	// восстановить файлы в них.
+	q := `
+	SELECT
+		b.name,
+		COALESCE(q.max_bytes, 0),
+		COALESCE(q.max_files, 0),
+		b.max_file_size,
+		b.keep_versions,
+		b.keep_versions_days,
+		b.create_time
+	FROM buckets b
+	LEFT JOIN quotas q ON q.bucket = b.name AND q.owner = '*'
+	ORDER BY b.name;
+	`
+
+	rows, err := tx.Query(ctx, q)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for rows.Next() {
+		var b ManifestBucket
+		if err = rows.Scan(&b.Name, &b.MaxBytes, &b.MaxFiles, &b.MaxFileSize, &b.KeepVersions, &b.KeepVersionsDays, &b.CreatedAt); err != nil {
+			rows.Close()
+			return Manifest{}, err
+		}
+		manifest.Buckets = append(manifest.Buckets, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	q = `
+	WITH RECURSIVE tree AS (
+		SELECT id, 0 AS depth FROM folders WHERE parent_id IS NULL
+		UNION ALL
+		SELECT f.id, t.depth + 1 FROM folders f JOIN tree t ON f.parent_id = t.id
+	)
+	SELECT f.bucket, f.id, COALESCE(f.parent_id::text, ''), f.name, f.create_time
+	FROM folders f JOIN tree t ON f.id = t.id
+	ORDER BY t.depth, f.id;
+	`
+
+	rows, err = tx.Query(ctx, q)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for rows.Next() {
+		var f ManifestFolder
+		if err = rows.Scan(&f.Bucket, &f.ID, &f.ParentID, &f.Name, &f.CreatedAt); err != nil {
+			rows.Close()
+			return Manifest{}, err
+		}
+		manifest.Folders = append(manifest.Folders, f)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	q = `
+	SELECT 
+		bucket,
+		id,
+		name,
+		owner,
+		current_version,
+		octet_length(data),
+		encode(sha256(data), 'hex'),
+		create_time,
+		update_time,
+		tags,
+		description,
+		metadata,
+		COALESCE(folder_id::text, '')
+	FROM 
+		files
+	WHERE $1::timestamp IS NULL OR update_time > $1
+	ORDER BY id;
+	`
+
+	rows, err = tx.Query(ctx, q, sinceArg)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for rows.Next() {
+		var f ManifestFile
+		if err = rows.Scan(&f.Bucket, &f.ID, &f.Name, &f.Owner, &f.Version, &f.Size, &f.SHA256, &f.CreatedAt, &f.UpdatedAt, &f.Tags, &f.Description, &f.Metadata, &f.FolderID); err != nil {
+			rows.Close()
+			return Manifest{}, err
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	aw, err := newArchiveWriter(w, manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	q = `
+	SELECT data FROM files WHERE $1::timestamp IS NULL OR update_time > $1 ORDER BY id;
+	`
+
+	rows, err = tx.Query(ctx, q, sinceArg)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer rows.Close()
+
+	i := 0
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return Manifest{}, err
+		}
+		if err = aw.WriteFile(manifest.Files[i], data); err != nil {
+			return Manifest{}, err
+		}
+		i++
+		logger.Debug(fmt.Sprintf("Exported file %d of %d", i, len(manifest.Files)))
+	}
+	if err = rows.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	if err = aw.Close(); err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, tx.Commit(ctx)
+}
+
+// Restore восстанавливает файлы из архива с исходными ID и временем создания и изменения.
+// Все изменения выполняются в одной транзакции: при любой ошибке проверки целостности
+// база остается без изменений. Существующие файлы с теми же ID перезаписываются,
+// поэтому инкрементальные архивы применяются по порядку поверх полного.
+func Restore(ctx context.Context, logger *logging.Logger, client postgresql.Client, r io.Reader) (Manifest, error) {
+	tx, err := client.Begin(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	bucketQ := `
+	INSERT INTO buckets
+		(name, max_file_size, keep_versions, keep_versions_days, create_time)
+	VALUES
+		($1, $2, $3, $4, $5)
+	ON CONFLICT (name) DO UPDATE SET
+		max_file_size = EXCLUDED.max_file_size,
+		keep_versions = EXCLUDED.keep_versions,
+		keep_versions_days = EXCLUDED.keep_versions_days,
+		create_time = EXCLUDED.create_time;
+	`
+
+	bucketQuotaQ := `
+	INSERT INTO quotas
+		(bucket, owner, max_bytes, max_files)
+	VALUES
+		($1, '*', $2, $3)
+	ON CONFLICT (bucket, owner) DO UPDATE SET
+		max_bytes = EXCLUDED.max_bytes,
+		max_files = EXCLUDED.max_files;
+	`
+
+	// бакет существующих папок и файлов не меняется: счетчики квот ведутся в пределах бакета
+	folderQ := `
+	INSERT INTO folders 
+		(id, parent_id, name, create_time, bucket)
+	VALUES 
+		($1, NULLIF($2, '')::uuid, $3, $4, COALESCE(NULLIF($5, ''), 'default'))
+	ON CONFLICT (id) DO UPDATE SET
+		parent_id = EXCLUDED.parent_id,
+		name = EXCLUDED.name,
+		create_time = EXCLUDED.create_time;
+	`
+
+	fileQ := `
+	INSERT INTO files 
+		(id, name, data, current_version, create_time, update_time, owner, tags, description, metadata, folder_id, bucket)
+	VALUES 
+		($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'default'), COALESCE($8::text[], '{}'), $9, $10::jsonb, NULLIF($11, '')::uuid, COALESCE(NULLIF($12, ''), 'default'))
+	ON CONFLICT (id) DO UPDATE SET
+		name = EXCLUDED.name,
+		folder_id = EXCLUDED.folder_id,
+		data = EXCLUDED.data,
+		owner = EXCLUDED.owner,
+		tags = EXCLUDED.tags,
+		description = EXCLUDED.description,
+		metadata = EXCLUDED.metadata,
+		current_version = EXCLUDED.current_version,
+		revision = files.revision + 1,
+		create_time = EXCLUDED.create_time,
+		update_time = EXCLUDED.update_time;
+	`
+
+	versionQ := `
+	INSERT INTO file_versions 
+		(file_id, version, name, data, create_time)
+	VALUES 
+		($1, $2, $3, $4, $5)
+	ON CONFLICT (file_id, version) DO UPDATE SET
+		name = EXCLUDED.name,
+		data = EXCLUDED.data,
+		create_time = EXCLUDED.create_time;
+	`
+
+	// бакеты и папки нужны раньше файлов, а манифест становится известен только при чтении архива
+	foldersRestored := false
+	restoreFolders := func(m Manifest) error {
+		foldersRestored = true
+		for _, b := range m.Buckets {
+			if _, err := tx.Exec(ctx, bucketQ, b.Name, b.MaxFileSize, b.KeepVersions, b.KeepVersionsDays, b.CreatedAt); err != nil {
+				return fmt.Errorf("restore bucket %s: %w", b.Name, err)
+			}
+			if _, err := tx.Exec(ctx, bucketQuotaQ, b.Name, b.MaxBytes, b.MaxFiles); err != nil {
+				return fmt.Errorf("restore bucket %s: %w", b.Name, err)
+			}
+		}
+		for _, f := range m.Folders {
+			if _, err := tx.Exec(ctx, folderQ, f.ID, f.ParentID, f.Name, f.CreatedAt, f.Bucket); err != nil {
+				return fmt.Errorf("restore folder %s: %w", f.ID, err)
+			}
+		}
+		return nil
+	}
+
+	restored := 0
+	manifest, err := readArchive(r, func(m Manifest, f ManifestFile, data []byte) error {
+		if !foldersRestored {
+			if err := restoreFolders(m); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, fileQ, f.ID, f.Name, data, f.Version, f.CreatedAt, f.UpdatedAt, f.Owner, f.Tags, f.Description, metadataJSON(f.Metadata), f.FolderID, f.Bucket); err != nil {
+			return fmt.Errorf("restore file %s: %w", f.ID, err)
+		}
+		if _, err := tx.Exec(ctx, versionQ, f.ID, f.Version, f.Name, data, f.UpdatedAt); err != nil {
+			return fmt.Errorf("restore file %s: %w", f.ID, err)
+		}
+		restored++
+		logger.Debug(fmt.Sprintf("Restored file %d of %d: %s", restored, len(m.Files), f.ID))
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	if !foldersRestored {
+		if err = restoreFolders(manifest); err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, tx.Commit(ctx)
+}
+
+// metadataJSON кодирует метаданные для столбца jsonb; nil - пустой объект, а не null.
+func metadataJSON(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
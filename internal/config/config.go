@@ -3,6 +3,10 @@ package config
 import (
 	"log"
 	"sync"
+	"time"
+
+	"app/pkg/client/postgresql"
+	"app/pkg/retry"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
@@ -16,15 +20,206 @@ type Config struct {
 			Host string `yaml:"host" env:"LISTEN_GRPC_HOST" env-default:"localhost"`
 			Port string `yaml:"port" env:"LISTEN_GRPC_PORT" env-default:"50051"`
 		} `yaml:"grpc"`
+		HTTP struct {
+			Host          string `yaml:"host" env:"LISTEN_HTTP_HOST" env-default:"localhost"`
+			Port          string `yaml:"port" env:"LISTEN_HTTP_PORT" env-default:"8080"`
+			MaxUploadSize int64  `yaml:"max_upload_size" env:"LISTEN_HTTP_MAX_UPLOAD_SIZE" env-default:"4194304"`
+		} `yaml:"http"`
+		// Отдельный адрес для метрик /debug/vars, по умолчанию только локальный; пустой порт отключает его
+		Debug struct {
+			Host string `yaml:"host" env:"LISTEN_DEBUG_HOST" env-default:"127.0.0.1"`
+			Port string `yaml:"port" env:"LISTEN_DEBUG_PORT" env-default:"6060"`
+		} `yaml:"debug"`
 	} `yaml:"listen"`
 
+	// Нулевые параметры пула оставляют значения pgxpool по умолчанию,
+	// нулевой statement_timeout не ограничивает время запроса
 	Postgres struct {
 		Host     string `yaml:"host" env:"POSTGRES_HOST" env-default:"localhost"`
 		Port     string `yaml:"port" env:"POSTGRES_PORT" env-default:"5432"`
 		Database string `yaml:"database" env:"POSTGRES_DATABASE"`
 		Username string `yaml:"username" env:"POSTGRES_USERNAME"`
 		Password string `yaml:"password" env:"POSTGRES_PASSWORD"`
+
+		SSLMode     string `yaml:"sslmode" env:"POSTGRES_SSLMODE" env-default:"prefer"`
+		SSLRootCert string `yaml:"sslrootcert" env:"POSTGRES_SSLROOTCERT"`
+		SSLCert     string `yaml:"sslcert" env:"POSTGRES_SSLCERT"`
+		SSLKey      string `yaml:"sslkey" env:"POSTGRES_SSLKEY"`
+
+		ApplicationName  string        `yaml:"application_name" env:"POSTGRES_APPLICATION_NAME" env-default:"file-service"`
+		ConnectTimeout   time.Duration `yaml:"connect_timeout" env:"POSTGRES_CONNECT_TIMEOUT" env-default:"5s"`
+		StatementTimeout time.Duration `yaml:"statement_timeout" env:"POSTGRES_STATEMENT_TIMEOUT"`
+
+		MinConns        int32         `yaml:"min_conns" env:"POSTGRES_MIN_CONNS"`
+		MaxConns        int32         `yaml:"max_conns" env:"POSTGRES_MAX_CONNS"`
+		MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env:"POSTGRES_MAX_CONN_LIFETIME" env-default:"1h"`
+		MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env:"POSTGRES_MAX_CONN_IDLE_TIME" env-default:"30m"`
+
+		// повторы подключения при старте: экспоненциальная задержка от retry_delay до max_retry_delay
+		ConnectAttempts   int           `yaml:"connect_attempts" env:"POSTGRES_CONNECT_ATTEMPTS" env-default:"5"`
+		ConnectMaxElapsed time.Duration `yaml:"connect_max_elapsed" env:"POSTGRES_CONNECT_MAX_ELAPSED" env-default:"1m"`
+		RetryDelay        time.Duration `yaml:"retry_delay" env:"POSTGRES_RETRY_DELAY" env-default:"1s"`
+		MaxRetryDelay     time.Duration `yaml:"max_retry_delay" env:"POSTGRES_MAX_RETRY_DELAY" env-default:"10s"`
+
+		// повторы запросов при временных ошибках (ошибки сериализации, разрывы соединения)
+		QueryAttempts      int           `yaml:"query_attempts" env:"POSTGRES_QUERY_ATTEMPTS" env-default:"3"`
+		QueryRetryDelay    time.Duration `yaml:"query_retry_delay" env:"POSTGRES_QUERY_RETRY_DELAY" env-default:"50ms"`
+		QueryMaxRetryDelay time.Duration `yaml:"query_max_retry_delay" env:"POSTGRES_QUERY_MAX_RETRY_DELAY" env-default:"1s"`
+
+		// реплики для чтения ("host" или "host:port") с учетными данными основного сервера;
+		// реплика, отстающая больше max_replication_lag, пропускается (0 - без ограничения)
+		Replicas             []string      `yaml:"replicas" env:"POSTGRES_REPLICAS" env-separator:","`
+		MaxReplicationLag    time.Duration `yaml:"max_replication_lag" env:"POSTGRES_MAX_REPLICATION_LAG" env-default:"10s"`
+		ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" env:"POSTGRES_REPLICA_CHECK_INTERVAL" env-default:"5s"`
+
+		// роль, под которой выполняются запросы бакетов (миграция 11_buckets); пустая
+		// отключает RLS, и бакеты перестают быть изолированы друг от друга
+		TenantRole string `yaml:"tenant_role" env:"POSTGRES_TENANT_ROLE" env-default:"file_service_tenant"`
 	} `yaml:"postgres"`
+
+	Versioning struct {
+		KeepLast      int           `yaml:"keep_last" env:"VERSIONING_KEEP_LAST" env-default:"10"`
+		KeepDays      int           `yaml:"keep_days" env:"VERSIONING_KEEP_DAYS" env-default:"30"`
+		PruneInterval time.Duration `yaml:"prune_interval" env:"VERSIONING_PRUNE_INTERVAL" env-default:"1h"`
+	} `yaml:"versioning"`
+
+	Idempotency struct {
+		TTL             time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" env-default:"24h"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval" env:"IDEMPOTENCY_CLEANUP_INTERVAL" env-default:"1h"`
+	} `yaml:"idempotency"`
+
+	// Файл сессии собирается в одно значение bytea в памяти сервиса и базы, поэтому max_size
+	// должен быть заметно меньше предела поля PostgreSQL (1 ГБ)
+	UploadSessions struct {
+		TTL             time.Duration `yaml:"ttl" env:"UPLOAD_SESSIONS_TTL" env-default:"24h"`
+		MaxSize         int64         `yaml:"max_size" env:"UPLOAD_SESSIONS_MAX_SIZE" env-default:"268435456"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval" env:"UPLOAD_SESSIONS_CLEANUP_INTERVAL" env-default:"10m"`
+	} `yaml:"upload_sessions"`
+
+	Batch struct {
+		MaxSize int `yaml:"max_size" env:"BATCH_MAX_SIZE" env-default:"100"`
+		// наибольший суммарный размер файлов в DownloadArchive
+		MaxArchiveSize int64 `yaml:"max_archive_size" env:"BATCH_MAX_ARCHIVE_SIZE" env-default:"1073741824"`
+	} `yaml:"batch"`
+
+	// Журнал изменений для WatchFiles: события старше retention удаляются, и курсоры
+	// до них перестают действовать. Наблюдатели опрашивают журнал раз в poll_interval
+	// на случай потерянных уведомлений и раз в heartbeat_interval (меньше retention)
+	// получают свежий курсор. События видны после завершения всех более ранних транзакций
+	// кластера PostgreSQL: долгая транзакция любой сессии задерживает их для всех наблюдателей
+	FileEvents struct {
+		Retention         time.Duration `yaml:"retention" env:"FILE_EVENTS_RETENTION" env-default:"24h"`
+		CleanupInterval   time.Duration `yaml:"cleanup_interval" env:"FILE_EVENTS_CLEANUP_INTERVAL" env-default:"1h"`
+		PollInterval      time.Duration `yaml:"poll_interval" env:"FILE_EVENTS_POLL_INTERVAL" env-default:"5s"`
+		HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"FILE_EVENTS_HEARTBEAT_INTERVAL" env-default:"1m"`
+	} `yaml:"file_events"`
+
+	// Ссылки подписываются ключом active_key, проверяются любым из keys.
+	// Пустой active_key отключает ссылки на скачивание.
+	DownloadLinks struct {
+		BaseURL         string            `yaml:"base_url" env:"DOWNLOAD_LINKS_BASE_URL" env-default:"http://localhost:8080"`
+		ActiveKey       string            `yaml:"active_key" env:"DOWNLOAD_LINKS_ACTIVE_KEY"`
+		Keys            map[string]string `yaml:"keys" env:"DOWNLOAD_LINKS_KEYS"`
+		DefaultTTL      time.Duration     `yaml:"default_ttl" env:"DOWNLOAD_LINKS_DEFAULT_TTL" env-default:"1h"`
+		MaxTTL          time.Duration     `yaml:"max_ttl" env:"DOWNLOAD_LINKS_MAX_TTL" env-default:"168h"`
+		CleanupInterval time.Duration     `yaml:"cleanup_interval" env:"DOWNLOAD_LINKS_CLEANUP_INTERVAL" env-default:"1h"`
+	} `yaml:"download_links"`
+
+	// Число одновременных запросов каждого класса и объем данных (байт), которые одновременно
+	// обрабатывают загрузки и скачивания; нулевой объем не ограничивается.
+	// Приоритет ожидающего запроса растет на уровень за каждые priority_aging
+	Concurrency struct {
+		UploadRequests   int           `yaml:"upload_requests" env:"CONCURRENCY_UPLOAD_REQUESTS" env-default:"10"`
+		UploadBytes      int64         `yaml:"upload_bytes" env:"CONCURRENCY_UPLOAD_BYTES" env-default:"268435456"`
+		DownloadRequests int           `yaml:"download_requests" env:"CONCURRENCY_DOWNLOAD_REQUESTS" env-default:"10"`
+		DownloadBytes    int64         `yaml:"download_bytes" env:"CONCURRENCY_DOWNLOAD_BYTES" env-default:"268435456"`
+		ListRequests     int           `yaml:"list_requests" env:"CONCURRENCY_LIST_REQUESTS" env-default:"100"`
+		PriorityAging    time.Duration `yaml:"priority_aging" env:"CONCURRENCY_PRIORITY_AGING" env-default:"5s"`
+	} `yaml:"concurrency"`
+
+	// Лимиты на клиента (токен из buckets.tokens или IP-адрес) для каждого класса методов;
+	// нулевая частота отключает лимит
+	RateLimits struct {
+		Upload   RateLimit `yaml:"upload" env-prefix:"RATE_LIMITS_UPLOAD_"`
+		Download RateLimit `yaml:"download" env-prefix:"RATE_LIMITS_DOWNLOAD_"`
+		List     RateLimit `yaml:"list" env-prefix:"RATE_LIMITS_LIST_"`
+	} `yaml:"rate_limits"`
+
+	// Токены клиентов (authorization: Bearer <токен>) и их бакеты: клиент с токеном работает
+	// только в своем бакете. Клиенты без токена работают только в default; другой бакет в
+	// метаданных x-bucket доступен лишь с x-admin-token
+	Buckets struct {
+		Tokens map[string]string `yaml:"tokens" env:"BUCKETS_TOKENS"`
+	} `yaml:"buckets"`
+
+	// Владельцы файлов по токенам клиентов (authorization: Bearer <токен>) для квот;
+	// клиенты без токена - владелец default, x-owner доступен лишь с x-admin-token
+	Owners struct {
+		Tokens map[string]string `yaml:"tokens" env:"OWNERS_TOKENS"`
+	} `yaml:"owners"`
+
+	// Токен для административных методов (метаданные x-admin-token); пустой отключает их
+	Admin struct {
+		Token string `yaml:"token" env:"ADMIN_TOKEN"`
+	} `yaml:"admin"`
+}
+
+// RateLimit - частота запросов и передаваемых байтов в секунду с допустимым всплеском;
+// нулевой всплеск равен частоте.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second" env:"REQUESTS_PER_SECOND"`
+	RequestsBurst     float64 `yaml:"requests_burst" env:"REQUESTS_BURST"`
+	BytesPerSecond    float64 `yaml:"bytes_per_second" env:"BYTES_PER_SECOND"`
+	BytesBurst        float64 `yaml:"bytes_burst" env:"BYTES_BURST"`
+}
+
+// PostgresClient возвращает параметры подключения для postgresql.NewClient.
+func (c *Config) PostgresClient() postgresql.Config {
+	p := c.Postgres
+	return postgresql.Config{
+		Host:             p.Host,
+		Port:             p.Port,
+		Database:         p.Database,
+		Username:         p.Username,
+		Password:         p.Password,
+		SSLMode:          p.SSLMode,
+		SSLRootCert:      p.SSLRootCert,
+		SSLCert:          p.SSLCert,
+		SSLKey:           p.SSLKey,
+		ApplicationName:  p.ApplicationName,
+		ConnectTimeout:   p.ConnectTimeout,
+		StatementTimeout: p.StatementTimeout,
+		MinConns:         p.MinConns,
+		MaxConns:         p.MaxConns,
+		MaxConnLifetime:  p.MaxConnLifetime,
+		MaxConnIdleTime:  p.MaxConnIdleTime,
+		TenantRole:       p.TenantRole,
+		ConnectRetry: retry.Policy{
+			MaxAttempts: p.ConnectAttempts,
+			MaxElapsed:  p.ConnectMaxElapsed,
+			BaseDelay:   p.RetryDelay,
+			MaxDelay:    p.MaxRetryDelay,
+		},
+	}
+}
+
+// PostgresReplicas возвращает параметры подключения к репликам для чтения.
+func (c *Config) PostgresReplicas() []postgresql.Config {
+	primary := c.PostgresClient()
+	replicas := make([]postgresql.Config, 0, len(c.Postgres.Replicas))
+	for _, addr := range c.Postgres.Replicas {
+		replicas = append(replicas, primary.Replica(addr))
+	}
+	return replicas
+}
+
+// PostgresQueryRetry возвращает политику повтора запросов для postgresql.WithRetry.
+func (c *Config) PostgresQueryRetry() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: c.Postgres.QueryAttempts,
+		BaseDelay:   c.Postgres.QueryRetryDelay,
+		MaxDelay:    c.Postgres.QueryMaxRetryDelay,
+	}
 }
 
 var instance *Config
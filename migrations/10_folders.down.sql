@@ -0,0 +1,5 @@
+ALTER TABLE public.upload_sessions DROP COLUMN IF EXISTS folder_id;
+DROP INDEX IF EXISTS files_root_name_idx;
+DROP INDEX IF EXISTS files_folder_name_idx;
+ALTER TABLE public.files DROP COLUMN IF EXISTS folder_id;
+DROP TABLE IF EXISTS public.folders;
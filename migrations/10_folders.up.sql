@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS public.folders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- NULL - папка в корне
+    parent_id UUID REFERENCES public.folders (id),
+    name VARCHAR(100) NOT NULL,
+    create_time timestamp default current_timestamp
+);
+
+-- имена папок уникальны в пределах родителя; индексы используются и для выборки содержимого
+CREATE UNIQUE INDEX IF NOT EXISTS folders_parent_name_idx ON public.folders (parent_id, name) WHERE parent_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS folders_root_name_idx ON public.folders (name) WHERE parent_id IS NULL;
+
+-- NULL - файл в корне. Имена файлов уникальны в пределах папки; в корне - нет,
+-- так как там остаются файлы, загруженные до появления папок, с повторяющимися именами.
+-- Уникальность в корне и между файлами и папками добавляет миграция 15_unique_names.
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES public.folders (id);
+CREATE UNIQUE INDEX IF NOT EXISTS files_folder_name_idx ON public.files (folder_id, name) WHERE folder_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS files_root_name_idx ON public.files (name) WHERE folder_id IS NULL;
+
+-- незавершенная загрузка в удаленную папку теряет смысл и удаляется вместе с ней
+ALTER TABLE public.upload_sessions ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES public.folders (id) ON DELETE CASCADE;
@@ -0,0 +1,133 @@
+DROP POLICY IF EXISTS upload_chunks_tenant ON public.upload_chunks;
+DROP POLICY IF EXISTS download_links_tenant ON public.download_links;
+DROP POLICY IF EXISTS file_versions_tenant ON public.file_versions;
+DROP POLICY IF EXISTS quota_usage_tenant ON public.quota_usage;
+DROP POLICY IF EXISTS quotas_tenant ON public.quotas;
+DROP POLICY IF EXISTS idempotency_keys_tenant ON public.idempotency_keys;
+DROP POLICY IF EXISTS upload_sessions_tenant ON public.upload_sessions;
+DROP POLICY IF EXISTS folders_tenant ON public.folders;
+DROP POLICY IF EXISTS files_tenant ON public.files;
+
+ALTER TABLE public.upload_chunks DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.download_links DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.file_versions DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.quota_usage DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.quotas DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.idempotency_keys DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.upload_sessions DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.folders DISABLE ROW LEVEL SECURITY;
+ALTER TABLE public.files DISABLE ROW LEVEL SECURITY;
+
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM file_service_tenant;
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM file_service_tenant;
+REVOKE USAGE ON SCHEMA public FROM file_service_tenant;
+DROP ROLE IF EXISTS file_service_tenant;
+
+DROP TRIGGER IF EXISTS upload_sessions_check_size ON public.upload_sessions;
+DROP TRIGGER IF EXISTS files_check_size ON public.files;
+DROP FUNCTION IF EXISTS public.upload_sessions_check_size();
+DROP FUNCTION IF EXISTS public.files_check_size();
+DROP FUNCTION IF EXISTS public.check_file_size(VARCHAR, BIGINT);
+
+-- Данные других бакетов удаляются: без бакетов их нельзя отличить от файлов default.
+DELETE FROM public.files WHERE bucket <> 'default';
+DELETE FROM public.upload_sessions WHERE bucket <> 'default';
+DELETE FROM public.folders WHERE bucket <> 'default';
+DELETE FROM public.idempotency_keys WHERE bucket <> 'default';
+DELETE FROM public.quotas WHERE bucket <> 'default';
+DELETE FROM public.quota_usage WHERE bucket <> 'default';
+
+DROP FUNCTION IF EXISTS public.apply_usage(VARCHAR, VARCHAR, BIGINT, BIGINT);
+CREATE OR REPLACE FUNCTION public.apply_usage(p_owner VARCHAR, p_bytes BIGINT, p_files BIGINT) RETURNS void AS $$
+DECLARE
+    u public.quota_usage%ROWTYPE;
+    q public.quotas%ROWTYPE;
+BEGIN
+    INSERT INTO public.quota_usage AS cur (owner, bytes, files)
+    VALUES (p_owner, p_bytes, p_files)
+    ON CONFLICT (owner) DO UPDATE SET
+        bytes = cur.bytes + EXCLUDED.bytes,
+        files = cur.files + EXCLUDED.files
+    RETURNING * INTO u;
+
+    SELECT * INTO q FROM public.quotas WHERE owner = p_owner;
+    IF FOUND AND ((p_bytes > 0 AND q.max_bytes > 0 AND u.bytes > q.max_bytes)
+               OR (p_files > 0 AND q.max_files > 0 AND u.files > q.max_files)) THEN
+        RAISE EXCEPTION 'quota exceeded for owner %', p_owner
+            USING ERRCODE = 'QT001',
+                  DETAIL = json_build_object(
+                      'owner', p_owner,
+                      'bytes', u.bytes,
+                      'files', u.files,
+                      'max_bytes', q.max_bytes,
+                      'max_files', q.max_files
+                  )::text;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := COALESCE(octet_length(OLD.data), 0);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := COALESCE(octet_length(NEW.data), 0);
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.owner, new_size, 1);
+        PERFORM public.apply_usage('*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage('*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.owner, new_size, 1);
+        PERFORM public.apply_usage('*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage('*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS files_root_name_idx;
+CREATE INDEX IF NOT EXISTS files_root_name_idx ON public.files (name) WHERE folder_id IS NULL;
+DROP INDEX IF EXISTS folders_root_name_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS folders_root_name_idx ON public.folders (name) WHERE parent_id IS NULL;
+
+ALTER TABLE public.upload_sessions DROP CONSTRAINT IF EXISTS upload_sessions_folder_id_fkey;
+ALTER TABLE public.upload_sessions ADD CONSTRAINT upload_sessions_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES public.folders (id) ON DELETE CASCADE;
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS files_folder_id_fkey;
+ALTER TABLE public.files ADD CONSTRAINT files_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES public.folders (id);
+ALTER TABLE public.folders DROP CONSTRAINT IF EXISTS folders_parent_id_fkey;
+ALTER TABLE public.folders ADD CONSTRAINT folders_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.folders (id);
+ALTER TABLE public.folders DROP CONSTRAINT IF EXISTS folders_bucket_id_key;
+
+ALTER TABLE public.quota_usage DROP CONSTRAINT IF EXISTS quota_usage_pkey;
+ALTER TABLE public.quota_usage ADD PRIMARY KEY (owner);
+ALTER TABLE public.quotas DROP CONSTRAINT IF EXISTS quotas_pkey;
+ALTER TABLE public.quotas ADD PRIMARY KEY (owner);
+ALTER TABLE public.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE public.idempotency_keys ADD PRIMARY KEY (key);
+
+DROP INDEX IF EXISTS files_bucket_id_idx;
+ALTER TABLE public.quota_usage DROP COLUMN IF EXISTS bucket;
+ALTER TABLE public.quotas DROP COLUMN IF EXISTS bucket;
+ALTER TABLE public.idempotency_keys DROP COLUMN IF EXISTS bucket;
+ALTER TABLE public.upload_sessions DROP COLUMN IF EXISTS bucket;
+ALTER TABLE public.folders DROP COLUMN IF EXISTS bucket;
+ALTER TABLE public.files DROP COLUMN IF EXISTS bucket;
+
+DROP FUNCTION IF EXISTS public.current_bucket();
+DROP TABLE IF EXISTS public.buckets;
@@ -0,0 +1,206 @@
+-- Бакет - изолированное пространство файлов одного арендатора. Нулевые значения настроек
+-- означают общие настройки сервиса; квота бакета хранится в quotas под владельцем '*'.
+CREATE TABLE IF NOT EXISTS public.buckets (
+    name VARCHAR(63) PRIMARY KEY,
+    max_file_size BIGINT NOT NULL DEFAULT 0,
+    keep_versions INTEGER NOT NULL DEFAULT 0,
+    keep_versions_days INTEGER NOT NULL DEFAULT 0,
+    create_time timestamp default current_timestamp
+);
+
+INSERT INTO public.buckets (name) VALUES ('default') ON CONFLICT DO NOTHING;
+
+-- Сервис задает app.tenant на соединении для каждого запроса арендатора; без него
+-- (фоновые задачи, утилиты, миграции) строки записываются в бакет default.
+CREATE OR REPLACE FUNCTION public.current_bucket() RETURNS VARCHAR
+    LANGUAGE sql STABLE AS $$ SELECT COALESCE(NULLIF(current_setting('app.tenant', true), ''), 'default') $$;
+
+-- Существующие строки попадают в бакет default.
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket() REFERENCES public.buckets (name);
+ALTER TABLE public.folders ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket() REFERENCES public.buckets (name);
+ALTER TABLE public.upload_sessions ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket() REFERENCES public.buckets (name);
+ALTER TABLE public.idempotency_keys ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket();
+ALTER TABLE public.quotas ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket();
+ALTER TABLE public.quota_usage ADD COLUMN IF NOT EXISTS bucket VARCHAR(63) NOT NULL DEFAULT public.current_bucket();
+
+CREATE INDEX IF NOT EXISTS files_bucket_id_idx ON public.files (bucket, id);
+
+-- ключи идемпотентности и квоты уникальны в пределах бакета; '*' - весь бакет
+ALTER TABLE public.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE public.idempotency_keys ADD PRIMARY KEY (bucket, key);
+ALTER TABLE public.quotas DROP CONSTRAINT IF EXISTS quotas_pkey;
+ALTER TABLE public.quotas ADD PRIMARY KEY (bucket, owner);
+ALTER TABLE public.quota_usage DROP CONSTRAINT IF EXISTS quota_usage_pkey;
+ALTER TABLE public.quota_usage ADD PRIMARY KEY (bucket, owner);
+
+-- Проверка внешнего ключа не подчиняется RLS, поэтому ссылки на папки включают бакет:
+-- иначе по угаданному ID можно было бы положить файл в папку чужого бакета.
+-- Имена ограничений прежние - по ним ошибки переводятся в ErrFolderNotFound.
+ALTER TABLE public.folders ADD CONSTRAINT folders_bucket_id_key UNIQUE (bucket, id);
+ALTER TABLE public.folders DROP CONSTRAINT IF EXISTS folders_parent_id_fkey;
+ALTER TABLE public.folders ADD CONSTRAINT folders_parent_id_fkey FOREIGN KEY (bucket, parent_id) REFERENCES public.folders (bucket, id);
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS files_folder_id_fkey;
+ALTER TABLE public.files ADD CONSTRAINT files_folder_id_fkey FOREIGN KEY (bucket, folder_id) REFERENCES public.folders (bucket, id);
+ALTER TABLE public.upload_sessions DROP CONSTRAINT IF EXISTS upload_sessions_folder_id_fkey;
+ALTER TABLE public.upload_sessions ADD CONSTRAINT upload_sessions_folder_id_fkey FOREIGN KEY (bucket, folder_id) REFERENCES public.folders (bucket, id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS folders_root_name_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS folders_root_name_idx ON public.folders (bucket, name) WHERE parent_id IS NULL;
+DROP INDEX IF EXISTS files_root_name_idx;
+CREATE INDEX IF NOT EXISTS files_root_name_idx ON public.files (bucket, name) WHERE folder_id IS NULL;
+
+-- Счетчики и квоты из 7_quotas ведутся в пределах бакета: '*' - весь бакет.
+DROP FUNCTION IF EXISTS public.apply_usage(VARCHAR, BIGINT, BIGINT);
+CREATE OR REPLACE FUNCTION public.apply_usage(p_bucket VARCHAR, p_owner VARCHAR, p_bytes BIGINT, p_files BIGINT) RETURNS void AS $$
+DECLARE
+    u public.quota_usage%ROWTYPE;
+    q public.quotas%ROWTYPE;
+BEGIN
+    INSERT INTO public.quota_usage AS cur (bucket, owner, bytes, files)
+    VALUES (p_bucket, p_owner, p_bytes, p_files)
+    ON CONFLICT (bucket, owner) DO UPDATE SET
+        bytes = cur.bytes + EXCLUDED.bytes,
+        files = cur.files + EXCLUDED.files
+    RETURNING * INTO u;
+
+    SELECT * INTO q FROM public.quotas WHERE bucket = p_bucket AND owner = p_owner;
+    IF FOUND AND ((p_bytes > 0 AND q.max_bytes > 0 AND u.bytes > q.max_bytes)
+               OR (p_files > 0 AND q.max_files > 0 AND u.files > q.max_files)) THEN
+        RAISE EXCEPTION 'quota exceeded for owner % in bucket %', p_owner, p_bucket
+            USING ERRCODE = 'QT001',
+                  DETAIL = json_build_object(
+                      'bucket', p_bucket,
+                      'owner', p_owner,
+                      'bytes', u.bytes,
+                      'files', u.files,
+                      'max_bytes', q.max_bytes,
+                      'max_files', q.max_files
+                  )::text;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- файл не переходит между бакетами, поэтому NEW.bucket = OLD.bucket
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := COALESCE(octet_length(OLD.data), 0);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := COALESCE(octet_length(NEW.data), 0);
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(OLD.bucket, '*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Наибольший размер файла бакета, как и квоты, проверяется в базе при любом способе записи.
+CREATE OR REPLACE FUNCTION public.check_file_size(p_bucket VARCHAR, p_size BIGINT) RETURNS void AS $$
+DECLARE
+    max_size BIGINT;
+BEGIN
+    SELECT max_file_size INTO max_size FROM public.buckets WHERE name = p_bucket;
+    IF max_size > 0 AND p_size > max_size THEN
+        RAISE EXCEPTION 'file size % exceeds the limit of bucket %', p_size, p_bucket
+            USING ERRCODE = 'QT002', DETAIL = max_size::text;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.files_check_size() RETURNS trigger AS $$
+BEGIN
+    PERFORM public.check_file_size(NEW.bucket, COALESCE(octet_length(NEW.data), 0));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.upload_sessions_check_size() RETURNS trigger AS $$
+BEGIN
+    PERFORM public.check_file_size(NEW.bucket, NEW.total_size);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_check_size ON public.files;
+CREATE TRIGGER files_check_size BEFORE INSERT OR UPDATE OF data ON public.files
+    FOR EACH ROW EXECUTE FUNCTION public.files_check_size();
+DROP TRIGGER IF EXISTS upload_sessions_check_size ON public.upload_sessions;
+CREATE TRIGGER upload_sessions_check_size BEFORE INSERT ON public.upload_sessions
+    FOR EACH ROW EXECUTE FUNCTION public.upload_sessions_check_size();
+
+-- Запросы арендаторов выполняются под ролью file_service_tenant (SET ROLE снимает и права
+-- суперпользователя), и политики RLS показывают ей только строки текущего бакета.
+-- Владелец таблиц политикам не подчиняется: фоновые задачи и утилиты видят все бакеты.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'file_service_tenant') THEN
+        CREATE ROLE file_service_tenant NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT file_service_tenant TO CURRENT_USER;
+GRANT USAGE ON SCHEMA public TO file_service_tenant;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO file_service_tenant;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO file_service_tenant;
+-- настройки бакетов меняют только администраторы
+REVOKE INSERT, UPDATE, DELETE ON public.buckets FROM file_service_tenant;
+
+ALTER TABLE public.buckets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.files ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.folders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.upload_sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.idempotency_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.quotas ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.quota_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.file_versions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.download_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.upload_chunks ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS buckets_tenant ON public.buckets;
+CREATE POLICY buckets_tenant ON public.buckets TO file_service_tenant USING (name = public.current_bucket());
+DROP POLICY IF EXISTS files_tenant ON public.files;
+CREATE POLICY files_tenant ON public.files TO file_service_tenant USING (bucket = public.current_bucket());
+DROP POLICY IF EXISTS folders_tenant ON public.folders;
+CREATE POLICY folders_tenant ON public.folders TO file_service_tenant USING (bucket = public.current_bucket());
+DROP POLICY IF EXISTS upload_sessions_tenant ON public.upload_sessions;
+CREATE POLICY upload_sessions_tenant ON public.upload_sessions TO file_service_tenant USING (bucket = public.current_bucket());
+DROP POLICY IF EXISTS idempotency_keys_tenant ON public.idempotency_keys;
+CREATE POLICY idempotency_keys_tenant ON public.idempotency_keys TO file_service_tenant USING (bucket = public.current_bucket());
+DROP POLICY IF EXISTS quotas_tenant ON public.quotas;
+CREATE POLICY quotas_tenant ON public.quotas TO file_service_tenant USING (bucket = public.current_bucket());
+DROP POLICY IF EXISTS quota_usage_tenant ON public.quota_usage;
+CREATE POLICY quota_usage_tenant ON public.quota_usage TO file_service_tenant USING (bucket = public.current_bucket());
+
+-- дочерние строки видны, если виден их файл или сессия; подзапрос сам ограничен RLS
+DROP POLICY IF EXISTS file_versions_tenant ON public.file_versions;
+CREATE POLICY file_versions_tenant ON public.file_versions TO file_service_tenant
+    USING (EXISTS (SELECT 1 FROM public.files f WHERE f.id = file_id));
+DROP POLICY IF EXISTS download_links_tenant ON public.download_links;
+CREATE POLICY download_links_tenant ON public.download_links TO file_service_tenant
+    USING (EXISTS (SELECT 1 FROM public.files f WHERE f.id = file_id));
+DROP POLICY IF EXISTS upload_chunks_tenant ON public.upload_chunks;
+CREATE POLICY upload_chunks_tenant ON public.upload_chunks TO file_service_tenant
+    USING (EXISTS (SELECT 1 FROM public.upload_sessions s WHERE s.id = session_id));
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS files_log_event ON public.files;
+DROP FUNCTION IF EXISTS public.files_log_event();
+DROP TABLE IF EXISTS public.file_events;
@@ -0,0 +1,66 @@
+-- Журнал изменений файлов для WatchFiles: состояние файла после изменения, для удаления - до него.
+-- Номера id выдаются до фиксации транзакций и не задают порядок видимости, поэтому события
+-- читаются в порядке (tx_id, id) и только после завершения всех более ранних транзакций.
+CREATE TABLE IF NOT EXISTS public.file_events (
+    id BIGSERIAL PRIMARY KEY,
+    tx_id xid8 NOT NULL DEFAULT pg_current_xact_id(),
+    bucket VARCHAR(63) NOT NULL,
+    type VARCHAR(7) NOT NULL,
+    file_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    revision BIGINT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    description TEXT NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    folder_id UUID,
+    file_create_time timestamp,
+    file_update_time timestamp,
+    create_time timestamp default current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS file_events_position_idx ON public.file_events (tx_id, id);
+CREATE INDEX IF NOT EXISTS file_events_create_time_idx ON public.file_events (create_time);
+
+-- Уведомление в канале file_events с именем бакета будит наблюдателей всех экземпляров
+-- сервиса; повторные уведомления одной транзакции PostgreSQL объединяет.
+CREATE OR REPLACE FUNCTION public.files_log_event() RETURNS trigger AS $$
+DECLARE
+    f public.files%ROWTYPE;
+    event_type VARCHAR(7);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        f := OLD;
+        event_type := 'deleted';
+    ELSE
+        f := NEW;
+        event_type := CASE TG_OP WHEN 'INSERT' THEN 'created' ELSE 'updated' END;
+    END IF;
+
+    -- каждое изменение файла увеличивает revision; остальные обновления (например,
+    -- служебных столбцов) событий не порождают
+    IF TG_OP = 'UPDATE' AND NEW.revision = OLD.revision THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO public.file_events
+        (bucket, type, file_id, name, size, revision, tags, description, metadata, folder_id, file_create_time, file_update_time)
+    VALUES
+        (f.bucket, event_type, f.id, f.name, octet_length(f.data), f.revision, f.tags, f.description, f.metadata, f.folder_id, f.create_time, f.update_time);
+
+    PERFORM pg_notify('file_events', f.bucket);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_log_event ON public.files;
+CREATE TRIGGER files_log_event
+AFTER INSERT OR UPDATE OR DELETE ON public.files
+FOR EACH ROW EXECUTE FUNCTION public.files_log_event();
+
+-- События, как и файлы, видны только своему бакету (см. 11_buckets).
+GRANT SELECT, INSERT, DELETE ON public.file_events TO file_service_tenant;
+GRANT USAGE ON SEQUENCE public.file_events_id_seq TO file_service_tenant;
+ALTER TABLE public.file_events ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS file_events_tenant ON public.file_events;
+CREATE POLICY file_events_tenant ON public.file_events TO file_service_tenant USING (bucket = public.current_bucket());
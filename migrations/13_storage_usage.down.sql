@@ -0,0 +1,56 @@
+DROP TRIGGER IF EXISTS upload_sessions_track_usage ON public.upload_sessions;
+DROP FUNCTION IF EXISTS public.upload_sessions_track_usage();
+DROP TRIGGER IF EXISTS file_versions_track_size ON public.file_versions;
+DROP FUNCTION IF EXISTS public.file_versions_track_size();
+
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := COALESCE(octet_length(OLD.data), 0);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := COALESCE(octet_length(NEW.data), 0);
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(OLD.bucket, '*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_track_usage ON public.files;
+CREATE TRIGGER files_track_usage
+AFTER INSERT OR DELETE OR UPDATE OF data, owner ON public.files
+FOR EACH ROW EXECUTE FUNCTION public.files_track_usage();
+
+UPDATE public.quota_usage SET bytes = 0;
+INSERT INTO public.quota_usage (bucket, owner, bytes, files)
+SELECT bucket, owner, SUM(bytes), 0 FROM (
+    SELECT bucket, owner, octet_length(data) AS bytes FROM public.files
+    UNION ALL
+    SELECT bucket, '*', octet_length(data) FROM public.files
+) usage
+GROUP BY bucket, owner
+ON CONFLICT (bucket, owner) DO UPDATE SET bytes = EXCLUDED.bytes;
+
+ALTER TABLE public.files DROP COLUMN IF EXISTS versions_size;
@@ -0,0 +1,102 @@
+-- Квоты учитывают все хранимые данные: каждую версию файла (текущая тоже хранится в
+-- file_versions) и принятые части сессий загрузки. Объем версий файла ведется в
+-- files.versions_size, поэтому счетчики владельца по-прежнему меняет один триггер на files,
+-- а версии, удаленные каскадно вместе с файлом, не вычитаются повторно.
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS versions_size BIGINT NOT NULL DEFAULT 0;
+
+UPDATE public.files f SET versions_size = v.size
+FROM (SELECT file_id, SUM(octet_length(data)) AS size FROM public.file_versions GROUP BY file_id) v
+WHERE v.file_id = f.id;
+
+UPDATE public.quota_usage SET bytes = 0;
+INSERT INTO public.quota_usage (bucket, owner, bytes, files)
+SELECT bucket, owner, SUM(bytes), 0 FROM (
+    SELECT bucket, owner, versions_size AS bytes FROM public.files
+    UNION ALL
+    SELECT bucket, '*', versions_size FROM public.files
+    UNION ALL
+    SELECT bucket, owner, received_size FROM public.upload_sessions
+    UNION ALL
+    SELECT bucket, '*', received_size FROM public.upload_sessions
+) usage
+GROUP BY bucket, owner
+ON CONFLICT (bucket, owner) DO UPDATE SET bytes = EXCLUDED.bytes;
+
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := OLD.versions_size;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := NEW.versions_size;
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(OLD.bucket, '*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_track_usage ON public.files;
+CREATE TRIGGER files_track_usage
+AFTER INSERT OR DELETE OR UPDATE OF versions_size, owner ON public.files
+FOR EACH ROW EXECUTE FUNCTION public.files_track_usage();
+
+-- При каскадном удалении вместе с файлом строки files уже нет, и обновление ничего не меняет:
+-- объем версий вычитает удаление самого файла.
+CREATE OR REPLACE FUNCTION public.file_versions_track_size() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE public.files SET versions_size = versions_size + octet_length(NEW.data) WHERE id = NEW.file_id;
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE public.files SET versions_size = versions_size - octet_length(OLD.data) WHERE id = OLD.file_id;
+    ELSIF octet_length(NEW.data) <> octet_length(OLD.data) THEN
+        UPDATE public.files SET versions_size = versions_size + octet_length(NEW.data) - octet_length(OLD.data) WHERE id = NEW.file_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS file_versions_track_size ON public.file_versions;
+CREATE TRIGGER file_versions_track_size
+AFTER INSERT OR DELETE OR UPDATE OF data ON public.file_versions
+FOR EACH ROW EXECUTE FUNCTION public.file_versions_track_size();
+
+-- Принятые части входят в квоту владельца сессии, пока сессия не завершена или не удалена.
+CREATE OR REPLACE FUNCTION public.upload_sessions_track_usage() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -OLD.received_size, 0);
+        PERFORM public.apply_usage(OLD.bucket, '*', -OLD.received_size, 0);
+    ELSIF NEW.received_size <> OLD.received_size THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, NEW.received_size - OLD.received_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', NEW.received_size - OLD.received_size, 0);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS upload_sessions_track_usage ON public.upload_sessions;
+CREATE TRIGGER upload_sessions_track_usage
+AFTER DELETE OR UPDATE OF received_size ON public.upload_sessions
+FOR EACH ROW EXECUTE FUNCTION public.upload_sessions_track_usage();
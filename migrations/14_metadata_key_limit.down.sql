@@ -0,0 +1,2 @@
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS files_metadata_keys_check;
+DROP FUNCTION IF EXISTS public.jsonb_key_count(JSONB);
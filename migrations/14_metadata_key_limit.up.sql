@@ -0,0 +1,9 @@
+-- Число ключей metadata проверяется и для результата слияния в UpdateFileMetadata, а не только
+-- для ключей запроса. Предел совпадает с maxMetadataKeys сервиса. NOT VALID не проверяет
+-- существующие строки: файл, уже превысивший предел, можно только сократить.
+CREATE OR REPLACE FUNCTION public.jsonb_key_count(doc JSONB) RETURNS INTEGER
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$ SELECT count(*)::integer FROM jsonb_object_keys(doc) $$;
+
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS files_metadata_keys_check;
+ALTER TABLE public.files ADD CONSTRAINT files_metadata_keys_check
+    CHECK (public.jsonb_key_count(metadata) <= 50) NOT VALID;
@@ -0,0 +1,8 @@
+-- переименованные повторы прежних имен не получают
+DROP TRIGGER IF EXISTS folders_check_name ON public.folders;
+DROP TRIGGER IF EXISTS files_check_name ON public.files;
+DROP FUNCTION IF EXISTS public.folders_check_name();
+DROP FUNCTION IF EXISTS public.files_check_name();
+DROP FUNCTION IF EXISTS public.check_entry_name(VARCHAR, UUID, VARCHAR, REGCLASS);
+DROP INDEX IF EXISTS files_root_name_idx;
+CREATE INDEX IF NOT EXISTS files_root_name_idx ON public.files (bucket, name) WHERE folder_id IS NULL;
@@ -0,0 +1,98 @@
+-- Имена уникальны в пределах папки для файлов и папок вместе, в том числе в корне:
+-- иначе ListFolder возвращал бы несколько записей с одним путем.
+-- Существующие повторы переименовываются: к имени добавляется начало ID файла,
+-- старейший файл с именем сохраняет его. Новая revision сбрасывает закэшированные etag.
+-- Если и новое имя занято (такой файл уже есть или у ID общее начало), к нему добавляется
+-- номер попытки, пока имя не станет свободным: иначе миграция упала бы на индексе ниже.
+DO $$
+DECLARE
+    f RECORD;
+    suffix TEXT;
+    candidate VARCHAR(100);
+    attempt INT;
+BEGIN
+    FOR f IN
+        SELECT x.id, x.bucket, x.folder_id, x.name
+        FROM public.files x
+        LEFT JOIN (
+            SELECT id, row_number() OVER (PARTITION BY bucket, name ORDER BY create_time, id) AS n
+            FROM public.files
+            WHERE folder_id IS NULL
+        ) dup ON dup.id = x.id
+        WHERE dup.n > 1 OR EXISTS (
+            SELECT 1 FROM public.folders d
+            WHERE d.bucket = x.bucket AND d.parent_id IS NOT DISTINCT FROM x.folder_id AND d.name = x.name
+        )
+        ORDER BY x.create_time, x.id
+    LOOP
+        attempt := 0;
+        LOOP
+            suffix := ' (' || left(f.id::text, 8) || CASE WHEN attempt > 0 THEN '-' || attempt ELSE '' END || ')';
+            candidate := left(f.name, 100 - length(suffix)) || suffix;
+            EXIT WHEN NOT EXISTS (
+                SELECT 1 FROM public.files
+                WHERE bucket = f.bucket AND folder_id IS NOT DISTINCT FROM f.folder_id AND name = candidate
+            ) AND NOT EXISTS (
+                SELECT 1 FROM public.folders
+                WHERE bucket = f.bucket AND parent_id IS NOT DISTINCT FROM f.folder_id AND name = candidate
+            );
+            attempt := attempt + 1;
+        END LOOP;
+
+        UPDATE public.files SET name = candidate, revision = revision + 1 WHERE id = f.id;
+    END LOOP;
+END;
+$$;
+
+DROP INDEX IF EXISTS files_root_name_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS files_root_name_idx ON public.files (bucket, name) WHERE folder_id IS NULL;
+
+-- Файл и папка лежат в разных таблицах, поэтому общее имя проверяют триггеры. Блокировка
+-- по имени в папке упорядочивает конкурентные записи, и вторая транзакция видит первую.
+CREATE OR REPLACE FUNCTION public.check_entry_name(p_bucket VARCHAR, p_parent UUID, p_name VARCHAR, p_table REGCLASS) RETURNS void AS $$
+DECLARE
+    taken BOOLEAN;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtextextended(p_bucket || '/' || COALESCE(p_parent::text, '') || '/' || p_name, 0));
+
+    IF p_table = 'public.files'::regclass THEN
+        SELECT EXISTS (SELECT 1 FROM public.files
+                       WHERE bucket = p_bucket AND folder_id IS NOT DISTINCT FROM p_parent AND name = p_name) INTO taken;
+    ELSE
+        SELECT EXISTS (SELECT 1 FROM public.folders
+                       WHERE bucket = p_bucket AND parent_id IS NOT DISTINCT FROM p_parent AND name = p_name) INTO taken;
+    END IF;
+
+    IF taken THEN
+        RAISE EXCEPTION 'name % already exists in the folder', p_name
+            USING ERRCODE = 'unique_violation', CONSTRAINT = 'entries_name_check';
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.files_check_name() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.name = OLD.name AND NEW.folder_id IS NOT DISTINCT FROM OLD.folder_id THEN
+        RETURN NEW;
+    END IF;
+    PERFORM public.check_entry_name(NEW.bucket, NEW.folder_id, NEW.name, 'public.folders');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.folders_check_name() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.name = OLD.name AND NEW.parent_id IS NOT DISTINCT FROM OLD.parent_id THEN
+        RETURN NEW;
+    END IF;
+    PERFORM public.check_entry_name(NEW.bucket, NEW.parent_id, NEW.name, 'public.files');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_check_name ON public.files;
+CREATE TRIGGER files_check_name BEFORE INSERT OR UPDATE OF name, folder_id ON public.files
+    FOR EACH ROW EXECUTE FUNCTION public.files_check_name();
+DROP TRIGGER IF EXISTS folders_check_name ON public.folders;
+CREATE TRIGGER folders_check_name BEFORE INSERT OR UPDATE OF name, parent_id ON public.folders
+    FOR EACH ROW EXECUTE FUNCTION public.folders_check_name();
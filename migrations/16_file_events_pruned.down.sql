@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.file_events_pruned;
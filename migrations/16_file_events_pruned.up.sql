@@ -0,0 +1,22 @@
+-- Наибольшая позиция удаленного события бакета: курсор до нее мог пропустить события
+-- и устарел, курсор после нее действителен, даже если его собственное событие удалено.
+CREATE TABLE IF NOT EXISTS public.file_events_pruned (
+    bucket VARCHAR(63) PRIMARY KEY,
+    tx_id xid8 NOT NULL,
+    id BIGINT NOT NULL
+);
+
+GRANT SELECT ON public.file_events_pruned TO file_service_tenant;
+ALTER TABLE public.file_events_pruned ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS file_events_pruned_tenant ON public.file_events_pruned;
+CREATE POLICY file_events_pruned_tenant ON public.file_events_pruned TO file_service_tenant USING (bucket = public.current_bucket());
+
+-- Какие события удалены до этой миграции, неизвестно: курсоры до самого старого
+-- оставшегося события бакета (или до текущей позиции, если событий нет) устаревают.
+INSERT INTO public.file_events_pruned (bucket, tx_id, id)
+SELECT b.name, COALESCE(e.tx_id, pg_snapshot_xmin(pg_current_snapshot())), COALESCE(e.id, 0)
+FROM public.buckets b
+LEFT JOIN LATERAL (
+    SELECT tx_id, id FROM public.file_events WHERE bucket = b.name ORDER BY tx_id, id LIMIT 1
+) e ON true
+ON CONFLICT (bucket) DO NOTHING;
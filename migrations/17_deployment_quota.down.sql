@@ -0,0 +1,51 @@
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := OLD.versions_size;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := NEW.versions_size;
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(OLD.bucket, '*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.upload_sessions_track_usage() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -OLD.received_size, 0);
+        PERFORM public.apply_usage(OLD.bucket, '*', -OLD.received_size, 0);
+    ELSIF NEW.received_size <> OLD.received_size THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, NEW.received_size - OLD.received_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', NEW.received_size - OLD.received_size, 0);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS public.apply_deployment_usage(BIGINT, BIGINT);
+DELETE FROM public.quota_usage WHERE bucket = '*';
+DELETE FROM public.quotas WHERE bucket = '*';
@@ -0,0 +1,77 @@
+-- Квота всего сервиса: строки quotas и quota_usage с bucket = '*' и owner = '*' ограничивают
+-- все бакеты вместе, в дополнение к квотам бакетов. До 11_buckets общая квота '*' была одна
+-- на сервис; миграция 11 отнесла ее к бакету default, поэтому она копируется и в общую строку.
+INSERT INTO public.quotas (bucket, owner, max_bytes, max_files)
+SELECT '*', '*', max_bytes, max_files FROM public.quotas WHERE bucket = 'default' AND owner = '*'
+ON CONFLICT (bucket, owner) DO NOTHING;
+
+INSERT INTO public.quota_usage (bucket, owner, bytes, files)
+SELECT '*', '*', COALESCE(SUM(bytes), 0), COALESCE(SUM(files), 0)
+FROM public.quota_usage WHERE owner = '*' AND bucket <> '*'
+ON CONFLICT (bucket, owner) DO UPDATE SET bytes = EXCLUDED.bytes, files = EXCLUDED.files;
+
+-- Запросы бакетов не видят строку '*' через RLS, поэтому она меняется от имени владельца
+-- функции. Строка блокируется последней, после владельца и бакета, как и прежде '*' в 7_quotas.
+CREATE OR REPLACE FUNCTION public.apply_deployment_usage(p_bytes BIGINT, p_files BIGINT) RETURNS void
+    SECURITY DEFINER SET search_path = public AS $$
+BEGIN
+    PERFORM public.apply_usage('*', '*', p_bytes, p_files);
+END;
+$$ LANGUAGE plpgsql;
+
+REVOKE ALL ON FUNCTION public.apply_deployment_usage(BIGINT, BIGINT) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION public.apply_deployment_usage(BIGINT, BIGINT) TO file_service_tenant;
+
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := OLD.versions_size;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := NEW.versions_size;
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size, 1);
+        PERFORM public.apply_deployment_usage(new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(OLD.bucket, '*', -old_size, -1);
+        PERFORM public.apply_deployment_usage(-old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size, 1);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+        PERFORM public.apply_deployment_usage(new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', new_size - old_size, 0);
+        PERFORM public.apply_deployment_usage(new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION public.upload_sessions_track_usage() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.bucket, OLD.owner, -OLD.received_size, 0);
+        PERFORM public.apply_usage(OLD.bucket, '*', -OLD.received_size, 0);
+        PERFORM public.apply_deployment_usage(-OLD.received_size, 0);
+    ELSIF NEW.received_size <> OLD.received_size THEN
+        PERFORM public.apply_usage(NEW.bucket, NEW.owner, NEW.received_size - OLD.received_size, 0);
+        PERFORM public.apply_usage(NEW.bucket, '*', NEW.received_size - OLD.received_size, 0);
+        PERFORM public.apply_deployment_usage(NEW.received_size - OLD.received_size, 0);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
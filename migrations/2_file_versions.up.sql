@@ -0,0 +1,14 @@
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS current_version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS public.file_versions (
+    file_id UUID NOT NULL REFERENCES public.files (id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    data BYTEA NOT NULL,
+    create_time timestamp default current_timestamp,
+    PRIMARY KEY (file_id, version)
+);
+
+INSERT INTO public.file_versions (file_id, version, name, data, create_time)
+SELECT id, current_version, name, data, update_time FROM public.files
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    file_id UUID REFERENCES public.files (id) ON DELETE CASCADE,
+    fingerprint VARCHAR(64) NOT NULL,
+    create_time timestamp default current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_create_time_idx ON public.idempotency_keys (create_time);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS public.upload_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    total_size BIGINT NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    received_size BIGINT NOT NULL DEFAULT 0,
+    create_time timestamp default current_timestamp,
+    expire_time timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS upload_sessions_expire_time_idx ON public.upload_sessions (expire_time);
+
+CREATE TABLE IF NOT EXISTS public.upload_chunks (
+    session_id UUID NOT NULL REFERENCES public.upload_sessions (id) ON DELETE CASCADE,
+    chunk_offset BIGINT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (session_id, chunk_offset)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.download_links (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    file_id UUID NOT NULL REFERENCES public.files (id) ON DELETE CASCADE,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    create_time timestamp default current_timestamp,
+    expire_time timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS download_links_expire_time_idx ON public.download_links (expire_time);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS files_track_usage ON public.files;
+DROP FUNCTION IF EXISTS public.files_track_usage();
+DROP FUNCTION IF EXISTS public.apply_usage(VARCHAR, BIGINT, BIGINT);
+DROP TABLE IF EXISTS quota_usage;
+DROP TABLE IF EXISTS quotas;
+ALTER TABLE public.upload_sessions DROP COLUMN IF EXISTS owner;
+ALTER TABLE public.files DROP COLUMN IF EXISTS owner;
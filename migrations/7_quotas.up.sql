@@ -0,0 +1,97 @@
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS owner VARCHAR(100) NOT NULL DEFAULT 'default';
+ALTER TABLE public.upload_sessions ADD COLUMN IF NOT EXISTS owner VARCHAR(100) NOT NULL DEFAULT 'default';
+
+-- owner = '*' - общая квота и общее использование; 0 - без ограничения
+CREATE TABLE IF NOT EXISTS public.quotas (
+    owner VARCHAR(100) PRIMARY KEY,
+    max_bytes BIGINT NOT NULL DEFAULT 0,
+    max_files BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS public.quota_usage (
+    owner VARCHAR(100) PRIMARY KEY,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    files BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO public.quota_usage (owner, bytes, files)
+SELECT owner, COALESCE(SUM(octet_length(data)), 0), COUNT(*) FROM public.files GROUP BY owner
+UNION ALL
+SELECT '*', COALESCE(SUM(octet_length(data)), 0), COUNT(*) FROM public.files
+ON CONFLICT (owner) DO NOTHING;
+
+-- apply_usage изменяет счетчики владельца и проверяет его квоту. Строка quota_usage остается
+-- заблокированной до конца транзакции, поэтому параллельные загрузки одного владельца
+-- проверяются по очереди и не могут вместе превысить квоту. Уменьшение не проверяется,
+-- чтобы удаление проходило всегда, даже если квоту снизили ниже текущего использования.
+CREATE OR REPLACE FUNCTION public.apply_usage(p_owner VARCHAR, p_bytes BIGINT, p_files BIGINT) RETURNS void AS $$
+DECLARE
+    u public.quota_usage%ROWTYPE;
+    q public.quotas%ROWTYPE;
+BEGIN
+    INSERT INTO public.quota_usage AS cur (owner, bytes, files)
+    VALUES (p_owner, p_bytes, p_files)
+    ON CONFLICT (owner) DO UPDATE SET
+        bytes = cur.bytes + EXCLUDED.bytes,
+        files = cur.files + EXCLUDED.files
+    RETURNING * INTO u;
+
+    SELECT * INTO q FROM public.quotas WHERE owner = p_owner;
+    IF FOUND AND ((p_bytes > 0 AND q.max_bytes > 0 AND u.bytes > q.max_bytes)
+               OR (p_files > 0 AND q.max_files > 0 AND u.files > q.max_files)) THEN
+        RAISE EXCEPTION 'quota exceeded for owner %', p_owner
+            USING ERRCODE = 'QT001',
+                  DETAIL = json_build_object(
+                      'owner', p_owner,
+                      'bytes', u.bytes,
+                      'files', u.files,
+                      'max_bytes', q.max_bytes,
+                      'max_files', q.max_files
+                  )::text;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Счетчики ведутся триггером, поэтому остаются согласованными при любом способе
+-- изменения files: загрузке, пакетных операциях, восстановлении версий и из резервной копии.
+-- Владелец всегда блокируется раньше общей строки '*', что исключает взаимные блокировки.
+CREATE OR REPLACE FUNCTION public.files_track_usage() RETURNS trigger AS $$
+DECLARE
+    old_size BIGINT := 0;
+    new_size BIGINT := 0;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_size := COALESCE(octet_length(OLD.data), 0);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_size := COALESCE(octet_length(NEW.data), 0);
+    END IF;
+
+    -- переименование и обновление без изменения размера не трогают счетчики
+    IF TG_OP = 'UPDATE' AND NEW.owner = OLD.owner AND new_size = old_size THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'INSERT' THEN
+        PERFORM public.apply_usage(NEW.owner, new_size, 1);
+        PERFORM public.apply_usage('*', new_size, 1);
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM public.apply_usage(OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage('*', -old_size, -1);
+    ELSIF NEW.owner <> OLD.owner THEN
+        PERFORM public.apply_usage(OLD.owner, -old_size, -1);
+        PERFORM public.apply_usage(NEW.owner, new_size, 1);
+        PERFORM public.apply_usage('*', new_size - old_size, 0);
+    ELSE
+        PERFORM public.apply_usage(NEW.owner, new_size - old_size, 0);
+        PERFORM public.apply_usage('*', new_size - old_size, 0);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS files_track_usage ON public.files;
+CREATE TRIGGER files_track_usage
+AFTER INSERT OR DELETE OR UPDATE OF data, owner ON public.files
+FOR EACH ROW EXECUTE FUNCTION public.files_track_usage();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS files_name_trgm_idx;
+DROP INDEX IF EXISTS files_search_vector_idx;
+ALTER TABLE public.files DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Знаки препинания заменяются пробелами, чтобы "cat_photo.jpg" разбивалось на слова cat, photo и jpg.
+-- Словарь simple не приводит слова к основе: имена файлов редко бывают текстом на одном языке.
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (setweight(to_tsvector('simple', regexp_replace(name, '[[:punct:]]+', ' ', 'g')), 'A')) STORED;
+
+CREATE INDEX IF NOT EXISTS files_search_vector_idx ON public.files USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS files_name_trgm_idx ON public.files USING GIN (name gin_trgm_ops);
@@ -0,0 +1,11 @@
+ALTER TABLE public.files DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE public.files ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (setweight(to_tsvector('simple', regexp_replace(name, '[[:punct:]]+', ' ', 'g')), 'A')) STORED;
+CREATE INDEX IF NOT EXISTS files_search_vector_idx ON public.files USING GIN (search_vector);
+
+DROP FUNCTION IF EXISTS public.tags_to_text(TEXT[]);
+DROP INDEX IF EXISTS files_metadata_idx;
+DROP INDEX IF EXISTS files_tags_idx;
+ALTER TABLE public.files DROP COLUMN IF EXISTS metadata;
+ALTER TABLE public.files DROP COLUMN IF EXISTS description;
+ALTER TABLE public.files DROP COLUMN IF EXISTS tags;
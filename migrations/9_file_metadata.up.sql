@@ -0,0 +1,22 @@
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.files ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+-- фильтры ListFiles: tags @> ... и metadata @> ...
+CREATE INDEX IF NOT EXISTS files_tags_idx ON public.files USING GIN (tags);
+CREATE INDEX IF NOT EXISTS files_metadata_idx ON public.files USING GIN (metadata jsonb_path_ops);
+
+-- array_to_string только стабильна, а в генерируемом столбце нужна неизменяемая функция
+CREATE OR REPLACE FUNCTION public.tags_to_text(tags TEXT[]) RETURNS TEXT
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$ SELECT array_to_string(tags, ' ') $$;
+
+-- поисковый вектор из 8_search дополняется тегами и описанием с меньшим весом, чем имя
+ALTER TABLE public.files DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE public.files ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', regexp_replace(name, '[[:punct:]]+', ' ', 'g')), 'A') ||
+        setweight(to_tsvector('simple', public.tags_to_text(tags)), 'B') ||
+        setweight(to_tsvector('simple', description), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS files_search_vector_idx ON public.files USING GIN (search_vector);
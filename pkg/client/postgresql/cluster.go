@@ -0,0 +1,272 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"app/pkg/logging"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// primaryWALQuery возвращает позицию WAL основного сервера в байтах.
+const primaryWALQuery = `SELECT (pg_current_wal_lsn() - '0/0'::pg_lsn)::float8`
+
+// replicationLagQuery возвращает отставание реплики в секундах. Реплика, применившая WAL
+// основного сервера до позиции $1, не отстает, даже если на нем давно не было записей.
+// Иначе отставание - время с последней примененной транзакции: сравнение с основным
+// сервером, а не с полученным самой репликой WAL, замечает и оборванную репликацию.
+const replicationLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_replay_lsn() - '0/0'::pg_lsn >= $1::float8 THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - COALESCE(pg_last_xact_replay_timestamp(), pg_postmaster_start_time()))
+	END::float8
+`
+
+// Replica - реплика для чтения; Name используется только в журнале.
+type Replica struct {
+	Name   string
+	Client Client
+}
+
+type replica struct {
+	Replica
+	healthy atomic.Bool
+}
+
+// Cluster направляет все запросы на основной сервер, а запросы, выбранные через
+// Reader, - на исправные реплики по очереди. Реплика исправна, если последняя
+// проверка прошла успешно и отставание не больше maxLag. Пока проверок не было,
+// реплики считаются неисправными.
+type Cluster struct {
+	primary  Client
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+	logger   *logging.Logger
+}
+
+func NewCluster(logger *logging.Logger, primary Client, replicas []Replica, maxLag time.Duration) *Cluster {
+	c := &Cluster{primary: primary, maxLag: maxLag, logger: logger}
+	for _, r := range replicas {
+		c.replicas = append(c.replicas, &replica{Replica: r})
+	}
+	return c
+}
+
+type sessionKey struct{}
+
+type session struct {
+	wrote atomic.Bool
+}
+
+// WithSession начинает в ctx сеанс запроса: после первой записи через Cluster
+// все чтения сеанса идут на основной сервер и видят свои изменения.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func markWrite(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.wrote.Store(true)
+	}
+}
+
+// Reader возвращает клиент для запросов только на чтение. Если исправных реплик нет
+// или в сеансе ctx уже была запись, это основной сервер, а внутри WithTx - транзакция.
+func (c *Cluster) Reader(ctx context.Context) Client {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok && s.wrote.Load() {
+		return c.primary
+	}
+
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return &replicaClient{cluster: c, replica: r}
+		}
+	}
+	return c.primary
+}
+
+// Reader возвращает клиент для чтения, если client - Cluster, иначе Conn(ctx, client).
+func Reader(ctx context.Context, client Client) Client {
+	if c, ok := client.(*Cluster); ok {
+		return c.Reader(ctx)
+	}
+	return Conn(ctx, client)
+}
+
+func (c *Cluster) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	markWrite(ctx)
+	return c.primary.Exec(ctx, sql, arguments...)
+}
+
+// Query и QueryRow через Cluster могут изменять данные (INSERT ... RETURNING),
+// поэтому тоже считаются записью.
+func (c *Cluster) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	markWrite(ctx)
+	return c.primary.Query(ctx, sql, args...)
+}
+
+func (c *Cluster) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	markWrite(ctx)
+	return c.primary.QueryRow(ctx, sql, args...)
+}
+
+func (c *Cluster) Begin(ctx context.Context) (pgx.Tx, error) {
+	markWrite(ctx)
+	return c.primary.Begin(ctx)
+}
+
+func (c *Cluster) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	markWrite(ctx)
+	return beginTx(ctx, c.primary, txOptions)
+}
+
+// Run проверяет реплики каждые interval, пока не отменен ctx.
+func (c *Cluster) Run(ctx context.Context, interval time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check измеряет отставание реплик и обновляет их состояние. Без позиции WAL основного
+// сервера отставание не проверить, и реплики считаются неисправными.
+func (c *Cluster) Check(ctx context.Context, timeout time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+
+	primaryWAL, primaryErr := queryFloat(ctx, c.primary, timeout, primaryWALQuery)
+	for _, r := range c.replicas {
+		lag, err := time.Duration(0), fmt.Errorf("primary WAL position is unknown: %w", primaryErr)
+		if primaryErr == nil {
+			lag, err = replicationLag(ctx, r.Client, timeout, primaryWAL)
+		}
+		switch {
+		case err != nil:
+			c.setHealthy(r, false, fmt.Sprintf("Replica %s is unavailable, reading from primary: %v", r.Name, err))
+		case c.maxLag > 0 && lag > c.maxLag:
+			c.setHealthy(r, false, fmt.Sprintf("Replica %s lags %s behind (max %s), skipping it", r.Name, lag, c.maxLag))
+		default:
+			c.setHealthy(r, true, fmt.Sprintf("Replica %s is available, lag %s", r.Name, lag))
+		}
+	}
+}
+
+// setHealthy пишет в журнал только смену состояния, чтобы не повторять сообщение
+// при каждой проверке.
+func (c *Cluster) setHealthy(r *replica, healthy bool, msg string) {
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		c.logger.Info(msg)
+	} else {
+		c.logger.Warn(msg)
+	}
+}
+
+func replicationLag(ctx context.Context, client Client, timeout time.Duration, primaryWAL float64) (time.Duration, error) {
+	seconds, err := queryFloat(ctx, client, timeout, replicationLagQuery, primaryWAL)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func queryFloat(ctx context.Context, client Client, timeout time.Duration, sql string, args ...interface{}) (float64, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var v float64
+	if err := client.QueryRow(ctx, sql, args...).Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// replicaFailed отличает отказ реплики от ошибки самого запроса: чтение после
+// разрыва соединения или конфликта с восстановлением можно повторить на основном сервере.
+func replicaFailed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return IsTransient(err)
+	}
+	return !errors.Is(err, pgx.ErrNoRows)
+}
+
+// replicaClient читает с реплики; при ее отказе помечает реплику неисправной
+// до следующей проверки и повторяет запрос на основном сервере.
+type replicaClient struct {
+	cluster *Cluster
+	replica *replica
+}
+
+func (c *replicaClient) fail(err error) {
+	c.cluster.setHealthy(c.replica, false, fmt.Sprintf("Replica %s failed, reading from primary: %v", c.replica.Name, err))
+}
+
+func (c *replicaClient) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return c.cluster.Exec(ctx, sql, arguments...)
+}
+
+func (c *replicaClient) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := c.replica.Client.Query(ctx, sql, args...)
+	if replicaFailed(err) {
+		c.fail(err)
+		return c.cluster.primary.Query(ctx, sql, args...)
+	}
+	return rows, err
+}
+
+func (c *replicaClient) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return replicaRow{c: c, ctx: ctx, sql: sql, args: args}
+}
+
+func (c *replicaClient) Begin(ctx context.Context) (pgx.Tx, error) {
+	return c.cluster.Begin(ctx)
+}
+
+type replicaRow struct {
+	c    *replicaClient
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+func (r replicaRow) Scan(dest ...interface{}) error {
+	err := r.c.replica.Client.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	if replicaFailed(err) {
+		r.c.fail(err)
+		return r.c.cluster.primary.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"app/pkg/logging"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClient отвечает на QueryRow значением value или ошибкой err и считает запросы.
+type fakeClient struct {
+	value   float64
+	err     error
+	queries int
+}
+
+func (c *fakeClient) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	c.queries++
+	return nil, c.err
+}
+
+func (c *fakeClient) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	c.queries++
+	return fakeRow{c}
+}
+
+func (c *fakeClient) Begin(context.Context) (pgx.Tx, error) {
+	return nil, nil
+}
+
+type fakeRow struct{ c *fakeClient }
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.c.err != nil {
+		return r.c.err
+	}
+	*dest[0].(*float64) = r.c.value
+	return nil
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger()
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		primary, a, b := &fakeClient{}, &fakeClient{}, &fakeClient{}
+		c := NewCluster(logger, primary, []Replica{{"a", a}, {"b", b}}, time.Second)
+
+		// до первой проверки реплики не используются
+		assert.Same(t, primary, c.Reader(ctx))
+
+		c.Check(ctx, time.Second)
+		for i := 0; i < 4; i++ {
+			var v float64
+			assert.NoError(t, c.Reader(ctx).QueryRow(ctx, "SELECT 1").Scan(&v))
+		}
+		assert.Equal(t, 3, a.queries)
+		assert.Equal(t, 3, b.queries)
+		// только запрос позиции WAL при проверке
+		assert.Equal(t, 1, primary.queries)
+	})
+
+	t.Run("MaxLag", func(t *testing.T) {
+		primary, lagging, down := &fakeClient{}, &fakeClient{value: 30}, &fakeClient{err: errors.New("connection refused")}
+		c := NewCluster(logger, primary, []Replica{{"lagging", lagging}, {"down", down}}, 10*time.Second)
+
+		c.Check(ctx, time.Second)
+		assert.Same(t, primary, c.Reader(ctx))
+
+		lagging.value = 5
+		c.Check(ctx, time.Second)
+		assert.Equal(t, &replicaClient{cluster: c, replica: c.replicas[0]}, c.Reader(ctx))
+	})
+
+	t.Run("PrimaryUnavailable", func(t *testing.T) {
+		primary, a := &fakeClient{}, &fakeClient{}
+		c := NewCluster(logger, primary, []Replica{{"a", a}}, 10*time.Second)
+		c.Check(ctx, time.Second)
+		assert.NotSame(t, primary, c.Reader(ctx))
+
+		// без позиции WAL основного сервера отставание реплики неизвестно
+		primary.err = errors.New("connection refused")
+		c.Check(ctx, time.Second)
+		assert.Same(t, primary, c.Reader(ctx))
+		assert.Equal(t, 1, a.queries)
+	})
+
+	t.Run("ReadAfterWrite", func(t *testing.T) {
+		primary, a := &fakeClient{}, &fakeClient{}
+		c := NewCluster(logger, primary, []Replica{{"a", a}}, 0)
+		c.Check(ctx, time.Second)
+
+		sessionCtx := WithSession(ctx)
+		assert.NotSame(t, primary, c.Reader(sessionCtx))
+		_, _ = c.Exec(sessionCtx, "DELETE FROM files")
+		assert.Same(t, primary, c.Reader(sessionCtx))
+
+		// другие запросы продолжают читать с реплики
+		assert.NotSame(t, primary, c.Reader(WithSession(ctx)))
+		assert.NotSame(t, primary, c.Reader(ctx))
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		primary, a := &fakeClient{value: 1}, &fakeClient{}
+		c := NewCluster(logger, primary, []Replica{{"a", a}}, 0)
+		c.Check(ctx, time.Second)
+
+		a.err = &pgconn.PgError{Code: "57P01"}
+		var v float64
+		assert.NoError(t, c.Reader(ctx).QueryRow(ctx, "SELECT 1").Scan(&v))
+		assert.Equal(t, float64(1), v)
+		assert.Same(t, primary, c.Reader(ctx))
+
+		// ошибки самого запроса на основной сервер не переносятся
+		a.err = nil
+		c.Check(ctx, time.Second)
+		a.err = pgx.ErrNoRows
+		assert.ErrorIs(t, c.Reader(ctx).QueryRow(ctx, "SELECT 1").Scan(&v), pgx.ErrNoRows)
+		a.err = &pgconn.PgError{Code: "42P01"}
+		_, err := c.Reader(ctx).Query(ctx, "SELECT 1")
+		assert.Error(t, err)
+		assert.Equal(t, 3, primary.queries, "two WAL position checks and one fallback read")
+	})
+}
+
+func TestReplicaConfig(t *testing.T) {
+	primary := Config{Host: "db", Port: "5432", Username: "admin", MaxConns: 10}
+
+	replica := primary.Replica("replica-1:5433")
+	assert.Equal(t, "replica-1", replica.Host)
+	assert.Equal(t, "5433", replica.Port)
+	assert.Equal(t, "admin", replica.Username)
+	assert.Equal(t, int32(10), replica.MaxConns)
+	assert.True(t, replica.LazyConnect)
+
+	assert.Equal(t, "5432", primary.Replica("replica-2").Port)
+}
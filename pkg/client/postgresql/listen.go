@@ -0,0 +1,57 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"app/pkg/logging"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Listen подписывается на канал channel (LISTEN) на отдельном соединении пула и вызывает
+// notify с текстом каждого уведомления. После обрыва подписка восстанавливается через
+// delay; subscribed вызывается после каждой подписки, так как уведомления за время
+// обрыва потеряны. Блокируется до отмены ctx.
+func Listen(ctx context.Context, logger *logging.Logger, pool *pgxpool.Pool, channel string, delay time.Duration, notify func(payload string), subscribed func()) {
+	for {
+		err := listen(ctx, pool, channel, notify, subscribed)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn(fmt.Sprintf("Lost subscription to PostgreSQL channel %s, resubscribing in %s: %v", channel, delay, err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func listen(ctx context.Context, pool *pgxpool.Pool, channel string, notify func(payload string), subscribed func()) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// соединение с подпиской закрывается, а не возвращается в пул
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	subscribed()
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		notify(n.Payload)
+	}
+}
@@ -3,10 +3,13 @@ package postgresql
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 	"time"
 
 	"app/pkg/logging"
-	repeatable "app/pkg/utils"
+	"app/pkg/retry"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
@@ -20,23 +23,149 @@ type Client interface {
 	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
-func NewClient(logger *logging.Logger, ctx context.Context, maxAttempts int, host, port, username, password, database string) (pool *pgxpool.Pool, err error) {
-	dsn := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s", username, password, host, port, database)
-	err = repeatable.DoWithTries(func() error {
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
+// Config - параметры подключения и пула. Нулевые значения параметров пула
+// оставляют значения pgxpool по умолчанию.
+type Config struct {
+	Host     string
+	Port     string
+	Database string
+	Username string
+	Password string
+
+	// SSLMode - disable, allow, prefer, require, verify-ca или verify-full
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	ApplicationName  string
+	ConnectTimeout   time.Duration
+	StatementTimeout time.Duration
+
+	MinConns        int32
+	MaxConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+
+	// LazyConnect не проверяет подключение при создании пула; так подключаются реплики,
+	// чтобы недоступная реплика не мешала запуску
+	LazyConnect bool
 
-		pool, err = pgxpool.Connect(ctx, dsn)
-		if err != nil {
-			return err
+	// TenantRole - роль, под которой выполняются запросы с WithTenant; пустая
+	// отключает переключение, и запросы арендаторов не отличаются от остальных
+	TenantRole string
+
+	// повторы подключения; ошибки авторизации и несуществующая база не повторяются
+	ConnectRetry retry.Policy
+}
+
+// DSN собирает строку подключения; учетные данные и параметры экранируются.
+func (c Config) DSN() string {
+	params := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			params.Set(key, value)
 		}
+	}
+	set("sslmode", c.SSLMode)
+	set("sslrootcert", c.SSLRootCert)
+	set("sslcert", c.SSLCert)
+	set("sslkey", c.SSLKey)
+	set("application_name", c.ApplicationName)
+	if c.ConnectTimeout > 0 {
+		// libpq принимает только целые секунды
+		set("connect_timeout", strconv.Itoa(max(int(c.ConnectTimeout.Seconds()), 1)))
+	}
 
-		return nil
-	}, maxAttempts, 5*time.Second)
+	dsn := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.Database,
+		RawQuery: params.Encode(),
+	}
+	return dsn.String()
+}
+
+// Replica возвращает параметры подключения к реплике addr ("host" или "host:port")
+// с теми же учетными данными и настройками пула.
+func (c Config) Replica(addr string) Config {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, c.Port
+	}
+	c.Host, c.Port = host, port
+	c.LazyConnect = true
+	return c
+}
 
+func (c Config) poolConfig() (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(c.DSN())
 	if err != nil {
-		logger.Fatal("error do with tries postgresql\n", err)
+		return nil, err
 	}
 
+	if c.MinConns > 0 {
+		poolConfig.MinConns = c.MinConns
+	}
+	if c.MaxConns > 0 {
+		poolConfig.MaxConns = c.MaxConns
+	}
+	if c.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	poolConfig.LazyConnect = c.LazyConnect
+	if c.StatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	if c.TenantRole != "" {
+		poolConfig.BeforeAcquire = newTenantScope(c.TenantRole, poolConfig.MaxConns).beforeAcquire
+	}
+	return poolConfig, nil
+}
+
+// NewClient подключается к базе, повторяя неудачные попытки по cfg.ConnectRetry,
+// пока не истечет ctx.
+func NewClient(logger *logging.Logger, ctx context.Context, cfg Config) (*pgxpool.Pool, error) {
+	poolConfig, err := cfg.poolConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgresql config: %w", err)
+	}
+
+	policy := cfg.ConnectRetry
+	policy.Retryable = isConnectRetryable
+	if policy.OnRetry == nil {
+		policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+			logger.Warn(fmt.Sprintf("Failed to connect to PostgreSQL (attempt %d), retrying in %s: %v", attempt, delay, err))
+		}
+	}
+
+	var pool *pgxpool.Pool
+	err = retry.Do(ctx, policy, func(ctx context.Context) (err error) {
+		pool, err = connect(ctx, poolConfig, cfg.ConnectTimeout)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgresql: %w", err)
+	}
+
+	if cfg.TenantRole != "" && !cfg.LazyConnect {
+		if err = checkTenantRole(ctx, pool, cfg.TenantRole); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
 	return pool, nil
 }
+
+func connect(ctx context.Context, poolConfig *pgxpool.Config, timeout time.Duration) (*pgxpool.Pool, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return pgxpool.ConnectConfig(ctx, poolConfig)
+}
@@ -0,0 +1,51 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolConfig(t *testing.T) {
+	cfg := Config{
+		Host:             "db.internal",
+		Port:             "5431",
+		Database:         "app",
+		Username:         "admin",
+		Password:         "p@ss:w/rd?#",
+		SSLMode:          "disable",
+		ApplicationName:  "file service",
+		ConnectTimeout:   1500 * time.Millisecond,
+		StatementTimeout: 30 * time.Second,
+		MaxConns:         20,
+		MaxConnIdleTime:  time.Minute,
+	}
+
+	poolConfig, err := cfg.poolConfig()
+	require.NoError(t, err)
+
+	conn := poolConfig.ConnConfig
+	assert.Equal(t, "db.internal", conn.Host)
+	assert.Equal(t, uint16(5431), conn.Port)
+	assert.Equal(t, "admin", conn.User)
+	assert.Equal(t, "p@ss:w/rd?#", conn.Password)
+	assert.Equal(t, "app", conn.Database)
+	assert.Nil(t, conn.TLSConfig)
+	assert.Equal(t, time.Second, conn.ConnectTimeout)
+	assert.Equal(t, "file service", conn.RuntimeParams["application_name"])
+	assert.Equal(t, "30000", conn.RuntimeParams["statement_timeout"])
+
+	assert.Equal(t, int32(20), poolConfig.MaxConns)
+	assert.Equal(t, time.Minute, poolConfig.MaxConnIdleTime)
+	// незаданные параметры остаются по умолчанию
+	assert.Equal(t, time.Hour, poolConfig.MaxConnLifetime)
+	assert.Nil(t, poolConfig.BeforeAcquire)
+
+	cfg.TenantRole = "file_service_tenant"
+	poolConfig, err = cfg.poolConfig()
+	require.NoError(t, err)
+	assert.NotNil(t, poolConfig.BeforeAcquire)
+	assert.Equal(t, "file_service_tenant", cfg.Replica("replica-1").TenantRole)
+}
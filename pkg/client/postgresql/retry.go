@@ -0,0 +1,128 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"app/pkg/logging"
+	"app/pkg/retry"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Коды SQLSTATE, https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	serializationFailure      = "40001"
+	deadlockDetected          = "40P01"
+	tooManyConnections        = "53300"
+	adminShutdown             = "57P01"
+	cannotConnectNow          = "57P03"
+	connectionExceptionClass  = "08"
+	invalidAuthorizationClass = "28"
+	invalidCatalogName        = "3D000"
+)
+
+// IsTransient сообщает, что запрос можно безопасно повторить: сервер откатил его
+// из-за конфликта (ошибка сериализации, взаимоблокировка, перегрузка) или он не был
+// отправлен, например из-за разрыва соединения.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case serializationFailure, deadlockDetected, tooManyConnections, cannotConnectNow, adminShutdown:
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, connectionExceptionClass)
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// isConnectRetryable отсекает ошибки подключения, которые повтор не исправит:
+// неверные учетные данные, несуществующую базу и ошибки конфигурации.
+func isConnectRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return !strings.HasPrefix(pgErr.Code, invalidAuthorizationClass) && pgErr.Code != invalidCatalogName
+	}
+	return true
+}
+
+// retryClient повторяет отдельные запросы вне транзакций. Запросы внутри транзакции
+// не повторяются: после ошибки транзакция прервана, повторять нужно ее целиком.
+type retryClient struct {
+	client Client
+	policy retry.Policy
+}
+
+// WithRetry оборачивает client так, что запросы с временными ошибками (IsTransient)
+// повторяются по policy. Ошибки строк Query после первого ответа не повторяются.
+func WithRetry(logger *logging.Logger, client Client, policy retry.Policy) Client {
+	policy.Retryable = IsTransient
+	if policy.OnRetry == nil {
+		policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+			logger.Warn(fmt.Sprintf("Retrying PostgreSQL query after attempt %d in %s: %v", attempt, delay, err))
+		}
+	}
+	return &retryClient{client: client, policy: policy}
+}
+
+func (c *retryClient) Exec(ctx context.Context, sql string, arguments ...interface{}) (tag pgconn.CommandTag, err error) {
+	err = retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		tag, err = c.client.Exec(ctx, sql, arguments...)
+		return err
+	})
+	return tag, err
+}
+
+func (c *retryClient) Query(ctx context.Context, sql string, args ...interface{}) (rows pgx.Rows, err error) {
+	err = retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		rows, err = c.client.Query(ctx, sql, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryRow выполняет запрос при Scan, поэтому и повторяется в Scan.
+func (c *retryClient) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return retryRow{c: c, ctx: ctx, sql: sql, args: args}
+}
+
+func (c *retryClient) Begin(ctx context.Context) (tx pgx.Tx, err error) {
+	err = retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		tx, err = c.client.Begin(ctx)
+		return err
+	})
+	return tx, err
+}
+
+func (c *retryClient) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (tx pgx.Tx, err error) {
+	err = retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		tx, err = beginTx(ctx, c.client, txOptions)
+		return err
+	})
+	return tx, err
+}
+
+type retryRow struct {
+	c    *retryClient
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+func (r retryRow) Scan(dest ...interface{}) error {
+	return retry.Do(r.ctx, r.c.policy, func(ctx context.Context) error {
+		return r.c.client.QueryRow(ctx, r.sql, r.args...).Scan(dest...)
+	})
+}
@@ -0,0 +1,36 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&pgconn.PgError{Code: "40001"}, true},
+		{fmt.Errorf("update: %w", &pgconn.PgError{Code: "40P01"}), true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{&pgconn.PgError{Code: "QT001"}, false},
+		{context.Canceled, false},
+		{errors.New("unknown"), false},
+	} {
+		assert.Equal(t, tc.transient, IsTransient(tc.err), "%v", tc.err)
+	}
+}
+
+func TestIsConnectRetryable(t *testing.T) {
+	assert.True(t, isConnectRetryable(errors.New("dial tcp: connection refused")))
+	assert.True(t, isConnectRetryable(&pgconn.PgError{Code: "57P03"}))
+	assert.False(t, isConnectRetryable(&pgconn.PgError{Code: "28P01"}))
+	assert.False(t, isConnectRetryable(&pgconn.PgError{Code: "3D000"}))
+	assert.False(t, isConnectRetryable(context.DeadlineExceeded))
+}
@@ -0,0 +1,96 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// TenantSetting - параметр сеанса с арендатором запроса, на который опираются политики RLS.
+const TenantSetting = "app.tenant"
+
+type tenantKey struct{}
+
+// WithTenant помечает запросы с ctx арендатором tenant. Соединение пула, выданное
+// такому запросу, переключается на роль Config.TenantRole с параметром app.tenant,
+// поэтому политики RLS показывают запросу только строки арендатора.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext возвращает арендатора, заданного WithTenant, или пустую строку.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantScope переключает соединения пула между арендаторами перед выдачей. Соединение
+// без арендатора работает под ролью пользователя подключения, как без tenantScope.
+type tenantScope struct {
+	role string
+	// больше стольких записей в conns бывает, только если пул закрыл часть соединений
+	maxConns int
+
+	mu sync.Mutex
+	// арендатор, на которого сейчас переключено соединение
+	conns map[*pgx.Conn]string
+}
+
+func newTenantScope(role string, maxConns int32) *tenantScope {
+	return &tenantScope{role: role, maxConns: int(maxConns), conns: make(map[*pgx.Conn]string)}
+}
+
+// beforeAcquire - pgxpool.Config.BeforeAcquire. Параметры задаются на уровне сеанса
+// вне транзакции, поэтому действуют до следующего переключения; запрос выполняется,
+// только если соединение переключено на другого арендатора.
+func (s *tenantScope) beforeAcquire(ctx context.Context, conn *pgx.Conn) bool {
+	tenant := TenantFromContext(ctx)
+
+	s.mu.Lock()
+	current := s.conns[conn]
+	s.mu.Unlock()
+	if current == tenant {
+		return true
+	}
+
+	role := s.role
+	if tenant == "" {
+		role = "none"
+	}
+	// при ошибке соединение в неизвестном состоянии, пул закроет его и выдаст другое
+	if _, err := conn.Exec(ctx, `SELECT set_config('role', $1, false), set_config('`+TenantSetting+`', $2, false)`, role, tenant); err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tenant == "" {
+		delete(s.conns, conn)
+	} else {
+		s.conns[conn] = tenant
+	}
+	if len(s.conns) > s.maxConns {
+		for c := range s.conns {
+			if c.IsClosed() {
+				delete(s.conns, c)
+			}
+		}
+	}
+	return true
+}
+
+// checkTenantRole проверяет, что пользователь подключения может переключиться на роль
+// арендаторов: иначе каждый запрос арендатора закрывал бы соединение и пробовал снова.
+func checkTenantRole(ctx context.Context, pool *pgxpool.Pool, role string) error {
+	var member bool
+	if err := pool.QueryRow(ctx, `SELECT pg_has_role($1, 'MEMBER')`, role).Scan(&member); err != nil {
+		return fmt.Errorf("check tenant role %s: %w", role, err)
+	}
+	if !member {
+		return fmt.Errorf("tenant role %s is not granted to the current user", role)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"app/pkg/retry"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// TxOptions - параметры транзакции WithTx. Retry задает повтор всей транзакции
+// при временных ошибках (IsTransient); политика без ограничений заменяется DefaultTxRetry.
+type TxOptions struct {
+	pgx.TxOptions
+	Retry retry.Policy
+}
+
+var DefaultTxRetry = retry.Policy{
+	MaxAttempts: 3,
+	BaseDelay:   20 * time.Millisecond,
+	MaxDelay:    500 * time.Millisecond,
+}
+
+var errTxOptionsUnsupported = errors.New("client does not support transaction options")
+
+type txKey struct{}
+
+// TxFromContext возвращает транзакцию, начатую WithTx выше по стеку вызовов.
+func TxFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// Conn возвращает транзакцию из ctx, если она есть, иначе client. Через Conn
+// запросы репозиториев присоединяются к внешней транзакции.
+func Conn(ctx context.Context, client Client) Client {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	return client
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// WithTx выполняет fn в транзакции: фиксирует ее, если fn вернула nil, и откатывает
+// при ошибке или панике (панику передает дальше). Контекст fn содержит транзакцию,
+// поэтому вложенный WithTx и методы, использующие Conn, работают в ней же; вложенный
+// WithTx не фиксирует и не повторяет внешнюю транзакцию.
+//
+// При ошибке сериализации, взаимоблокировке или разрыве соединения транзакция
+// повторяется целиком, поэтому fn не должна иметь побочных эффектов вне базы.
+func WithTx(ctx context.Context, client Client, opts TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	if tx, ok := TxFromContext(ctx); ok {
+		return fn(ctx, tx)
+	}
+
+	policy := opts.Retry
+	if policy.MaxAttempts == 0 && policy.MaxElapsed == 0 {
+		policy = DefaultTxRetry
+	}
+	policy.Retryable = IsTransient
+
+	return retry.Do(ctx, policy, func(ctx context.Context) error {
+		return runTx(ctx, client, opts.TxOptions, fn)
+	})
+}
+
+func runTx(ctx context.Context, client Client, opts pgx.TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) (err error) {
+	tx, err := beginTx(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		p := recover()
+		if p != nil || err != nil {
+			// откат нужен и после отмены ctx, иначе соединение вернется в пул с открытой транзакцией
+			_ = tx.Rollback(context.WithoutCancel(ctx))
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx), tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func beginTx(ctx context.Context, client Client, opts pgx.TxOptions) (pgx.Tx, error) {
+	if b, ok := client.(txBeginner); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts != (pgx.TxOptions{}) {
+		return nil, errTxOptionsUnsupported
+	}
+	return client.Begin(ctx)
+}
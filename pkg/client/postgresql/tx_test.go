@@ -0,0 +1,117 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTx struct {
+	pgx.Tx
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	if !tx.committed {
+		tx.rolledBack = true
+	}
+	return nil
+}
+
+// txClient начинает новую fakeTx на каждый Begin.
+type txClient struct {
+	fakeClient
+	txs []*fakeTx
+}
+
+func (c *txClient) Begin(context.Context) (pgx.Tx, error) {
+	tx := &fakeTx{}
+	c.txs = append(c.txs, tx)
+	return tx, nil
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		client := &txClient{}
+		err := WithTx(ctx, client, TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+			assert.Same(t, tx, Conn(ctx, client))
+			assert.Same(t, tx, Reader(ctx, client))
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, client.txs, 1)
+		assert.True(t, client.txs[0].committed)
+		assert.Same(t, client, Conn(ctx, client))
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		client := &txClient{}
+		errFailed := errors.New("failed")
+		err := WithTx(ctx, client, TxOptions{}, func(context.Context, pgx.Tx) error {
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed)
+		assert.Len(t, client.txs, 1)
+		assert.True(t, client.txs[0].rolledBack)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		client := &txClient{}
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = WithTx(ctx, client, TxOptions{}, func(context.Context, pgx.Tx) error {
+				panic("boom")
+			})
+		})
+		assert.True(t, client.txs[0].rolledBack)
+	})
+
+	t.Run("RetrySerializationFailure", func(t *testing.T) {
+		client := &txClient{}
+		calls := 0
+		err := WithTx(ctx, client, TxOptions{}, func(context.Context, pgx.Tx) error {
+			calls++
+			if calls == 1 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		assert.Len(t, client.txs, 2)
+		assert.True(t, client.txs[0].rolledBack)
+		assert.True(t, client.txs[1].committed)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		client := &txClient{}
+		err := WithTx(ctx, client, TxOptions{}, func(ctx context.Context, outer pgx.Tx) error {
+			return WithTx(ctx, client, TxOptions{}, func(ctx context.Context, inner pgx.Tx) error {
+				assert.Same(t, outer, inner)
+				return nil
+			})
+		})
+		assert.NoError(t, err)
+		assert.Len(t, client.txs, 1)
+	})
+
+	t.Run("OptionsUnsupported", func(t *testing.T) {
+		client := &txClient{}
+		err := WithTx(ctx, client, TxOptions{TxOptions: pgx.TxOptions{IsoLevel: pgx.Serializable}}, func(context.Context, pgx.Tx) error {
+			return nil
+		})
+		assert.ErrorIs(t, err, errTxOptionsUnsupported)
+		assert.Empty(t, client.txs)
+	})
+}
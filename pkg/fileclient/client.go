@@ -0,0 +1,169 @@
+// Package fileclient - клиент FileService с повторами, таймаутами по умолчанию
+// и потоковой загрузкой и выгрузкой файлов.
+package fileclient
+
+import (
+	"context"
+	"time"
+
+	pb "app/api/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultTimeout       = 30 * time.Second
+	defaultRetryAttempts = 4
+	defaultRetryBase     = 100 * time.Millisecond
+	defaultRetryMax      = 5 * time.Second
+	defaultChunkSize     = 1 << 20
+	defaultPageSize      = 100
+)
+
+type Client struct {
+	rpc  pb.FileServiceClient
+	conn *grpc.ClientConn
+	opts options
+}
+
+type options struct {
+	timeout       time.Duration
+	retryAttempts int
+	retryBase     time.Duration
+	retryMax      time.Duration
+	chunkSize     int
+	pageSize      int32
+
+	transportCreds credentials.TransportCredentials
+	perRPCCreds    credentials.PerRPCCredentials
+	token          string
+	dialOptions    []grpc.DialOption
+}
+
+type Option func(*options)
+
+// WithTimeout задает дедлайн одной попытки вызова, если у контекста нет своего.
+// 0 отключает дедлайн по умолчанию.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithRetry задает число попыток и границы экспоненциальной задержки между ними.
+// Повторяются только вызовы, завершившиеся с Unavailable или с ResourceExhausted
+// из-за лимита частоты; в последнем случае выдерживается задержка, указанная сервером.
+func WithRetry(attempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = max(attempts, 1)
+		o.retryBase = baseDelay
+		o.retryMax = maxDelay
+	}
+}
+
+// WithChunkSize задает размер части при загрузке через сессии;
+// файлы не больше этого размера загружаются одним вызовом UploadFile.
+func WithChunkSize(n int) Option {
+	return func(o *options) { o.chunkSize = n }
+}
+
+// WithPageSize задает размер страницы для List.
+func WithPageSize(n int32) Option {
+	return func(o *options) { o.pageSize = n }
+}
+
+// WithTransportCredentials включает TLS или другие транспортные учетные данные.
+// По умолчанию соединение не шифруется.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) { o.transportCreds = creds }
+}
+
+// WithPerRPCCredentials добавляет учетные данные к каждому вызову.
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(o *options) { o.perRPCCreds = creds }
+}
+
+// WithToken передает токен в заголовке authorization: Bearer.
+// Без WithTransportCredentials токен передается по незашифрованному соединению.
+func WithToken(token string) Option {
+	return func(o *options) { o.token = token }
+}
+
+// WithDialOptions передает дополнительные параметры в grpc.NewClient.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOptions = append(o.dialOptions, opts...) }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		timeout:       defaultTimeout,
+		retryAttempts: defaultRetryAttempts,
+		retryBase:     defaultRetryBase,
+		retryMax:      defaultRetryMax,
+		chunkSize:     defaultChunkSize,
+		pageSize:      defaultPageSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.token != "" && o.perRPCCreds == nil {
+		o.perRPCCreds = tokenCredentials{token: o.token, secure: o.transportCreds != nil}
+	}
+	return o
+}
+
+// New создает соединение с сервером по адресу target.
+func New(target string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+
+	creds := o.transportCreds
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, o.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{rpc: pb.NewFileServiceClient(conn), conn: conn, opts: o}, nil
+}
+
+// NewFromConn использует уже открытое соединение; Close его не закрывает.
+// Транспортные параметры и WithDialOptions в этом случае не применяются.
+func NewFromConn(conn grpc.ClientConnInterface, opts ...Option) *Client {
+	return &Client{rpc: pb.NewFileServiceClient(conn), opts: newOptions(opts)}
+}
+
+// RPC возвращает сгенерированный клиент для вызовов, которых нет в Client.
+func (c *Client) RPC() pb.FileServiceClient {
+	return c.rpc
+}
+
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *Client) callOptions() []grpc.CallOption {
+	if c.opts.perRPCCreds == nil {
+		return nil
+	}
+	return []grpc.CallOption{grpc.PerRPCCredentials(c.opts.perRPCCreds)}
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
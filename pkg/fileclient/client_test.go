@@ -0,0 +1,223 @@
+package fileclient_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "app/api/proto"
+	"app/internal/api/file"
+	mock_file "app/internal/api/file/mocks"
+	"app/pkg/fileclient"
+	"app/pkg/logging"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// flakyInterceptor отклоняет первые failures[method] вызовов метода с Unavailable
+// и запоминает заголовки authorization.
+type flakyInterceptor struct {
+	mu       sync.Mutex
+	failures map[string]int
+	calls    map[string]int
+	auth     []string
+}
+
+func (f *flakyInterceptor) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	f.mu.Lock()
+	f.calls[info.FullMethod]++
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		f.auth = append(f.auth, md.Get("authorization")...)
+	}
+	fail := f.failures[info.FullMethod] > 0
+	if fail {
+		f.failures[info.FullMethod]--
+	}
+	f.mu.Unlock()
+
+	if fail {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return handler(ctx, req)
+}
+
+func startServer(t *testing.T, repo file.FileRepository, opts ...fileclient.Option) (*fileclient.Client, *flakyInterceptor) {
+	t.Helper()
+
+	flaky := &flakyInterceptor{failures: map[string]int{}, calls: map[string]int{}}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(flaky.intercept))
+	pb.RegisterFileServiceServer(srv, file.NewServer(logging.NewTestLogger(), repo))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	opts = append([]fileclient.Option{fileclient.WithRetry(3, time.Millisecond, 5*time.Millisecond)}, opts...)
+	return fileclient.NewFromConn(conn, opts...), flaky
+}
+
+// onlyReader скрывает Seek, чтобы проверить загрузку через временный файл.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestUploadSmallRetriesUnavailable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	repo.EXPECT().
+		CreateIdempotent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, fl *file.File, key, fingerprint string, ttl time.Duration) error {
+			assert.Equal(t, "a.txt", fl.Name)
+			assert.Equal(t, []byte("hello"), fl.Data)
+			assert.NotEmpty(t, key)
+			fl.ID = "id-1"
+			return nil
+		})
+
+	client, flaky := startServer(t, repo, fileclient.WithToken("secret"))
+	flaky.failures["/fileservice.FileService/UploadFile"] = 2
+
+	id, err := client.Upload(context.Background(), "a.txt", onlyReader{bytes.NewBufferString("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", id)
+	assert.Equal(t, 3, flaky.calls["/fileservice.FileService/UploadFile"])
+	assert.Contains(t, flaky.auth, "Bearer secret")
+}
+
+func TestUploadGivesUpAfterRetries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	client, flaky := startServer(t, repo)
+	flaky.failures["/fileservice.FileService/UploadFile"] = 10
+
+	_, err := client.Upload(context.Background(), "a.txt", bytes.NewReader([]byte("hello")))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, flaky.calls["/fileservice.FileService/UploadFile"])
+}
+
+func TestUploadQuotaExceededIsNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	repo.EXPECT().
+		CreateIdempotent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&file.QuotaExceededError{Usage: file.Usage{Owner: "default", Files: 2, MaxFiles: 1}})
+
+	client, _ := startServer(t, repo)
+	_, err := client.Upload(context.Background(), "a.txt", bytes.NewReader([]byte("hello")))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestUploadLargeUsesSession(t *testing.T) {
+	for name, wrap := range map[string]func([]byte) io.Reader{
+		"Seeker":    func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"NonSeeker": func(b []byte) io.Reader { return onlyReader{bytes.NewReader(b)} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			repo := mock_file.NewMockFileRepository(ctrl)
+
+			data := []byte("0123456789")
+			var received []byte
+
+			repo.EXPECT().
+				CreateUploadSession(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, session *file.UploadSession, ttl time.Duration) error {
+					assert.Equal(t, int64(len(data)), session.TotalSize)
+					session.ID = "session-1"
+					return nil
+				})
+			repo.EXPECT().
+				AppendUploadChunk(gomock.Any(), "session-1", gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, id string, offset int64, chunk []byte, ttl time.Duration) (file.UploadSession, error) {
+					assert.Equal(t, int64(len(received)), offset)
+					received = append(received, chunk...)
+					return file.UploadSession{ID: id, ReceivedSize: int64(len(received))}, nil
+				}).
+				Times(3)
+			repo.EXPECT().
+				CompleteUploadSession(gomock.Any(), "session-1").
+				Return(file.File{ID: "id-2"}, nil)
+
+			client, flaky := startServer(t, repo, fileclient.WithChunkSize(4))
+			flaky.failures["/fileservice.FileService/UploadChunk"] = 1
+
+			id, err := client.Upload(context.Background(), "big.bin", wrap(data))
+			require.NoError(t, err)
+			assert.Equal(t, "id-2", id)
+			assert.Equal(t, data, received)
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	repo.EXPECT().FindOne(gomock.Any(), "id-1").Return(file.File{ID: "id-1", Name: "a.txt", Data: []byte("hello"), Version: 2, Revision: 3}, nil)
+
+	client, _ := startServer(t, repo)
+
+	var buf bytes.Buffer
+	info, err := client.Download(context.Background(), "id-1", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", buf.String())
+	assert.Equal(t, "a.txt", info.Name)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, "3", info.ETag)
+}
+
+func TestDeleteNotFoundIsNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	repo.EXPECT().Delete(gomock.Any(), "missing", int64(0)).Return(nil, nil).Times(1)
+
+	client, _ := startServer(t, repo)
+
+	err := client.Delete(context.Background(), "missing", "")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestListIteratesPages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mock_file.NewMockFileRepository(ctrl)
+
+	ids := []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+	}
+	repo.EXPECT().FindPage(gomock.Any(), file.FileFilter{}, "", 3).Return([]file.FileInfo{{File: file.File{ID: ids[0]}}, {File: file.File{ID: ids[1]}}, {File: file.File{ID: ids[2]}}}, nil)
+	repo.EXPECT().FindPage(gomock.Any(), file.FileFilter{}, ids[1], 3).Return([]file.FileInfo{{File: file.File{ID: ids[2]}}}, nil)
+
+	client, _ := startServer(t, repo, fileclient.WithPageSize(2))
+
+	var got []string
+	for info, err := range client.List(context.Background()) {
+		require.NoError(t, err)
+		got = append(got, info.ID)
+	}
+	assert.Equal(t, ids, got)
+}
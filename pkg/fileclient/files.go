@@ -0,0 +1,299 @@
+package fileclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"time"
+
+	pb "app/api/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type FileInfo struct {
+	ID          string
+	Name        string
+	Size        int64
+	Version     int32
+	ETag        string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Tags        []string
+	Description string
+	Metadata    map[string]string
+	FolderID    string
+}
+
+// Upload загружает содержимое r под именем name и возвращает ID файла.
+// Небольшие файлы отправляются одним вызовом с ключом идемпотентности, поэтому
+// повтор не создает дубликат. Файлы больше размера части загружаются через сессию;
+// если r не поддерживает Seek, содержимое сначала копируется во временный файл.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader) (string, error) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		head := make([]byte, c.opts.chunkSize+1)
+		n, err := io.ReadFull(r, head)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return c.uploadSmall(ctx, name, head[:n])
+		}
+		if err != nil {
+			return "", err
+		}
+
+		tmp, err := os.CreateTemp("", "fileclient-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err = io.Copy(tmp, io.MultiReader(bytes.NewReader(head), r)); err != nil {
+			return "", err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		rs = tmp
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, rs)
+	if err != nil {
+		return "", err
+	}
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if size <= int64(c.opts.chunkSize) {
+		data, err := io.ReadAll(rs)
+		if err != nil {
+			return "", err
+		}
+		return c.uploadSmall(ctx, name, data)
+	}
+
+	var readerAt io.ReaderAt
+	if ra, ok := rs.(io.ReaderAt); ok {
+		readerAt = ra
+	} else {
+		readerAt = &seekReaderAt{rs: rs}
+	}
+	return c.uploadSession(ctx, name, io.NewSectionReader(readerAt, start, size), hex.EncodeToString(hash.Sum(nil)))
+}
+
+func (c *Client) uploadSmall(ctx context.Context, name string, data []byte) (string, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return "", err
+	}
+
+	var res *pb.UploadFileResponse
+	err = c.invoke(ctx, func(ctx context.Context) (err error) {
+		res, err = c.rpc.UploadFile(ctx, &pb.UploadFileRequest{FileName: name, Data: data, IdempotencyKey: key}, c.callOptions()...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+func (c *Client) uploadSession(ctx context.Context, name string, src *io.SectionReader, checksum string) (string, error) {
+	var session *pb.CreateUploadSessionResponse
+	err := c.invoke(ctx, func(ctx context.Context) (err error) {
+		session, err = c.rpc.CreateUploadSession(ctx, &pb.CreateUploadSessionRequest{FileName: name, TotalSize: src.Size(), Sha256: checksum}, c.callOptions()...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	chunk := make([]byte, c.opts.chunkSize)
+	for offset := int64(0); offset < src.Size(); {
+		n, err := src.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		err = c.invoke(ctx, func(ctx context.Context) error {
+			_, err := c.rpc.UploadChunk(ctx, &pb.UploadChunkRequest{SessionId: session.SessionId, Offset: offset, Data: chunk[:n]}, c.callOptions()...)
+			return err
+		})
+		if status.Code(err) == codes.FailedPrecondition {
+			// часть могла дойти до сервера, хотя ответ потерялся: продолжаем с того места,
+			// где сервер остановился
+			received, statusErr := c.receivedSize(ctx, session.SessionId)
+			if statusErr == nil && received > offset {
+				offset = received
+				continue
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		offset += int64(n)
+	}
+
+	var res *pb.CompleteUploadSessionResponse
+	err = c.invoke(ctx, func(ctx context.Context) (err error) {
+		res, err = c.rpc.CompleteUploadSession(ctx, &pb.CompleteUploadSessionRequest{SessionId: session.SessionId}, c.callOptions()...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+func (c *Client) receivedSize(ctx context.Context, sessionID string) (int64, error) {
+	var res *pb.GetUploadSessionStatusResponse
+	err := c.invoke(ctx, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetUploadSessionStatus(ctx, &pb.GetUploadSessionStatusRequest{SessionId: sessionID}, c.callOptions()...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.ReceivedSize, nil
+}
+
+// Download записывает текущую версию файла в w и возвращает его метаданные.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (*FileInfo, error) {
+	var res *pb.DownloadFileResponse
+	err := c.invoke(ctx, func(ctx context.Context) (err error) {
+		res, err = c.rpc.DownloadFile(ctx, &pb.DownloadFileRequest{Id: id}, c.callOptions()...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(res.Data); err != nil {
+		return nil, err
+	}
+
+	return &FileInfo{
+		ID:        id,
+		Name:      res.FileName,
+		Size:      int64(len(res.Data)),
+		Version:   res.Version,
+		ETag:      res.Etag,
+		CreatedAt: time.Unix(res.CreatedAt, 0),
+		UpdatedAt: time.Unix(res.UpdatedAt, 0),
+	}, nil
+}
+
+// Delete удаляет файл; непустой ifMatch требует совпадения etag.
+func (c *Client) Delete(ctx context.Context, id, ifMatch string) error {
+	return c.invoke(ctx, func(ctx context.Context) error {
+		_, err := c.rpc.DeleteFile(ctx, &pb.DeleteFileRequest{Id: id, IfMatch: ifMatch}, c.callOptions()...)
+		return err
+	})
+}
+
+// List постранично обходит все файлы. Ошибка возвращается последним элементом,
+// после чего обход прекращается.
+func (c *Client) List(ctx context.Context) iter.Seq2[*FileInfo, error] {
+	return func(yield func(*FileInfo, error) bool) {
+		var token string
+		for {
+			var res *pb.ListFilesResponse
+			err := c.invoke(ctx, func(ctx context.Context) (err error) {
+				res, err = c.rpc.ListFiles(ctx, &pb.ListFilesRequest{PageSize: c.opts.pageSize, PageToken: token}, c.callOptions()...)
+				return err
+			})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, f := range res.Files {
+				info := &FileInfo{
+					ID:          f.Id,
+					Name:        f.Name,
+					Size:        f.Size,
+					ETag:        f.Etag,
+					CreatedAt:   time.Unix(f.CreatedAt, 0),
+					UpdatedAt:   time.Unix(f.UpdatedAt, 0),
+					Tags:        f.Tags,
+					Description: f.Description,
+					Metadata:    f.Metadata,
+					FolderID:    f.FolderId,
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+
+			if res.NextPageToken == "" {
+				return
+			}
+			token = res.NextPageToken
+		}
+	}
+}
+
+// DownloadArchive записывает в w архив с указанными файлами. Вызов повторяется,
+// только пока в w ничего не записано. Дедлайн по умолчанию действует на весь поток,
+// для больших архивов лучше передать ctx со своим дедлайном.
+func (c *Client) DownloadArchive(ctx context.Context, req *pb.DownloadArchiveRequest, w io.Writer) error {
+	return c.invoke(ctx, func(ctx context.Context) error {
+		stream, err := c.rpc.DownloadArchive(ctx, req, c.callOptions()...)
+		if err != nil {
+			return err
+		}
+
+		var written int64
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				if written > 0 {
+					return permanentError{err}
+				}
+				return err
+			}
+
+			n, err := w.Write(res.Data)
+			written += int64(n)
+			if err != nil {
+				return permanentError{err}
+			}
+		}
+	})
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate idempotency key: %w", err)
+	}
+	return "fileclient-" + hex.EncodeToString(b), nil
+}
+
+// seekReaderAt реализует ReaderAt поверх ReadSeeker для последовательного чтения частей.
+type seekReaderAt struct {
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.rs, p)
+}
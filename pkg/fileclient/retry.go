@@ -0,0 +1,87 @@
+package fileclient
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// permanentError помечает ошибку, после которой повтор невозможен,
+// например если часть потока уже записана получателю.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// retryable также возвращает задержку, запрошенную сервером через RetryInfo.
+// ResourceExhausted без RetryInfo (превышение квоты) не повторяется.
+func retryable(err error) (time.Duration, bool) {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return 0, false
+	}
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unavailable:
+		return 0, true
+	case codes.ResourceExhausted:
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				return info.RetryDelay.AsDuration(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// invoke вызывает fn, повторяя временные ошибки с экспоненциальной задержкой
+// и случайным разбросом. Каждая попытка получает дедлайн по умолчанию,
+// если у ctx нет своего.
+func (c *Client) invoke(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := c.opts.retryBase
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := c.withTimeout(ctx)
+		err := fn(callCtx)
+		cancel()
+
+		retryAfter, ok := retryable(err)
+		if err == nil || attempt >= c.opts.retryAttempts || !ok {
+			var permanent permanentError
+			if errors.As(err, &permanent) {
+				return permanent.err
+			}
+			return err
+		}
+
+		timer := time.NewTimer(max(jitter(delay), retryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay = min(delay*2, c.opts.retryMax)
+	}
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.opts.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.timeout)
+}
+
+// jitter возвращает случайную задержку в диапазоне [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
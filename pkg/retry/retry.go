@@ -0,0 +1,106 @@
+// Package retry - повтор операций с экспоненциальной задержкой и случайным разбросом.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy задает, сколько и как долго повторять операцию. Нулевые MaxAttempts
+// и MaxElapsed не ограничивают повторы; хотя бы одно из них стоит задать.
+type Policy struct {
+	MaxAttempts int
+	// MaxElapsed ограничивает общее время: попытка, которая началась бы позже, не делается
+	MaxElapsed time.Duration
+	// задержка перед n-м повтором выбирается случайно из [0, min(MaxDelay, BaseDelay*2^(n-1))]
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Retryable решает, стоит ли повторять после ошибки; nil - повторять любые,
+	// кроме помеченных Permanent
+	Retryable func(error) bool
+	// OnRetry вызывается перед ожиданием очередного повтора
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent помечает ошибку как неповторяемую независимо от Retryable.
+// Do возвращает исходную ошибку без обертки.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// для тестов
+var (
+	now    = time.Now
+	after  = time.After
+	jitter = func(d time.Duration) time.Duration {
+		return rand.N(d + 1)
+	}
+)
+
+// Do вызывает fn, пока она не завершится успешно, ошибка не окажется неповторяемой
+// или не кончатся попытки. Возвращает последнюю ошибку fn; при отмене ctx во время
+// ожидания - ее же вместе с ошибкой ctx.
+func Do(ctx context.Context, p Policy, fn func(ctx context.Context) error) error {
+	start := now()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if (p.Retryable != nil && !p.Retryable(err)) || ctx.Err() != nil {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		if p.MaxElapsed > 0 && now().Add(delay).Sub(start) > p.MaxElapsed {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-after(delay):
+		}
+	}
+}
+
+func (p Policy) delay(attempt int) time.Duration {
+	limit := p.MaxDelay
+	if limit <= 0 {
+		limit = math.MaxInt64 / 2
+	}
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if d <= 0 {
+		return 0
+	}
+	return jitter(d)
+}
@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTime подменяет часы и ожидание: ожидание мгновенно сдвигает часы.
+type fakeTime struct {
+	t      time.Time
+	delays []time.Duration
+}
+
+func useFakeTime(t *testing.T) *fakeTime {
+	ft := &fakeTime{t: time.Unix(1_700_000_000, 0)}
+	prevNow, prevAfter, prevJitter := now, after, jitter
+	now = func() time.Time { return ft.t }
+	after = func(d time.Duration) <-chan time.Time {
+		ft.delays = append(ft.delays, d)
+		ft.t = ft.t.Add(d)
+		ch := make(chan time.Time, 1)
+		ch <- ft.t
+		return ch
+	}
+	// без разброса задержки детерминированы
+	jitter = func(d time.Duration) time.Duration { return d }
+	t.Cleanup(func() { now, after, jitter = prevNow, prevAfter, prevJitter })
+	return ft
+}
+
+var errTemporary = errors.New("temporary")
+
+func failing(n int, err error) (func(context.Context) error, *int) {
+	calls := 0
+	return func(context.Context) error {
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Backoff", func(t *testing.T) {
+		ft := useFakeTime(t)
+		fn, calls := failing(4, errTemporary)
+
+		var attempts []int
+		err := Do(ctx, Policy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, OnRetry: func(attempt int, err error, delay time.Duration) {
+			attempts = append(attempts, attempt)
+		}}, fn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 5, *calls)
+		assert.Equal(t, []int{1, 2, 3, 4}, attempts)
+		assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}, ft.delays)
+	})
+
+	t.Run("NoSleepAfterLastAttempt", func(t *testing.T) {
+		ft := useFakeTime(t)
+		fn, calls := failing(10, errTemporary)
+
+		err := Do(ctx, Policy{MaxAttempts: 3, BaseDelay: time.Second}, fn)
+		assert.ErrorIs(t, err, errTemporary)
+		assert.Equal(t, 3, *calls)
+		assert.Len(t, ft.delays, 2)
+	})
+
+	t.Run("MaxElapsed", func(t *testing.T) {
+		useFakeTime(t)
+		fn, calls := failing(10, errTemporary)
+
+		// 1s + 2s укладываются в 5s, следующая задержка 4s уже нет
+		err := Do(ctx, Policy{MaxElapsed: 5 * time.Second, BaseDelay: time.Second}, fn)
+		assert.ErrorIs(t, err, errTemporary)
+		assert.Equal(t, 3, *calls)
+	})
+
+	t.Run("Classifier", func(t *testing.T) {
+		useFakeTime(t)
+		errAuth := errors.New("password authentication failed")
+		fn, calls := failing(10, errAuth)
+
+		err := Do(ctx, Policy{MaxAttempts: 5, Retryable: func(err error) bool { return !errors.Is(err, errAuth) }}, fn)
+		assert.ErrorIs(t, err, errAuth)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		useFakeTime(t)
+		fn, calls := failing(10, Permanent(errTemporary))
+
+		err := Do(ctx, Policy{MaxAttempts: 5}, fn)
+		assert.Equal(t, errTemporary, err)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		prevJitter := jitter
+		jitter = func(d time.Duration) time.Duration { return d }
+		t.Cleanup(func() { jitter = prevJitter })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		fn, calls := failing(10, errTemporary)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		err := Do(ctx, Policy{BaseDelay: time.Hour}, fn)
+		assert.ErrorIs(t, err, errTemporary)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, *calls)
+	})
+}
@@ -1,17 +0,0 @@
-package utils
-
-import (
-	"time"
-)
-
-func DoWithTries(fn func() error, attemtps int, delay time.Duration) (err error) {
-	for attemtps > 0 {
-		if err = fn(); err != nil {
-			time.Sleep(delay)
-			attemtps--
-			continue
-		}
-		return nil
-	}
-	return
-}